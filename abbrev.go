@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Journal title styles for the BibTeX journal field
+var journalStyles = map[string]bool{
+	"full": true, // the title as given in the publication metadata
+	"iso4": true, // abbreviated per ISO 4, e.g. "Nat. Commun."
+	"nlm":  true, // the NLM catalog style: ISO 4 without periods, e.g. "Nat Commun"
+}
+
+// Words from the ISSN List of Title Word Abbreviations (LTWA) that are
+// common in journal titles. Keys ending in "-" match any word starting with
+// that stem; other keys match whole words. Words not listed here are left
+// unabbreviated, so pass --ltwa with the full list for complete coverage.
+var ltwa = map[string]string{
+	"academ-":       "acad.",
+	"account-":      "account.",
+	"advanc-":       "adv.",
+	"agricult-":     "agric.",
+	"american":      "am.",
+	"analy-":        "anal.",
+	"animal":        "anim.",
+	"annals":        "ann.",
+	"applied":       "appl.",
+	"archives":      "arch.",
+	"association":   "assoc.",
+	"astronom-":     "astron.",
+	"astrophys-":    "astrophys.",
+	"behavio-":      "behav.",
+	"biochem-":      "biochem.",
+	"biolog-":       "biol.",
+	"biomedic-":     "biomed.",
+	"british":       "br.",
+	"bulletin":      "bull.",
+	"business":      "bus.",
+	"cellul-":       "cell.",
+	"chemi-":        "chem.",
+	"clinic-":       "clin.",
+	"communic-":     "commun.",
+	"comput-":       "comput.",
+	"conference":    "conf.",
+	"dental":        "dent.",
+	"dentistry":     "dent.",
+	"develop-":      "dev.",
+	"ecolog-":       "ecol.",
+	"econom-":       "econ.",
+	"educat-":       "educ.",
+	"electr-":       "electr.",
+	"engineer-":     "eng.",
+	"environment-":  "environ.",
+	"european":      "eur.",
+	"evolution-":    "evol.",
+	"experiment-":   "exp.",
+	"financ-":       "financ.",
+	"genet-":        "genet.",
+	"geolog-":       "geol.",
+	"geophys-":      "geophys.",
+	"histor-":       "hist.",
+	"immunol-":      "immunol.",
+	"industr-":      "ind.",
+	"informat-":     "inf.",
+	"institut-":     "inst.",
+	"international": "int.",
+	"journal":       "j.",
+	"language":      "lang.",
+	"letters":       "lett.",
+	"linguist-":     "linguist.",
+	"manag-":        "manag.",
+	"marketing":     "mark.",
+	"material-":     "mater.",
+	"mathemat-":     "math.",
+	"mechanic-":     "mech.",
+	"medic-":        "med.",
+	"microbiol-":    "microbiol.",
+	"molecul-":      "mol.",
+	"national":      "natl.",
+	"natur-":        "nat.",
+	"neurosci-":     "neurosci.",
+	"oncolog-":      "oncol.",
+	"operat-":       "oper.",
+	"organi-":       "organ.",
+	"pharmacol-":    "pharmacol.",
+	"philosoph-":    "philos.",
+	"physic-":       "phys.",
+	"physiol-":      "physiol.",
+	"politic-":      "polit.",
+	"proceedings":   "proc.",
+	"psycholog-":    "psychol.",
+	"quarterly":     "q.",
+	"research":      "res.",
+	"resour-":       "resour.",
+	"review-":       "rev.",
+	"scien-":        "sci.",
+	"societ-":       "soc.",
+	"sociolog-":     "sociol.",
+	"statist-":      "stat.",
+	"studies":       "stud.",
+	"surg-":         "surg.",
+	"system-":       "syst.",
+	"technolog-":    "technol.",
+	"theoretical":   "theor.",
+	"transactions":  "trans.",
+	"veterinar-":    "vet.",
+}
+
+// Articles, prepositions and conjunctions, which ISO 4 omits from
+// abbreviated titles
+var titleStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "at": true, "by": true, "for": true,
+	"from": true, "in": true, "of": true, "on": true, "the": true, "to": true,
+	"with": true, "&": true, "de": true, "des": true, "du": true, "la": true,
+	"le": true, "les": true, "et": true, "der": true, "die": true, "das": true,
+	"und": true, "für": true,
+}
+
+// Load additional LTWA entries from a file with one "word;abbreviation"
+// (or tab-separated) pair per line, as in the list published by the ISSN
+// International Centre. Further columns are ignored, and an abbreviation
+// of "n.a." marks a word that is never abbreviated.
+func loadLTWA(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening LTWA file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ';' || r == '\t'
+		})
+		if len(fields) < 2 {
+			continue
+		}
+		word := strings.ToLower(strings.TrimSpace(fields[0]))
+		abbreviation := strings.ToLower(strings.TrimSpace(fields[1]))
+		if word == "" || word == "word" || word == "words" {
+			continue
+		}
+		if abbreviation == "n.a." || abbreviation == "n. a." {
+			abbreviation = strings.TrimSuffix(word, "-")
+		}
+		ltwa[word] = abbreviation
+	}
+	return scanner.Err()
+}
+
+// The LTWA abbreviation of a single lowercase word, or "" if it has none.
+// Whole-word entries win over stems, and longer stems over shorter ones.
+func abbreviateWord(word string) string {
+	if abbreviation, ok := ltwa[word]; ok {
+		return abbreviation
+	}
+	for i := len(word); i > 0; i-- {
+		if abbreviation, ok := ltwa[word[:i]+"-"]; ok {
+			return abbreviation
+		}
+	}
+	return ""
+}
+
+// Abbreviate a journal title in the given style. Single-word titles are
+// never abbreviated, stop words are dropped (except at the start and the
+// end), and words not in the LTWA are kept as they are, as are section
+// letters like the A of "Journal of Physical Chemistry A".
+func abbreviateJournalTitle(title, style string) string {
+	if style != "iso4" && style != "nlm" {
+		return title
+	}
+	words := strings.Fields(title)
+	if len(words) < 2 {
+		return title
+	}
+
+	var out []string
+	for i, word := range words {
+		trimmed := strings.TrimFunc(word, func(r rune) bool {
+			return unicode.IsPunct(r) && r != '&'
+		})
+		if trimmed == "" {
+			continue
+		}
+		if len(trimmed) == 1 && unicode.IsUpper(rune(trimmed[0])) {
+			// A section letter, such as the A of "Physical Review A"
+			out = append(out, trimmed)
+			continue
+		}
+		lower := strings.ToLower(trimmed)
+		if titleStopWords[lower] && i > 0 && i < len(words)-1 {
+			continue
+		}
+
+		abbreviation := abbreviateWord(lower)
+		if abbreviation == "" || len(abbreviation) >= len(trimmed) {
+			out = append(out, trimmed)
+			continue
+		}
+		// Capitalize like the original word
+		runes := []rune(abbreviation)
+		if unicode.IsUpper([]rune(trimmed)[0]) {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+		out = append(out, string(runes))
+	}
+
+	abbreviated := strings.Join(out, " ")
+	if style == "nlm" {
+		abbreviated = strings.ReplaceAll(abbreviated, ".", "")
+	}
+	return abbreviated
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches an arXiv identifier in the current form (2101.00001, with an
+// optional version) or the old form (hep-th/9901001, math.GT/0309136)
+var arxivPattern = regexp.MustCompile(`(?i)(\d{4}\.\d{4,5}|[a-z]+(?:-[a-z]+)?(?:\.[A-Z]{2})?/\d{7})(v\d+)?`)
+
+// Matches where arXiv identifiers turn up in other metadata: arXiv's own
+// DOIs and links to the abstract or PDF
+var arxivLocationPattern = regexp.MustCompile(`(?i)^(?:10\.48550/arxiv\.|https?://(?:www\.|export\.)?arxiv\.org/(?:abs|pdf)/|arxiv:)`)
+
+// Extract the bare arXiv identifier from a reference to it, or return ""
+func normalizeArXivID(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if loc := arxivLocationPattern.FindStringIndex(ref); loc != nil {
+		ref = ref[loc[1]:]
+	}
+	id := arxivPattern.FindString(strings.TrimSuffix(ref, ".pdf"))
+	if id == "" || !strings.HasPrefix(strings.ToLower(ref), strings.ToLower(id)) {
+		return ""
+	}
+	return id
+}
+
+// The publication's arXiv identifier, from its metadata or its DOI or URL
+// when they point at arXiv, or "" if it has none
+func arxivID(pub Publication) string {
+	if id := normalizeArXivID(pub.ArXiv); id != "" {
+		return id
+	}
+	for _, ref := range []string{pub.DOI, pub.URL} {
+		if arxivLocationPattern.MatchString(strings.TrimSpace(ref)) {
+			if id := normalizeArXivID(ref); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// COAR resource type of preprints, as used in CERIF metadata
+const coarPreprint = "http://purl.org/coar/resource_type/c_816b"
+
+// Whether the publication is a preprint that hasn't appeared in a journal,
+// so there are no journal metrics to look up
+func isUnpublishedPreprint(pub Publication) bool {
+	if pub.ISSN != "" || pub.Published.Publication.Title != "" {
+		return false
+	}
+	return arxivID(pub) != "" || pub.Type == "Preprint" || pub.Type == coarPreprint
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Names of ASJC subject categories by code. The 27 subject areas (codes
+// ending in 00) are built in; --asjc-file adds the individual categories.
+var asjcNames = map[int64]string{
+	1000: "Multidisciplinary",
+	1100: "Agricultural and Biological Sciences",
+	1200: "Arts and Humanities",
+	1300: "Biochemistry, Genetics and Molecular Biology",
+	1400: "Business, Management and Accounting",
+	1500: "Chemical Engineering",
+	1600: "Chemistry",
+	1700: "Computer Science",
+	1800: "Decision Sciences",
+	1900: "Earth and Planetary Sciences",
+	2000: "Economics, Econometrics and Finance",
+	2100: "Energy",
+	2200: "Engineering",
+	2300: "Environmental Science",
+	2400: "Immunology and Microbiology",
+	2500: "Materials Science",
+	2600: "Mathematics",
+	2700: "Medicine",
+	2800: "Neuroscience",
+	2900: "Nursing",
+	3000: "Pharmacology, Toxicology and Pharmaceutics",
+	3100: "Physics and Astronomy",
+	3200: "Psychology",
+	3300: "Social Sciences",
+	3400: "Veterinary",
+	3500: "Dentistry",
+	3600: "Health Professions",
+}
+
+// Load ASJC category names from a CSV file of "code,description" rows, such
+// as the ASJC code list Elsevier publishes for Scopus. Rows whose first
+// column isn't a code, like a header, are skipped.
+func loadASJC(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening ASJC file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("error reading ASJC file: %v", err)
+	}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		code, err := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64)
+		if err != nil {
+			continue
+		}
+		if name := strings.TrimSpace(record[1]); name != "" {
+			asjcNames[code] = name
+		}
+	}
+	return nil
+}
+
+// The name of an ASJC category, falling back to the name of its subject
+// area, or "" if neither is known
+func asjcName(code int64) string {
+	if name, ok := asjcNames[code]; ok {
+		return name
+	}
+	return asjcNames[code/100*100]
+}
+
+// The names of the journal's subject categories
+func subjectKeywords(metrics JournalMetrics) []string {
+	var names []string
+	for _, code := range metrics.FieldCodes() {
+		if name := asjcName(code); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The prefix of the feed's tag: URIs (RFC 4151). The year is the date the
+// tag was minted and must stay fixed, or every id in the feed changes.
+const atomTagPrefix = "tag:impact-factor-lookup,2024:"
+
+// The start of an Atom feed of the publications, updated at the date of
+// the newest one, so the feed only changes when its entries do
+func atomFeedHeader(pubs []Publication) string {
+	updated := atomDate("")
+	for _, pub := range pubs {
+		updated = max(updated, atomDate(pub.Date))
+	}
+	return `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <id>` + atomTagPrefix + `publications</id>
+  <title>Publications</title>
+  <updated>` + updated + `</updated>
+  <generator uri="https://github.com/kljensen/impact-factor-lookup">impact-factor-lookup</generator>
+`
+}
+
+const atomFeedFooter = "</feed>\n"
+
+// Sort publications newest first, as feed readers expect, keeping the
+// order of those published on the same date
+func sortNewestFirst(pubs []Publication) {
+	sort.SliceStable(pubs, func(i, j int) bool {
+		return atomDate(pubs[i].Date) > atomDate(pubs[j].Date)
+	})
+}
+
+// The publication date as an Atom timestamp, filling in the first month
+// or day when the date only has a year or month. Atom requires a date, so
+// undated publications get the Unix epoch.
+func atomDate(date string) string {
+	for _, layout := range []string{"2006-01-02", "2006-01"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	if len(date) >= 4 {
+		if t, err := time.Parse("2006", date[:4]); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return "1970-01-01T00:00:00Z"
+}
+
+// A stable, unique id for a publication's entry: its DOI link, else a tag
+// URI with its repository ID, else its URL. Citation keys can collide
+// ("Jensen2021" twice), so publications with none of these get a tag URI
+// with a hash of their title, date and journal.
+func atomEntryID(pub Publication) string {
+	switch {
+	case pub.DOI != "":
+		return doiURL(pub.DOI)
+	case pub.ID != "":
+		return atomTagPrefix + "publication/" + pub.ID
+	case pub.URL != "":
+		return pub.URL
+	}
+	sum := sha256.Sum256([]byte(pub.Title + "\x00" + pub.Date + "\x00" + pub.Published.Publication.Title))
+	return fmt.Sprintf("%spublication/%x", atomTagPrefix, sum[:8])
+}
+
+// Convert a publication to an Atom feed entry, linking to its DOI and
+// summarizing where it was published and the journal's SJR and quartile
+func toAtomEntry(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+	var entry strings.Builder
+	line := func(format string, args ...any) {
+		entry.WriteString("  ")
+		fmt.Fprintf(&entry, format, args...)
+		entry.WriteString("\n")
+	}
+
+	link := pub.URL
+	if pub.DOI != "" {
+		link = doiURL(pub.DOI)
+	}
+
+	line("<entry>")
+	line("  <id>%s</id>", xmlEscape(atomEntryID(pub)))
+	line("  <title>%s</title>", xmlEscape(displayTitle(pub, opts)))
+	if link != "" {
+		line(`  <link rel="alternate" href="%s"/>`, xmlEscape(link))
+	}
+	line("  <updated>%s</updated>", atomDate(pub.Date))
+	listed, cut := listedAuthors(pub.Authors.AuthorList, opts)
+	for _, author := range listed {
+		family := author.Person.PersonName.FamilyNames
+		given := author.Person.PersonName.FirstNames
+		if opts.NormalizeAuthors && !author.Person.Organization {
+			family, given = normalizePersonName(family, given)
+		}
+		if opts.AuthorStyle == "initials" && !author.Person.Organization {
+			given = initials(given)
+		}
+		line("  <author><name>%s</name></author>", xmlEscape(strings.TrimSpace(given+" "+family)))
+	}
+	if cut {
+		line("  <author><name>et al.</name></author>")
+	}
+
+	var summary []string
+	if journal := pub.Published.Publication.Title; journal != "" {
+		summary = append(summary, abbreviateJournalTitle(journal, opts.JournalStyle))
+	}
+	if label := forthcomingLabel(pub); label != "" {
+		summary = append(summary, label)
+	} else if len(pub.Date) >= 4 {
+		summary = append(summary, pub.Date[:4])
+	}
+	if metrics != nil && metrics.SJR != nil {
+		sjr := "SJR " + formatOptional(metrics.SJR, opts.MetricPrecision)
+		if q := formatQuartile(metrics.Quartile); q != "" {
+			sjr += " (" + q + ")"
+		}
+		summary = append(summary, sjr)
+	}
+	if metrics != nil && metrics.CiteScore != nil {
+		summary = append(summary, "CiteScore "+formatOptional(metrics.CiteScore, opts.MetricPrecision))
+	}
+	if metrics != nil && metrics.SNIP != nil {
+		summary = append(summary, "SNIP "+formatOptional(metrics.SNIP, opts.MetricPrecision))
+	}
+	if len(summary) > 0 {
+		line("  <summary>%s</summary>", xmlEscape(strings.Join(summary, ", ")))
+	}
+	if opts.Abstracts && pub.Abstract != "" {
+		line("  <content type=\"text\">%s</content>", xmlEscape(strings.Join(strings.Fields(pub.Abstract), " ")))
+	}
+	line("</entry>")
+	return entry.String(), nil
+}
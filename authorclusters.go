@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// One way an author's name is written in the metadata, with the ORCID iD
+// given with it
+type nameVariant struct {
+	family string // as normalizePersonName leaves it
+	given  string
+	orcid  string
+}
+
+// The name variant of an author, or false for organizations and authors
+// without a family name
+func authorVariant(author Author) (nameVariant, bool) {
+	if author.Person.Organization {
+		return nameVariant{}, false
+	}
+	family, given := normalizePersonName(author.Person.PersonName.FamilyNames, author.Person.PersonName.FirstNames)
+	if family == "" {
+		return nameVariant{}, false
+	}
+	return nameVariant{family: family, given: given, orcid: normalizeORCID(author.Person.ORCID)}, true
+}
+
+// The given names as lowercase words, with initials as single letters:
+// "Kyle L." -> ["kyle" "l"], "J.-M." -> ["j" "m"]
+func (v nameVariant) givenWords() []string {
+	return strings.FieldsFunc(strings.ToLower(v.given), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '.' || r == '-'
+	})
+}
+
+// How fully the name is written: the number of given names, then how many
+// of them are spelled out rather than initials
+func (v nameVariant) fullness() (int, int) {
+	words := v.givenWords()
+	spelled := 0
+	for _, word := range words {
+		if len([]rune(word)) > 1 {
+			spelled++
+		}
+	}
+	return len(words), spelled
+}
+
+// Whether the variants may name the same person: variants with ORCID iDs
+// do when the iDs are equal, and otherwise the family names must be equal
+// and the given names of one must start with those of the other, where an
+// initial matches any name it is the initial of. "Jensen, K." is
+// compatible with "Jensen, Kyle L." but not with "Jensen, Karen".
+func (v nameVariant) compatible(w nameVariant) bool {
+	if v.orcid != "" && w.orcid != "" {
+		return v.orcid == w.orcid
+	}
+	if !strings.EqualFold(v.family, w.family) {
+		return false
+	}
+	a, b := v.givenWords(), w.givenWords()
+	for i := 0; i < min(len(a), len(b)); i++ {
+		ra, rb := []rune(a[i]), []rune(b[i])
+		if ra[0] != rb[0] {
+			return false
+		}
+		if len(ra) > 1 && len(rb) > 1 && a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The name as "Family, Given"
+func (v nameVariant) String() string {
+	if v.given == "" {
+		return v.family
+	}
+	return v.family + ", " + v.given
+}
+
+// The name variants taken to be one person
+type authorCluster struct {
+	name     nameVariant // the variant with the most given names spelled out
+	orcid    string
+	variants []nameVariant
+}
+
+// The person's name as "Given Family"
+func (c *authorCluster) displayName() string {
+	return strings.TrimSpace(c.name.given + " " + c.name.family)
+}
+
+// Whether a variant without an ORCID iD may belong with the cluster: it
+// must be compatible with all the cluster's variants of its family name,
+// of which there must be one
+func (c *authorCluster) accepts(v nameVariant) bool {
+	matched := false
+	for _, member := range c.variants {
+		if !strings.EqualFold(member.family, v.family) {
+			continue
+		}
+		if !member.compatible(v) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// The authors of a set of publications, with the variant spellings of
+// each person's name clustered together
+type authorClusters struct {
+	clusters  []*authorCluster
+	byVariant map[nameVariant]*authorCluster
+}
+
+// Cluster the name variants of the publications' authors. Variants with
+// the same ORCID iD are one person, whatever their spelling. The other
+// variants are taken most fully written first, and each joins the one
+// cluster it is compatible with; a variant compatible with several, such
+// as "Jensen, K." beside both "Jensen, Kyle" and "Jensen, Karen", is kept
+// apart rather than guessed at. With confirmORCID, that cluster must also
+// have an ORCID iD, so that without one only identical spellings are
+// merged.
+func clusterAuthors(pubs []Publication, confirmORCID bool) *authorClusters {
+	counts := map[nameVariant]int{}
+	var variants []nameVariant
+	for _, pub := range pubs {
+		for _, author := range pub.Authors.AuthorList {
+			v, ok := authorVariant(author)
+			if !ok {
+				continue
+			}
+			if counts[v] == 0 {
+				variants = append(variants, v)
+			}
+			counts[v]++
+		}
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		if (variants[i].orcid != "") != (variants[j].orcid != "") {
+			return variants[i].orcid != ""
+		}
+		iWords, iSpelled := variants[i].fullness()
+		jWords, jSpelled := variants[j].fullness()
+		if iWords != jWords {
+			return iWords > jWords
+		}
+		if iSpelled != jSpelled {
+			return iSpelled > jSpelled
+		}
+		return counts[variants[i]] > counts[variants[j]]
+	})
+
+	c := &authorClusters{byVariant: map[nameVariant]*authorCluster{}}
+	byORCID := map[string]*authorCluster{}
+	for _, v := range variants {
+		var home *authorCluster
+		if v.orcid != "" {
+			home = byORCID[v.orcid]
+		} else {
+			var candidates []*authorCluster
+			for _, cluster := range c.clusters {
+				if cluster.accepts(v) {
+					candidates = append(candidates, cluster)
+				}
+			}
+			if len(candidates) == 1 && (!confirmORCID || candidates[0].orcid != "") {
+				home = candidates[0]
+			}
+		}
+		if home == nil {
+			home = &authorCluster{name: v, orcid: v.orcid}
+			c.clusters = append(c.clusters, home)
+			if v.orcid != "" {
+				byORCID[v.orcid] = home
+			}
+		}
+		home.variants = append(home.variants, v)
+		_, spelled := v.fullness()
+		if _, nameSpelled := home.name.fullness(); spelled > nameSpelled {
+			home.name = v
+		}
+		c.byVariant[v] = home
+	}
+	return c
+}
+
+// The cluster of an author, or nil for organizations and authors without
+// a family name
+func (c *authorClusters) of(author Author) *authorCluster {
+	v, ok := authorVariant(author)
+	if !ok {
+		return nil
+	}
+	return c.byVariant[v]
+}
+
+// The publications of one author, with their metrics summary
+type AuthorReport struct {
+	Author   string   `json:"author"`
+	ORCID    string   `json:"orcid,omitempty"`
+	Variants []string `json:"variants"` // the spellings of the author's name, as "Family, Given"
+	MetricsSummary
+	Corresponding int `json:"corresponding"` // publications the author is marked as a corresponding author of
+}
+
+// Group the publications by author, with the variant spellings of each
+// person's name clustered as by clusterAuthors, and summarize each group.
+// A publication counts once towards each of its authors. Authors are
+// ordered by number of publications, most first, and then by name.
+func buildAuthorReports(pubs []Publication, db *MetricsDatabase, confirmORCID bool) []AuthorReport {
+	clusters := clusterAuthors(pubs, confirmORCID)
+	groups := map[*authorCluster][]Publication{}
+	correspondence := map[*authorCluster]int{}
+	for _, pub := range pubs {
+		seen := map[*authorCluster]bool{}
+		correspondent := map[*authorCluster]bool{}
+		for _, author := range pub.Authors.AuthorList {
+			cluster := clusters.of(author)
+			if cluster == nil {
+				continue
+			}
+			if !seen[cluster] {
+				seen[cluster] = true
+				groups[cluster] = append(groups[cluster], pub)
+			}
+			if corresponding(author) && !correspondent[cluster] {
+				correspondent[cluster] = true
+				correspondence[cluster]++
+			}
+		}
+	}
+
+	reports := make([]AuthorReport, 0, len(clusters.clusters))
+	for _, cluster := range clusters.clusters {
+		report := AuthorReport{Author: cluster.displayName(), ORCID: cluster.orcid, MetricsSummary: summarize(groups[cluster], db), Corresponding: correspondence[cluster]}
+		for _, v := range cluster.variants {
+			if name := v.String(); !slices.Contains(report.Variants, name) {
+				report.Variants = append(report.Variants, name)
+			}
+		}
+		reports = append(reports, report)
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Publications != reports[j].Publications {
+			return reports[i].Publications > reports[j].Publications
+		}
+		return reports[i].Author < reports[j].Author
+	})
+	return reports
+}
+
+// Write the per-author summaries as an aligned table
+func writeAuthorReportsText(w io.Writer, authors []AuthorReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Author\tPublications\tCorresponding\tWith metrics\tQ1\tMean SJR\tVariants")
+	for _, author := range authors {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.3f\t%s\n", author.Author,
+			author.Publications, author.Corresponding, author.WithMetrics, author.Quartiles["Q1"],
+			author.MeanSJR, strings.Join(author.Variants, "; "))
+	}
+	return tw.Flush()
+}
+
+// Write the per-author summaries as CSV, one row per author, with the
+// name variants separated by semicolons
+func writeAuthorReportsCSV(w io.Writer, authors []AuthorReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"author", "orcid", "variants", "publications", "corresponding", "with_metrics", "q1", "q2", "q3", "q4", "unknown_quartile", "mean_sjr"})
+	for _, author := range authors {
+		writer.Write([]string{
+			author.Author,
+			author.ORCID,
+			strings.Join(author.Variants, "; "),
+			strconv.Itoa(author.Publications),
+			strconv.Itoa(author.Corresponding),
+			strconv.Itoa(author.WithMetrics),
+			strconv.Itoa(author.Quartiles["Q1"]),
+			strconv.Itoa(author.Quartiles["Q2"]),
+			strconv.Itoa(author.Quartiles["Q3"]),
+			strconv.Itoa(author.Quartiles["Q4"]),
+			strconv.Itoa(author.Quartiles["unknown"]),
+			strconv.FormatFloat(author.MeanSJR, 'f', 3, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/impact-factor-lookup/bibtex"
+)
+
+// Matches an ORCID iD, bare or as part of an https://orcid.org/ URL
+var orcidPattern = regexp.MustCompile(`\d{4}-\d{4}-\d{4}-\d{3}[\dX]`)
+
+// Author name styles for the BibTeX author field
+var authorStyles = map[string]bool{
+	"full":     true, // given names as they appear in the metadata
+	"initials": true, // given names reduced to initials, e.g. "Jensen, K. L."
+}
+
+// Lowercase name particles that belong in the BibTeX "von" part of a name
+var nameParticles = map[string]bool{
+	"da": true, "dal": true, "de": true, "del": true, "della": true,
+	"der": true, "di": true, "du": true, "la": true, "le": true, "ten": true,
+	"ter": true, "van": true, "von": true, "zu": true, "den": true, "dos": true,
+}
+
+// Whether a word of a name is written entirely in capitals, as some
+// repositories do with family names. Initials ("K.L.") don't count.
+func isAllCaps(word string) bool {
+	hasWord := false
+	for _, part := range strings.FieldsFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for _, r := range part {
+			if unicode.IsLower(r) {
+				return false
+			}
+		}
+		if len([]rune(part)) > 1 {
+			hasWord = true
+		}
+	}
+	return hasWord
+}
+
+// Convert the all-caps words of a name to title case, capitalizing each
+// part of hyphenated or apostrophized names ("O'BRIEN-SMITH" ->
+// "O'Brien-Smith") and lowercasing particles ("VAN DER BERG" -> "van der
+// Berg"). Other words are kept, so "van der BERG" becomes "van der Berg".
+func titleCaseName(name string) string {
+	words := strings.Fields(name)
+	for i, word := range words {
+		if !isAllCaps(word) {
+			continue
+		}
+		lower := strings.ToLower(word)
+		if nameParticles[lower] && i < len(words)-1 {
+			words[i] = lower
+			continue
+		}
+		var b strings.Builder
+		startOfPart := true
+		for _, r := range lower {
+			if startOfPart && unicode.IsLetter(r) {
+				b.WriteRune(unicode.ToUpper(r))
+				startOfPart = false
+				continue
+			}
+			b.WriteRune(r)
+			if r == '-' || r == '\'' || r == '’' {
+				startOfPart = true
+			}
+		}
+		words[i] = b.String()
+	}
+	return strings.Join(words, " ")
+}
+
+// Clean up a person's name: collapse whitespace, fix all-caps names, and
+// move particles that trail the given names ("Jan van der") to the front of
+// the family name, where BibTeX expects them.
+func normalizePersonName(family, given string) (string, string) {
+	family = strings.Join(strings.Fields(family), " ")
+	given = strings.Join(strings.Fields(given), " ")
+	family = titleCaseName(family)
+	given = titleCaseName(given)
+
+	givenWords := strings.Fields(given)
+	var particles []string
+	for len(givenWords) > 1 && nameParticles[strings.ToLower(givenWords[len(givenWords)-1])] {
+		particles = append([]string{strings.ToLower(givenWords[len(givenWords)-1])}, particles...)
+		givenWords = givenWords[:len(givenWords)-1]
+	}
+	if len(particles) > 0 {
+		family = strings.Join(append(particles, family), " ")
+		given = strings.Join(givenWords, " ")
+	}
+	return family, given
+}
+
+// Reduce given names to initials: "Kyle Lee" -> "K. L.",
+// "Jean-Marie" -> "J.-M.", "K.L." -> "K. L."
+func initials(given string) string {
+	var parts []string
+	for _, word := range strings.FieldsFunc(given, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '.'
+	}) {
+		var hyphenated []string
+		for _, piece := range strings.Split(word, "-") {
+			runes := []rune(piece)
+			if len(runes) == 0 {
+				continue
+			}
+			hyphenated = append(hyphenated, string(unicode.ToUpper(runes[0]))+".")
+		}
+		if len(hyphenated) > 0 {
+			parts = append(parts, strings.Join(hyphenated, "-"))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Extract the bare ORCID iD (0000-0002-1825-0097) from metadata that may
+// hold it as a URL, or return "" if there is none
+func normalizeORCID(orcid string) string {
+	return orcidPattern.FindString(strings.ToUpper(orcid))
+}
+
+// The author's name as it appears in the author field
+func formatAuthorName(author Author, opts bibtexOptions) string {
+	if author.Person.Organization {
+		// Braced so BibTeX doesn't split it into name parts
+		return "{" + author.Person.PersonName.FamilyNames + "}"
+	}
+	family := author.Person.PersonName.FamilyNames
+	given := author.Person.PersonName.FirstNames
+	if opts.NormalizeAuthors {
+		family, given = normalizePersonName(family, given)
+	}
+	if opts.AuthorStyle == "initials" {
+		given = initials(given)
+	}
+	if given == "" || family == "" {
+		// Without the comma, which would leave a name part empty
+		return strings.TrimSpace(family + given)
+	}
+	return fmt.Sprintf("%s, %s", family, given)
+}
+
+// The authors with their names escaped for BibTeX, so formatAuthors can
+// brace organizations around the escaped names
+func escapedAuthors(authors []Author) []Author {
+	escaped := make([]Author, len(authors))
+	for i, author := range authors {
+		name := &author.Person.PersonName
+		name.FamilyNames = bibtex.EscapeText(name.FamilyNames)
+		name.FirstNames = bibtex.EscapeText(name.FirstNames)
+		escaped[i] = author
+	}
+	return escaped
+}
+
+// The authors that have a name, leaving out the empty author elements
+// some repositories export
+func namedAuthors(authors []Author) []Author {
+	var named []Author
+	for _, author := range authors {
+		name := author.Person.PersonName
+		if strings.TrimSpace(name.FamilyNames+name.FirstNames) != "" {
+			named = append(named, author)
+		}
+	}
+	return named
+}
+
+// The authors to list: those with a name, cut to the first --max-authors
+// of them. Returns whether the list was cut.
+func listedAuthors(authors []Author, opts bibtexOptions) ([]Author, bool) {
+	named := namedAuthors(authors)
+	if opts.MaxAuthors > 0 && len(named) > opts.MaxAuthors {
+		return named[:opts.MaxAuthors], true
+	}
+	return named, false
+}
+
+// Mark the listed authors who contributed equally as a BibLaTeX data
+// annotation of the author field, e.g. "1=equal; 2=equal", or "" when none
+// did
+func formatEqualContributions(authors []Author, opts bibtexOptions) string {
+	listed, _ := listedAuthors(authors, opts)
+	var marks []string
+	for i, author := range listed {
+		if equalContribution(author) {
+			marks = append(marks, fmt.Sprintf("%d=equal", i+1))
+		}
+	}
+	return strings.Join(marks, "; ")
+}
+
+// List the ORCID iDs of the listed authors that have one, as "Name/iD"
+// pairs separated by semicolons
+func formatORCIDs(authors []Author, opts bibtexOptions) string {
+	listed, _ := listedAuthors(authors, opts)
+	var pairs []string
+	for _, author := range listed {
+		if orcid := normalizeORCID(author.Person.ORCID); orcid != "" {
+			pairs = append(pairs, formatAuthorName(author, opts)+"/"+orcid)
+		}
+	}
+	return strings.Join(pairs, "; ")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// The outcome of generating the output for one paper XML file of a
+// directory
+type BatchResult struct {
+	Input  string
+	Output string
+	Counts ManifestCounts
+	Err    error
+}
+
+// The paper XML files in a directory tree, in lexical order. The output
+// root is skipped when it is inside the tree, as are the files outputOf
+// gives as the output of another file found, so .xml output written next
+// to its input isn't read back as input.
+func findPaperFiles(dir, outputRoot string, outputOf func(string) (string, error)) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if outputRoot != "" && path != dir && filepath.Clean(path) == filepath.Clean(outputRoot) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".xml") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	generated := map[string]bool{}
+	for _, file := range files {
+		if output, err := outputOf(file); err == nil {
+			generated[filepath.Clean(output)] = true
+		}
+	}
+	inputs := files[:0]
+	for _, file := range files {
+		if !generated[filepath.Clean(file)] {
+			inputs = append(inputs, file)
+		}
+	}
+	return inputs, nil
+}
+
+// The extension of output files in cfg's format. Template output takes it
+// from the template's file name, e.g. ".xml" for entries.xml.tmpl.
+func outputExtension(cfg generateConfig) string {
+	if cfg.Template == "" {
+		return outputFormats[cfg.Format].Extension
+	}
+	name := filepath.Base(cfg.Template)
+	for _, suffix := range []string{".tmpl", ".tpl", ".gotmpl"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	if ext := filepath.Ext(name); ext != "" {
+		return ext
+	}
+	return ".txt"
+}
+
+// The output path for a paper XML file in dir: next to it, or at the same
+// place under outputRoot when that is given. An output that would replace
+// its input, as .xml template output does, gets ".out" added to its name
+// (p.xml -> p.out.xml).
+func batchOutputPath(dir, input, outputRoot, ext string) (string, error) {
+	output := strings.TrimSuffix(input, filepath.Ext(input)) + ext
+	if outputRoot != "" {
+		rel, err := filepath.Rel(dir, output)
+		if err != nil {
+			return "", err
+		}
+		output = filepath.Join(outputRoot, rel)
+	}
+	if sameFile(output, input) {
+		output = strings.TrimSuffix(output, ext) + ".out" + ext
+	}
+	return output, nil
+}
+
+// Whether two paths name the same file, by path or, when both exist, by
+// identity
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// Generate the output for every paper XML file in a directory tree, as
+// generate does for one, writing each next to its input or mirrored under
+// outputRoot. A file that fails doesn't stop the others.
+func generateBatch(cfg generateConfig, db *MetricsDatabase, dir, outputRoot string) ([]BatchResult, error) {
+	ext := outputExtension(cfg)
+	outputOf := func(input string) (string, error) {
+		return batchOutputPath(dir, input, outputRoot, ext)
+	}
+	inputs, err := findPaperFiles(dir, outputRoot, outputOf)
+	if err != nil {
+		return nil, runErrorf(exitError, "Error reading %s: %v", dir, err)
+	}
+	if len(inputs) == 0 {
+		return nil, runErrorf(exitUsage, "No paper XML files in %s", dir)
+	}
+	results := make([]BatchResult, 0, len(inputs))
+	for _, input := range inputs {
+		result := BatchResult{Input: input}
+		result.Output, result.Err = outputOf(input)
+		if result.Err == nil {
+			result.Err = os.MkdirAll(filepath.Dir(result.Output), 0o755)
+		}
+		if result.Err == nil {
+			fileCfg := cfg
+			fileCfg.XMLFilename = input
+			fileCfg.OutputPath = result.Output
+			result.Counts, result.Err = generate(fileCfg, db)
+		}
+		if result.Err != nil {
+			log.Printf("Error: %s: %v", input, result.Err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Write a table of the files of a batch run, with the number of
+// publications written and those without metrics, and the totals
+func writeBatchSummaryText(w io.Writer, results []BatchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Input\tOutput\tPublications\tWithout metrics\tStatus")
+	var total ManifestCounts
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "failed"
+			if exitCodeFor(r.Err) == exitMissRate {
+				status = "miss rate"
+			}
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", r.Input, r.Output, r.Counts.Output, r.Counts.WithoutMetrics, status)
+		total.Output += r.Counts.Output
+		total.WithoutMetrics += r.Counts.WithoutMetrics
+	}
+	fmt.Fprintf(tw, "Total (%d outputs, %d failed)\t\t%d\t%d\t\n", len(results), failed, total.Output, total.WithoutMetrics)
+	return tw.Flush()
+}
+
+// The exit code of a batch run: that of the first file that failed, or 0
+func batchExitCode(results []BatchResult) int {
+	for _, r := range results {
+		if r.Err != nil {
+			return exitCodeFor(r.Err)
+		}
+	}
+	return 0
+}
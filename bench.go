@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+// The timing of one stage of a `bench` run
+type BenchStage struct {
+	Name       string  `json:"name"`
+	Items      int     `json:"items"`       // rows, records, lookups or entries processed
+	Seconds    float64 `json:"seconds"`     // wall-clock time of the fastest run
+	PerSecond  float64 `json:"per_second"`  // Items / Seconds
+	AllocBytes uint64  `json:"alloc_bytes"` // bytes allocated during the fastest run
+}
+
+// The report printed by `bench`
+type BenchReport struct {
+	GoVersion  string       `json:"go_version"`
+	GOOS       string       `json:"goos"`
+	GOARCH     string       `json:"goarch"`
+	CPUs       int          `json:"cpus"`
+	Runs       int          `json:"runs"`
+	MetricsCSV string       `json:"metrics_csv"`
+	PaperXML   string       `json:"paper_xml"`
+	Stages     []BenchStage `json:"stages"`
+}
+
+// Run f the given number of times and keep the timing of the fastest run.
+// f returns the number of items it processed.
+func benchStage(name string, runs int, f func() (int, error)) (BenchStage, error) {
+	stage := BenchStage{Name: name}
+	for i := 0; i < runs; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		items, err := f()
+		elapsed := time.Since(start).Seconds()
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			return stage, fmt.Errorf("%s: %w", name, err)
+		}
+		if i == 0 || elapsed < stage.Seconds {
+			stage.Items = items
+			stage.Seconds = elapsed
+			stage.AllocBytes = after.TotalAlloc - before.TotalAlloc
+		}
+	}
+	if stage.Seconds > 0 {
+		stage.PerSecond = float64(stage.Items) / stage.Seconds
+	}
+	return stage, nil
+}
+
+// Time loading the metrics CSV, parsing the paper XML, looking up every
+// paper's journal, and rendering the papers as BibTeX
+func runBenchmarks(csvFilename, xmlFilename string, runs, jobs int) (BenchReport, error) {
+	report := BenchReport{
+		GoVersion:  runtime.Version(),
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		Runs:       runs,
+		MetricsCSV: csvFilename,
+		PaperXML:   xmlFilename,
+	}
+
+	var db *MetricsDatabase
+	stage, err := benchStage("load_csv", runs, func() (int, error) {
+		var err error
+		db, _, err = ReadMetricsCSV(csvFilename)
+		if err != nil {
+			return 0, err
+		}
+		return len(db.journals), nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Stages = append(report.Stages, stage)
+
+	var pubs []Publication
+	stage, err = benchStage("parse_xml", runs, func() (int, error) {
+		file, err := os.Open(xmlFilename)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		pubs, _, err = ReadPublications(file, "auto", false)
+		return len(pubs), err
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Stages = append(report.Stages, stage)
+
+	stage, _ = benchStage("lookup", runs, func() (int, error) {
+		for _, pub := range pubs {
+			db.LookupISSN(pub.ISSN)
+		}
+		return len(pubs), nil
+	})
+	report.Stages = append(report.Stages, stage)
+
+	opts := defaultBibtexOptions()
+	stage, _ = benchStage("render", runs, func() (int, error) {
+		var err error
+		renderEntries(pubs, db, toBibTeX, opts, jobs, func(r renderedEntry) {
+			if r.Err != nil && err == nil {
+				err = r.Err
+			}
+			io.WriteString(io.Discard, r.Entry)
+		})
+		return len(pubs), err
+	})
+	report.Stages = append(report.Stages, stage)
+	return report, nil
+}
+
+// Write the report as an aligned text table
+func writeBenchText(w io.Writer, report BenchReport) error {
+	fmt.Fprintf(w, "%s %s/%s, %d CPUs, best of %d runs\n", report.GoVersion, report.GOOS, report.GOARCH, report.CPUs, report.Runs)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STAGE\tITEMS\tSECONDS\tITEMS/S\tALLOC MB\t")
+	for _, s := range report.Stages {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.0f\t%.1f\t\n", s.Name, s.Items, s.Seconds, s.PerSecond, float64(s.AllocBytes)/(1<<20))
+	}
+	return tw.Flush()
+}
+
+// Write the report as indented JSON
+func writeBenchJSON(w io.Writer, report BenchReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// The `bench` subcommand: time each stage of a run on the given inputs
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv, instead of passing it as an argument")
+	format := fs.String("format", "json", "output format: json or text")
+	runs := fs.Int("runs", 3, "number of times to run each stage; the fastest run is reported")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "number of publications to render in parallel")
+	fs.Usage = func() {
+		log.Printf("Usage: %s bench [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "bench", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+
+	var write func(io.Writer, BenchReport) error
+	switch *format {
+	case "json":
+		write = writeBenchJSON
+	case "text":
+		write = writeBenchText
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *runs < 1 {
+		log.Printf("--runs must be at least 1")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	benchArgs := fs.Args()
+	if len(benchArgs) == 1 && *metricsPath != "" {
+		benchArgs = append(benchArgs, *metricsPath)
+	}
+	if len(benchArgs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	report, err := runBenchmarks(benchArgs[1], benchArgs[0], *runs, *jobs)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	if err := write(os.Stdout, report); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/impact-factor-lookup/bibtex"
+)
+
+// How the generated BibTeX is checked before it is written out
+var validateModes = map[string]bool{
+	"off":   true, // don't check
+	"warn":  true, // log problems and write the output anyway
+	"error": true, // log problems and fail the run without writing the output
+}
+
+// A parsed BibTeX entry
+type bibEntry struct {
+	Type   string
+	Key    string
+	Fields []bibField
+}
+
+// A field of a BibTeX entry, with its value as written, delimiters included
+type bibField struct {
+	Name  string
+	Value string
+}
+
+// A minimal BibTeX parser, strict enough to reject what BibTeX and Biber
+// would choke on: unbalanced braces, missing commas and delimiters, and
+// anything but whitespace between entries
+type bibParser struct {
+	src string
+	pos int
+}
+
+// Parse BibTeX source made up of entries separated by whitespace
+func parseBibTeX(src string) ([]bibEntry, error) {
+	p := &bibParser{src: src}
+	var entries []bibEntry
+	for {
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			return entries, nil
+		}
+		entry, err := p.entry()
+		if err != nil {
+			// An entry cut short still counts for its key, when it has one
+			if entry.Key != "" {
+				entries = append(entries, entry)
+			}
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// An error at the current position, with the line number
+func (p *bibParser) errorf(format string, args ...any) error {
+	line := strings.Count(p.src[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *bibParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// Consume the given byte, after any whitespace
+func (p *bibParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return p.errorf("expected %q, found end of input", c)
+	}
+	if p.src[p.pos] != c {
+		return p.errorf("expected %q, found %q", c, p.src[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+// An entry type, field name or macro: letters, digits and a few punctuation
+// characters that BibTeX allows in names
+func (p *bibParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := rune(p.src[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("_-:.+/'", c) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *bibParser) entry() (bibEntry, error) {
+	var entry bibEntry
+	if err := p.expect('@'); err != nil {
+		return entry, err
+	}
+	entry.Type = strings.ToLower(p.ident())
+	if entry.Type == "" {
+		return entry, p.errorf("missing entry type")
+	}
+	if err := p.expect('{'); err != nil {
+		return entry, err
+	}
+	entry.Key = p.ident()
+	if entry.Key == "" {
+		return entry, p.errorf("missing citation key")
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			p.pos++
+			return entry, nil
+		}
+		if err := p.expect(','); err != nil {
+			return entry, err
+		}
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '}' {
+			p.pos++
+			return entry, nil
+		}
+		name := strings.ToLower(p.ident())
+		if name == "" {
+			return entry, p.errorf("missing field name in entry %s", entry.Key)
+		}
+		if err := p.expect('='); err != nil {
+			return entry, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return entry, fmt.Errorf("field %s of entry %s: %v", name, entry.Key, err)
+		}
+		entry.Fields = append(entry.Fields, bibField{Name: name, Value: value})
+	}
+}
+
+// A field value: braced or quoted strings, numbers and macros, joined by #
+func (p *bibParser) value() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for {
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			return "", p.errorf("missing value")
+		}
+		switch c := p.src[p.pos]; c {
+		case '{':
+			if err := p.braced(); err != nil {
+				return "", err
+			}
+		case '"':
+			if err := p.quoted(); err != nil {
+				return "", err
+			}
+		default:
+			if p.ident() == "" {
+				return "", p.errorf("unexpected %q in value", c)
+			}
+		}
+		end := p.pos
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == '#' {
+			p.pos++
+			continue
+		}
+		return p.src[start:end], nil
+	}
+}
+
+// Consume a brace-delimited string, with nested braces balanced
+func (p *bibParser) braced() error {
+	start := p.pos
+	depth := 0
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+	}
+	p.pos = start
+	return p.errorf("unbalanced braces")
+}
+
+// Consume a quote-delimited string, in which braces must balance and quotes
+// may only appear inside braces
+func (p *bibParser) quoted() error {
+	start := p.pos
+	depth := 0
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return p.errorf("unbalanced braces")
+			}
+		case '"':
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+	}
+	p.pos = start
+	return p.errorf("unterminated quoted string")
+}
+
+// Checks generated entries one at a time, remembering citation keys so that
+// duplicates across entries are caught
+type bibValidator struct {
+	keys map[string]bool
+}
+
+func newBibValidator() *bibValidator {
+	return &bibValidator{keys: make(map[string]bool)}
+}
+
+// The first character of a field value that LaTeX would take as a comment,
+// an alignment tab or a subscript because it isn't escaped, leaving out
+// inline math and the links in \url commands, or 0 when there is none
+func unescapedSpecial(value string) byte {
+	for _, span := range bibtex.MathSpans(value) {
+		value = value[:span[0]] + strings.Repeat(" ", span[1]-span[0]) + value[span[1]:]
+	}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case strings.HasPrefix(value[i:], `\url{`):
+			if end := strings.IndexByte(value[i:], '}'); end >= 0 {
+				i += end
+			}
+		case c == '\\':
+			i++
+		case c == '%' || c == '&' || c == '_':
+			return c
+		}
+	}
+	return 0
+}
+
+// The problems with one generated entry: syntax errors, duplicate fields,
+// unescaped special characters in text fields, and a citation key already
+// used by an earlier entry. BibTeX compares keys case-insensitively.
+func (v *bibValidator) Check(src string) []string {
+	// Keys are registered before syntax errors are reported, so a later
+	// entry reusing the key of a broken one is still caught
+	entries, err := parseBibTeX(src)
+	var problems []string
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, entry := range entries {
+		key := strings.ToLower(entry.Key)
+		if v.keys[key] {
+			problems = append(problems, fmt.Sprintf("duplicate citation key %s", entry.Key))
+		}
+		v.keys[key] = true
+	}
+	if err != nil {
+		return problems
+	}
+	for _, entry := range entries {
+		fields := map[string]bool{}
+		for _, field := range entry.Fields {
+			if fields[field.Name] {
+				problems = append(problems, fmt.Sprintf("entry %s: duplicate field %s", entry.Key, field.Name))
+			}
+			fields[field.Name] = true
+			if slices.Contains(bibtex.VerbatimFields, field.Name) {
+				continue
+			}
+			if c := unescapedSpecial(field.Value); c != 0 {
+				problems = append(problems, fmt.Sprintf("entry %s: unescaped %q in field %s", entry.Key, c, field.Name))
+			}
+		}
+	}
+	return problems
+}
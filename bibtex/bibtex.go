@@ -0,0 +1,299 @@
+// Package bibtex writes BibTeX entries.
+package bibtex
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// One field of an entry. Values are written between braces as they are,
+// so they may contain braced groups, e.g. "{DNA} sequencing".
+type Field struct {
+	Name  string
+	Value string
+}
+
+// A BibTeX entry, e.g. @article{Key, ...}
+type Entry struct {
+	Type   string
+	Key    string
+	Fields []Field
+}
+
+// Add a field to the entry, unless value is empty
+func (e *Entry) Add(name, value string) {
+	if value != "" {
+		e.Fields = append(e.Fields, Field{Name: name, Value: value})
+	}
+}
+
+// The value of a field, or "" when the entry doesn't have it
+func (e Entry) Get(name string) string {
+	for _, f := range e.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// How the encoder escapes field values
+type EscapeMode int
+
+const (
+	// Write values as they are, for values that are already LaTeX
+	EscapeNone EscapeMode = iota
+	// Escape the characters LaTeX treats specially in text (& % $ # _ ~ ^
+	// and lone backslashes), except in inline math (see MathSpans). Braces
+	// are kept, as values may brace groups. The fields in VerbatimFields
+	// are left alone.
+	EscapeLaTeX
+)
+
+// Fields whose values are identifiers or links rather than text, which
+// EscapeLaTeX leaves alone
+var VerbatimFields = []string{"url", "doi", "eprint", "issn", "isbn"}
+
+// Options for an Encoder. The zero value writes every field in the order
+// it was added, unescaped.
+type Options struct {
+	// Fields written first, in this order; the others follow in the order
+	// they were added
+	FieldOrder []string
+	Escape     EscapeMode
+	// Entry types to write instead of others, e.g. {"misc": "online"}
+	EntryTypes map[string]string
+	// Fields to leave out, e.g. the journal metrics
+	Omit []string
+	// The indentation of fields (default two spaces)
+	Indent string
+	// Pad field names so the equals signs line up
+	Align bool
+	// End the last field with a comma as well, so adding a field after
+	// it changes one line
+	TrailingComma bool
+}
+
+// Writes BibTeX entries to an io.Writer
+type Encoder struct {
+	w    io.Writer
+	opts Options
+}
+
+// Create an encoder writing to w
+func NewEncoder(w io.Writer, opts Options) *Encoder {
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	return &Encoder{w: w, opts: opts}
+}
+
+// Write an entry, followed by a newline
+func (enc *Encoder) Encode(e Entry) error {
+	w := bufio.NewWriter(enc.w)
+	entryType := e.Type
+	if mapped, ok := enc.opts.EntryTypes[entryType]; ok {
+		entryType = mapped
+	}
+	w.WriteString("@" + entryType + "{" + e.Key)
+	fields := enc.fields(e.Fields)
+	width := 0
+	if enc.opts.Align {
+		for _, f := range fields {
+			width = max(width, len(f.Name))
+		}
+	}
+	for _, f := range fields {
+		w.WriteString(",\n" + enc.opts.Indent + f.Name + strings.Repeat(" ", max(0, width-len(f.Name))) + " = {" + enc.escape(f) + "}")
+	}
+	if enc.opts.TrailingComma && len(fields) > 0 {
+		w.WriteString(",")
+	}
+	w.WriteString("\n}\n")
+	return w.Flush()
+}
+
+// The fields to write, in order
+func (enc *Encoder) fields(fields []Field) []Field {
+	out := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		if !containsFold(enc.opts.Omit, f.Name) {
+			out = append(out, f)
+		}
+	}
+	if len(enc.opts.FieldOrder) == 0 {
+		return out
+	}
+	rank := func(name string) int {
+		for i, first := range enc.opts.FieldOrder {
+			if strings.EqualFold(first, name) {
+				return i
+			}
+		}
+		return len(enc.opts.FieldOrder)
+	}
+	ordered := make([]Field, 0, len(out))
+	for i := 0; i <= len(enc.opts.FieldOrder); i++ {
+		for _, f := range out {
+			if rank(f.Name) == i {
+				ordered = append(ordered, f)
+			}
+		}
+	}
+	return ordered
+}
+
+// Escapes the characters LaTeX treats specially in text: & % $ # _ with a
+// backslash, and ~ ^ and lone backslashes with text commands. With braces,
+// { and } are escaped too, as \{ and \} where they pair up and as
+// \textbraceleft{} and \textbraceright{} where they don't, since BibTeX
+// counts escaped braces as well. Characters that are already escaped, and
+// commands such as \"o or \textasciitilde{}, are kept as they are.
+func escapeSpecial(s string, braces bool) string {
+	var paired map[int]bool
+	if braces {
+		paired = pairedBraces(s)
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			n := commandLength(s[i:])
+			if n == 0 {
+				b.WriteString(`\textbackslash{}`)
+				continue
+			}
+			b.WriteString(s[i : i+n])
+			i += n - 1
+		case strings.IndexByte("&%$#_", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case braces && c == '{' && paired[i]:
+			b.WriteString(`\{`)
+		case braces && c == '}' && paired[i]:
+			b.WriteString(`\}`)
+		case braces && c == '{':
+			b.WriteString(`\textbraceleft{}`)
+		case braces && c == '}':
+			b.WriteString(`\textbraceright{}`)
+		case c == '~':
+			b.WriteString(`\textasciitilde{}`)
+		case c == '^':
+			b.WriteString(`\textasciicircum{}`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// The length of the command or escaped character s starts with: 2 for \&,
+// the name and an empty argument for \textasciitilde{}, or 0 when the
+// backslash stands alone, as in "a \ b" or at the end of s
+func commandLength(s string) int {
+	if len(s) < 2 {
+		return 0
+	}
+	isLetter := func(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+	if !isLetter(s[1]) {
+		if strings.IndexByte("&%$#_{}~^\\\"'`=.-", s[1]) >= 0 {
+			return 2
+		}
+		return 0
+	}
+	n := 2
+	for n < len(s) && isLetter(s[n]) {
+		n++
+	}
+	if strings.HasPrefix(s[n:], "{}") {
+		n += 2
+	}
+	return n
+}
+
+// The offsets of the unescaped braces of s that pair up with another
+func pairedBraces(s string) map[int]bool {
+	paired := map[int]bool{}
+	var open []int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if n := commandLength(s[i:]); n > 0 {
+				i += n - 1
+			}
+		case '{':
+			open = append(open, i)
+		case '}':
+			if len(open) > 0 {
+				paired[open[len(open)-1]] = true
+				paired[i] = true
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return paired
+}
+
+// MathSpans finds the inline math of s, as the start and end offsets of
+// each $...$ span including the dollars. As in pandoc, the opening dollar
+// must be followed by a non-space and the closing one preceded by a
+// non-space and not followed by a digit, so prices like "$5 and $10"
+// aren't math. Escaped dollars (\$) are text.
+func MathSpans(s string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] != '$':
+		case start < 0:
+			if i+1 < len(s) && s[i+1] != ' ' && s[i+1] != '$' {
+				start = i
+			}
+		case s[i-1] != ' ' && (i+1 == len(s) || s[i+1] < '0' || s[i+1] > '9'):
+			spans = append(spans, [2]int{start, i + 1})
+			start = -1
+		}
+	}
+	return spans
+}
+
+// EscapeMath escapes s with escape, leaving its inline math as it is, so
+// "$\alpha$-helices & $T_c$" keeps its formulas
+func EscapeMath(s string, escape func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range MathSpans(s) {
+		b.WriteString(escape(s[last:span[0]]))
+		b.WriteString(s[span[0]:span[1]])
+		last = span[1]
+	}
+	b.WriteString(escape(s[last:]))
+	return b.String()
+}
+
+// EscapeText escapes the characters LaTeX treats specially in plain text
+// (& % $ # _ { } ~ ^ \), except in inline math and where they are already
+// escaped. "50% of $T_c$" becomes "50\% of $T_c$".
+func EscapeText(s string) string {
+	return EscapeMath(s, func(s string) string { return escapeSpecial(s, true) })
+}
+
+func (enc *Encoder) escape(f Field) string {
+	if enc.opts.Escape != EscapeLaTeX || containsFold(VerbatimFields, f.Name) {
+		return f.Value
+	}
+	// Values may brace groups, so braces are kept
+	return EscapeMath(f.Value, func(s string) string { return escapeSpecial(s, false) })
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package bibtex
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	entry := Entry{Type: "misc", Key: "Jensen2021"}
+	entry.Add("author", "Jensen, Kyle")
+	entry.Add("title", "{Cats & dogs} ~ x^2")
+	entry.Add("url", "https://example.org/a_b")
+	entry.Add("sjr", "5.5")
+	entry.Add("empty", "")
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "zero options",
+			want: "@misc{Jensen2021,\n  author = {Jensen, Kyle},\n  title = {{Cats & dogs} ~ x^2},\n  url = {https://example.org/a_b},\n  sjr = {5.5}\n}\n",
+		},
+		{
+			name: "field order",
+			opts: Options{FieldOrder: []string{"SJR", "title"}},
+			want: "@misc{Jensen2021,\n  sjr = {5.5},\n  title = {{Cats & dogs} ~ x^2},\n  author = {Jensen, Kyle},\n  url = {https://example.org/a_b}\n}\n",
+		},
+		{
+			name: "omit",
+			opts: Options{Omit: []string{"SJR", "url"}},
+			want: "@misc{Jensen2021,\n  author = {Jensen, Kyle},\n  title = {{Cats & dogs} ~ x^2}\n}\n",
+		},
+		{
+			name: "entry types",
+			opts: Options{EntryTypes: map[string]string{"misc": "online"}, Omit: []string{"author", "title", "url"}},
+			want: "@online{Jensen2021,\n  sjr = {5.5}\n}\n",
+		},
+		{
+			name: "escape",
+			opts: Options{Escape: EscapeLaTeX, Omit: []string{"author", "sjr"}},
+			want: "@misc{Jensen2021,\n  title = {{Cats \\& dogs} \\textasciitilde{} x\\textasciicircum{}2},\n  url = {https://example.org/a_b}\n}\n",
+		},
+		{
+			name: "align",
+			opts: Options{Align: true, Omit: []string{"title", "url"}},
+			want: "@misc{Jensen2021,\n  author = {Jensen, Kyle},\n  sjr    = {5.5}\n}\n",
+		},
+		{
+			name: "indent",
+			opts: Options{Indent: "\t", Omit: []string{"title", "url"}},
+			want: "@misc{Jensen2021,\n\tauthor = {Jensen, Kyle},\n\tsjr = {5.5}\n}\n",
+		},
+		{
+			name: "trailing comma",
+			opts: Options{TrailingComma: true, Omit: []string{"title", "url"}},
+			want: "@misc{Jensen2021,\n  author = {Jensen, Kyle},\n  sjr = {5.5},\n}\n",
+		},
+		{
+			name: "trailing comma without fields",
+			opts: Options{TrailingComma: true, Omit: []string{"author", "title", "url", "sjr"}},
+			want: "@misc{Jensen2021\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := NewEncoder(&out, tt.opts).Encode(entry); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMathSpans(t *testing.T) {
+	tests := []struct {
+		in   string
+		want [][2]int
+	}{
+		{"no math", nil},
+		{"$\\alpha$-helices", [][2]int{{0, 8}}},
+		{"$a$ and $b$", [][2]int{{0, 3}, {8, 11}}},
+		{"costs $5 and $10", nil},
+		{"between $5 and $10", nil},
+		{"an escaped \\$ and $x$", [][2]int{{18, 21}}},
+		{"\\$5 and \\$10", nil},
+		{"unclosed $x", nil},
+		{"$$", nil},
+		{"$ x$", nil},
+	}
+	for _, tt := range tests {
+		if got := MathSpans(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MathSpans(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeMath(t *testing.T) {
+	upper := strings.ToUpper
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"text", "TEXT"},
+		{"$\\alpha$-helices and $T_c$", "$\\alpha$-HELICES AND $T_c$"},
+		{"costs $5", "COSTS $5"},
+	}
+	for _, tt := range tests {
+		if got := EscapeMath(tt.in, upper); got != tt.want {
+			t.Errorf("EscapeMath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Cats & dogs", "Cats \\& dogs"},
+		{"50% of DNA_seq #1", "50\\% of DNA\\_seq \\#1"},
+		{"$\\alpha$-helices & $T_c$", "$\\alpha$-helices \\& $T_c$"},
+		{"costs $5 and $10", "costs \\$5 and \\$10"},
+		{"already \\& escaped \\%", "already \\& escaped \\%"},
+		{"Schr\\\"odinger", "Schr\\\"odinger"},
+		{"unclosed $x_1", "unclosed \\$x\\_1"},
+		{"a {b} c", "a \\{b\\} c"},
+		{"x^2 {odd", "x\\textasciicircum{}2 \\textbraceleft{}odd"},
+		{"odd} {pair}", "odd\\textbraceright{} \\{pair\\}"},
+		{"a ~ b", "a \\textasciitilde{} b"},
+		{"a \\ b and c\\", "a \\textbackslash{} b and c\\textbackslash{}"},
+		{"$x^{2}$ and ^", "$x^{2}$ and \\textasciicircum{}"},
+		{"already \\{ \\textasciitilde{} \\textbackslash{}", "already \\{ \\textasciitilde{} \\textbackslash{}"},
+	}
+	for _, tt := range tests {
+		if got := EscapeText(tt.in); got != tt.want {
+			t.Errorf("EscapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := EscapeText(tt.want); got != tt.want {
+			t.Errorf("EscapeText(%q) = %q, want it unchanged", tt.want, got)
+		}
+	}
+}
+
+func TestVerbatimFields(t *testing.T) {
+	entry := Entry{Type: "article", Key: "K"}
+	for _, name := range VerbatimFields {
+		entry.Add(name, "a_b%c")
+	}
+	entry.Add("DOI", "10.1000/x_y")
+	entry.Add("note", "a_b%c")
+
+	var out strings.Builder
+	if err := NewEncoder(&out, Options{Escape: EscapeLaTeX}).Encode(entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range VerbatimFields {
+		if !strings.Contains(out.String(), "  "+name+" = {a_b%c}") {
+			t.Errorf("%s was escaped:\n%s", name, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "  DOI = {10.1000/x_y}") {
+		t.Errorf("field names should match case-insensitively:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "  note = {a\\_b\\%c}") {
+		t.Errorf("note wasn't escaped:\n%s", out.String())
+	}
+}
+
+func TestEntryGet(t *testing.T) {
+	entry := Entry{Type: "article", Key: "K"}
+	entry.Add("Title", "T")
+	if got := entry.Get("title"); got != "T" {
+		t.Errorf("Get(title) = %q, want T", got)
+	}
+	if got := entry.Get("author"); got != "" {
+		t.Errorf("Get(author) = %q, want empty", got)
+	}
+}
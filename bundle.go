@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What an offline bundle holds, as written to its bundle.json
+type BundleManifest struct {
+	Tool    ManifestTool    `json:"tool"`
+	Created time.Time       `json:"created"`
+	Metrics ManifestMetrics `json:"metrics"`
+	Files   []ManifestFile  `json:"files"`  // the data files, with their paths in the bundle
+	Cached  int             `json:"cached"` // responses of remote services in the bundle's cache
+}
+
+// The data files a bundle can hold, by role, with the flag each is given to
+var bundleRoles = []struct{ role, flag string }{
+	{"metrics", "metrics"},
+	{"citescore", "citescore"},
+	{"eigenfactor", "eigenfactor"},
+	{"asjc", "asjc-file"},
+	{"ltwa", "ltwa"},
+	{"issnl", "issnl-file"},
+}
+
+// Add a file to a tar archive under the given name
+func addToTar(tw *tar.Writer, name, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// Write a bundle of the data files, given by role, and the responses cached
+// in cacheDir unless it is empty
+func writeBundle(output string, files map[string]string, cacheDir string, metrics ManifestMetrics) (BundleManifest, error) {
+	manifest := BundleManifest{Tool: manifestTool(), Created: time.Now().UTC(), Metrics: metrics}
+	out, err := createAtomicFile(output)
+	if err != nil {
+		return manifest, err
+	}
+	defer out.Abort()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, r := range bundleRoles {
+		filename := files[r.role]
+		if filename == "" {
+			continue
+		}
+		file, err := hashFile(r.role, filename)
+		if err != nil {
+			return manifest, err
+		}
+		file.Path = "data/" + r.role + filepath.Ext(filename)
+		if err := addToTar(tw, file.Path, filename); err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	// The cache is laid out by provider, as --cache-dir keeps it, next to
+	// the journal cache of --providers
+	if cacheDir != "" {
+		err := filepath.WalkDir(cacheDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(p, ".json") {
+				return err
+			}
+			rel, err := filepath.Rel(cacheDir, p)
+			if err != nil {
+				return err
+			}
+			provider, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+			if _, ok := httpClients[provider]; !ok && rel != journalCacheFile {
+				return nil
+			}
+			manifest.Cached++
+			return addToTar(tw, "cache/"+filepath.ToSlash(rel), p)
+		})
+		if err != nil {
+			return manifest, fmt.Errorf("error reading cache: %v", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	header := &tar.Header{Name: "bundle.json", Mode: 0o644, Size: int64(len(data)), ModTime: manifest.Created}
+	if err := tw.WriteHeader(header); err != nil {
+		return manifest, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	if err := gz.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, out.Commit()
+}
+
+// Unpack a bundle, once, into the user's cache directory, where later runs
+// with the same bundle find it. Returns the unpacked data files by role
+// and the directory of the cached responses.
+func openBundle(filename string) (map[string]string, string, error) {
+	file, err := hashFile("bundle", filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading bundle: %w", err)
+	}
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	dir := filepath.Join(base, "impact-factor-lookup", "bundles", file.SHA256[:16])
+	if _, err := os.Stat(filepath.Join(dir, "bundle.json")); err != nil {
+		if err := unpackBundle(filename, dir); err != nil {
+			return nil, "", fmt.Errorf("error unpacking bundle %s: %v", filename, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "bundle.json"))
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading bundle: %v", err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("error reading bundle manifest: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range manifest.Files {
+		files[f.Role] = filepath.Join(dir, filepath.FromSlash(f.Path))
+	}
+	return files, filepath.Join(dir, "cache"), nil
+}
+
+// Unpack a bundle into dir, checking the data files against the bundle's
+// manifest. The bundle is unpacked next to dir first, so an interrupted
+// run doesn't leave a partial bundle behind.
+func unpackBundle(filename, dir string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.MkdirTemp(filepath.Dir(dir), ".unpack-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temp)
+
+	hashes := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		target := filepath.Join(temp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, hash), tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		hashes[name] = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	data, err := os.ReadFile(filepath.Join(temp, "bundle.json"))
+	if err != nil {
+		return fmt.Errorf("not a bundle: no bundle.json")
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("error reading bundle manifest: %v", err)
+	}
+	for _, f := range manifest.Files {
+		if hashes[f.Path] != f.SHA256 {
+			return fmt.Errorf("%s is missing or damaged", f.Path)
+		}
+	}
+	// Another run may have unpacked the same bundle meanwhile
+	if err := os.Rename(temp, dir); err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, "bundle.json")); statErr != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run against a bundle: give each flag of fs that wasn't set the bundle's
+// file for it, use the bundle's cached responses, and, unless --offline
+// was given, make no network requests
+func applyBundle(fs *flag.FlagSet, filename string) error {
+	files, cacheDir, err := openBundle(filename)
+	if err != nil {
+		return err
+	}
+	for _, r := range bundleRoles {
+		if f := fs.Lookup(r.flag); f != nil && f.Value.String() == "" && files[r.role] != "" {
+			fs.Set(r.flag, files[r.role])
+		}
+	}
+	if f := fs.Lookup("cache-dir"); f != nil && f.Value.String() == "" {
+		fs.Set("cache-dir", cacheDir)
+	}
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "offline"
+	})
+	if fs.Lookup("offline") != nil && !explicit {
+		fs.Set("offline", "true")
+	}
+	return nil
+}
+
+// The `bundle` subcommand: package the metrics and the other data files,
+// and the responses of remote services cached by earlier runs, into one
+// archive for machines without network access
+func runBundle(args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	output := fs.String("o", "", "file to write the bundle to, e.g. bundle.tar.gz")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv, instead of passing it as an argument")
+	sources := metricsSourceFlags(fs)
+	asjcPath := fs.String("asjc-file", "", "CSV file of ASJC category codes and names to include")
+	ltwaPath := fs.String("ltwa", "", "file of LTWA title word abbreviations to include")
+	issnlPath := fs.String("issnl-file", "", "ISSN-to-ISSN-L table to include")
+	cacheDir := fs.String("cache-dir", "", "directory of responses of remote services cached with --cache-dir to include")
+	fs.Usage = func() {
+		log.Printf("Usage: %s bundle [flags] -o <bundle file> [impact factor csv]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "bundle", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if fs.NArg() == 1 {
+		*metricsPath = fs.Arg(0)
+	}
+	if fs.NArg() > 1 || *metricsPath == "" || *output == "" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Check the data before packing it up for a machine where it can't
+	// easily be fixed
+	db, err := loadMetrics(*metricsPath, *sources, false)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	checks := []struct {
+		path string
+		load func(string) error
+	}{{*asjcPath, loadASJC}, {*ltwaPath, loadLTWA}, {*issnlPath, loadISSNL}}
+	for _, check := range checks {
+		if check.path != "" {
+			if err := check.load(check.path); err != nil {
+				fatalf(inputExitCode(err), "%v", err)
+			}
+		}
+	}
+
+	files := map[string]string{
+		"metrics":     *metricsPath,
+		"citescore":   sources.CiteScore,
+		"eigenfactor": sources.Eigenfactor,
+		"asjc":        *asjcPath,
+		"ltwa":        *ltwaPath,
+		"issnl":       *issnlPath,
+	}
+	manifest, err := writeBundle(*output, files, *cacheDir, db.summary())
+	if err != nil {
+		fatalf(exitError, "Error writing bundle: %v", err)
+	}
+	log.Printf("Wrote %s with %d data files and %d cached responses", *output, len(manifest.Files), manifest.Cached)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// A transport that keeps the responses of one provider in a directory and
+// answers repeated requests from there, so a run can be repeated, or moved
+// to a machine without network access, without asking the provider again.
+// Only GET requests are cached, and only their successful and not found
+// responses, which are as good as final.
+type cacheTransport struct {
+	dir     string // the provider's directory in the cache, or "" for none
+	offline bool   // answer only from the cache
+	base    http.RoundTripper
+}
+
+// A response as kept in the cache
+type cachedResponse struct {
+	URL         string
+	Status      int
+	ContentType string `json:",omitempty"`
+	Body        []byte
+}
+
+// The cache key of a request URL. The contact address sent to Crossref is
+// left out, so caches made by different users match.
+func cacheKey(u *url.URL) string {
+	keyed := *u
+	query := keyed.Query()
+	query.Del("mailto")
+	keyed.RawQuery = query.Encode()
+	sum := sha256.Sum256([]byte(keyed.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		if t.offline {
+			return nil, fmt.Errorf("offline: not sending %s %s", req.Method, req.URL.Redacted())
+		}
+		return t.base.RoundTrip(req)
+	}
+	path := filepath.Join(t.dir, cacheKey(req.URL)+".json")
+	if data, err := os.ReadFile(path); err == nil && t.dir != "" {
+		var cached cachedResponse
+		if err := json.Unmarshal(data, &cached); err == nil {
+			return cached.response(req), nil
+		}
+	}
+	if t.offline {
+		return nil, fmt.Errorf("offline: %s isn't in the cache", req.URL.Redacted())
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound) {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	cached := cachedResponse{URL: req.URL.Redacted(), Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: body}
+	if data, err := json.Marshal(cached); err == nil && os.MkdirAll(t.dir, 0o755) == nil {
+		writeFileAtomic(path, data)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// The cached response as an answer to req
+func (c cachedResponse) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	if c.ContentType != "" {
+		header.Set("Content-Type", c.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+// Write a file so that readers never see it half-written
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicPerm(path, data, 0o644)
+}
+
+// Write a file as writeFileAtomic does, with the given permissions
+func writeFileAtomicPerm(path string, data []byte, perm os.FileMode) error {
+	file, err := createAtomicFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+	file.perm = perm
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Commit()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// An organisational unit, such as the department an author is affiliated
+// with. Affiliations from metadata formats other than CERIF only have a
+// name, and a ROR identifier where the format has one.
+type OrgUnit struct {
+	ID          string `xml:"id,attr" json:"id,omitempty"`
+	Name        string `xml:"Name" json:"name,omitempty"`
+	Acronym     string `xml:"Acronym" json:"acronym,omitempty"`
+	Identifiers []struct {
+		Type  string `xml:"type,attr" json:"type,omitempty"`
+		Value string `xml:",chardata" json:"value,omitempty"`
+	} `xml:"Identifier" json:"identifiers,omitempty"`
+	PartOf *OrgUnit `xml:"PartOf>OrgUnit" json:"part_of,omitempty"` // the unit this one belongs to, e.g. the university of a department
+
+	// The unit's ROR identifier, when it is known from another metadata
+	// format or from matching; see rorID
+	ROR string `xml:"-" json:"ror,omitempty"`
+}
+
+// Funding a publication originates from, as in the OpenAIRE CERIF
+// OriginatesFrom element
+type Funding struct {
+	Name       string `xml:"Name" json:"name,omitempty"`
+	Acronym    string `xml:"Acronym" json:"acronym,omitempty"`
+	Identifier string `xml:"Identifier" json:"identifier,omitempty"` // the grant number
+	Funder     string `xml:"Funder>OrgUnit>Name" json:"funder,omitempty"`
+}
+
+// Reduce a publication status to lowercase letters, so that "E-pub ahead
+// of print", "Epub ahead of print" and "epub_ahead_of_print" compare equal.
+// Statuses given as vocabulary URIs are reduced to their last part.
+func normalizeStatus(status string) string {
+	status = strings.TrimSpace(status)
+	if i := strings.LastIndexAny(status, "/#"); i >= 0 && strings.Contains(status, "://") {
+		status = status[i+1:]
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, status)
+}
+
+// Labels of the statuses of publications that are accepted but not yet
+// published, by normalized status
+var forthcomingStatuses = map[string]string{
+	"acceptedinpress": "In press", // Pure's "Accepted/In press"
+	"inpress":         "In press",
+	"accepted":        "Accepted",
+	"forthcoming":     "Forthcoming",
+}
+
+// How --in-press treats publications that are accepted but not yet
+// published
+var inPressModes = map[string]bool{
+	"include": true, // keep them, labelled in place of a year
+	"exclude": true, // drop them
+	"only":    true, // keep only them
+}
+
+// The label of a publication that is accepted but not yet published, such
+// as "In press", or "" for any other publication
+func forthcomingLabel(pub Publication) string {
+	return forthcomingStatuses[normalizeStatus(pub.Status)]
+}
+
+// Keep or drop the publications that are accepted but not yet published,
+// according to one of inPressModes
+func filterInPress(pubs []Publication, mode string) []Publication {
+	if mode == "include" {
+		return pubs
+	}
+	var out []Publication
+	for _, pub := range pubs {
+		if (forthcomingLabel(pub) != "") == (mode == "only") {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// Whether the publication is marked as peer reviewed
+func peerReviewed(pub Publication) bool {
+	switch strings.ToLower(strings.TrimSpace(pub.PeerReviewed)) {
+	case "true", "yes", "1", "peer-reviewed", "peerreviewed":
+		return true
+	}
+	return false
+}
+
+// Whether the author is marked as a corresponding author of the
+// publication
+func corresponding(author Author) bool {
+	switch strings.ToLower(strings.TrimSpace(author.Corresponding)) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// Whether the author is marked as having contributed equally with the
+// other authors so marked
+func equalContribution(author Author) bool {
+	switch strings.ToLower(strings.TrimSpace(author.EqualContribution)) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// Fill in the names of a CERIF publication's authors that lack parts.
+// Group and consortium authors, which have an OrgUnit or a DisplayName
+// instead of a Person, become organization authors named by them. Persons
+// without a family name take their name from the DisplayName when there
+// is one, or else their given name stands as their whole name. Authors
+// with no name at all are dropped.
+func resolveAuthorNames(pub Publication) Publication {
+	var authors []Author
+	for _, author := range pub.Authors.AuthorList {
+		name := &author.Person.PersonName
+		given := strings.TrimSpace(name.FirstNames)
+		display := strings.Join(strings.Fields(author.DisplayName), " ")
+		switch {
+		case strings.TrimSpace(name.FamilyNames) != "":
+		case given != "" && display != "":
+			*name = parsePersonName(display)
+		case given != "":
+			*name = PersonName{FamilyNames: given}
+		case author.Group != nil && strings.TrimSpace(author.Group.Name) != "":
+			author.Person = Person{PersonName: PersonName{FamilyNames: strings.TrimSpace(author.Group.Name)}, Organization: true}
+		case display != "":
+			author.Person = Person{PersonName: PersonName{FamilyNames: display}, Organization: true}
+		default:
+			continue
+		}
+		authors = append(authors, author)
+	}
+	pub.Authors.AuthorList = authors
+	return pub
+}
+
+// Keep only the publications whose corresponding authors include the
+// person, given as for --self: "Family, Initials" or an ORCID iD
+func filterCorresponding(pubs []Publication, person string) []Publication {
+	matcher := parseSelf(person)
+	var out []Publication
+	for _, pub := range pubs {
+		if matcher.corresponding(pub) {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// The organisational units the publication's authors are affiliated with,
+// by name, in order of first appearance
+func orgUnits(pub Publication) []string {
+	seen := map[string]bool{}
+	var units []string
+	for _, author := range pub.Authors.AuthorList {
+		for _, unit := range author.Affiliations {
+			name := strings.Join(strings.Fields(unit.Name), " ")
+			if name != "" && !seen[name] {
+				seen[name] = true
+				units = append(units, name)
+			}
+		}
+	}
+	return units
+}
+
+// Keep only the publications with one of the given comma-separated
+// statuses, e.g. "published" or "published,epub ahead of print"
+func filterStatuses(pubs []Publication, list string) []Publication {
+	wanted := map[string]bool{}
+	for _, status := range strings.Split(list, ",") {
+		if status := normalizeStatus(status); status != "" {
+			wanted[status] = true
+		}
+	}
+	var out []Publication
+	for _, pub := range pubs {
+		if wanted[normalizeStatus(pub.Status)] {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// Keep only the peer-reviewed publications
+func filterPeerReviewed(pubs []Publication) []Publication {
+	var out []Publication
+	for _, pub := range pubs {
+		if peerReviewed(pub) {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// Keep only the publications with an author affiliated with the
+// organisational unit, given by ID, name or acronym (case-insensitively)
+func filterOrgUnit(pubs []Publication, unit string) []Publication {
+	unit = strings.TrimSpace(unit)
+	var out []Publication
+	for _, pub := range pubs {
+	authors:
+		for _, author := range pub.Authors.AuthorList {
+			for _, affiliation := range author.Affiliations {
+				if affiliation.ID == unit || strings.EqualFold(affiliation.Name, unit) || strings.EqualFold(affiliation.Acronym, unit) {
+					out = append(out, pub)
+					break authors
+				}
+			}
+		}
+	}
+	return out
+}
+
+// The version of the --format json output, raised whenever a field is
+// renamed or removed or its meaning changes, so integrations can tell
+// which shape they get. Adding a field doesn't change it. Version 2 gave
+// the lookup results, trends and reports the same lowercase keys.
+const publicationSchemaVersion = 2
+
+// A publication as written by --format json, with its citation key, the
+// organisational units of its authors, its journal's metrics (null when
+// it has none) and the journal's SJR trend (left out when unknown)
+type publicationJSON struct {
+	SchemaVersion int `json:"schema_version"` // publicationSchemaVersion
+	Publication
+	CitationKey string          `json:"citation_key"`
+	OrgUnits    []string        `json:"org_units,omitempty"`
+	Metrics     *JournalMetrics `json:"metrics"`
+	Trend       *SJRTrend       `json:"trend,omitempty"`
+}
+
+// Render a publication as an element of the --format json array
+func toJSON(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+	data, err := json.MarshalIndent(publicationJSON{
+		SchemaVersion: publicationSchemaVersion,
+		Publication:   pub,
+		CitationKey:   createCitationKey(pub),
+		OrgUnits:      orgUnits(pub),
+		Metrics:       metrics,
+		Trend:         pub.Trend,
+	}, "  ", "  ")
+	if err != nil {
+		return "", err
+	}
+	return "  " + string(data), nil
+}
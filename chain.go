@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The providers --providers can chain: the metrics CSV, the journal cache
+// in --cache-dir, and the remote services
+var journalProviders = map[string]bool{"csv": true, "cache": true, "openalex": true, "crossref": true, "scopus": true}
+
+// The file in --cache-dir that keeps the journals remote providers found
+const journalCacheFile = "journals.json"
+
+// A journal found by a remote provider, as kept in the journal cache
+type cachedJournal struct {
+	Provider string
+	Metrics  JournalMetrics
+}
+
+// An ordered chain of journal providers. The journal of each publication
+// is looked up with each provider in turn until one finds it; journals
+// found elsewhere than in the metrics CSV are added to the database.
+type providerChain struct {
+	providers []string
+	cacheDir  string
+
+	cache map[string]cachedJournal // the journal cache by ISSN, loaded on first use
+	dirty bool                     // whether cache has journals to save
+	found map[string]string        // the provider that found each ISSN looked up, or "" for none
+}
+
+// Parse a --providers value: comma-separated provider names, tried in
+// order. The empty value is the metrics CSV alone.
+func parseProviderChain(spec, cacheDir string) (*providerChain, error) {
+	c := &providerChain{cacheDir: cacheDir, found: map[string]string{}}
+	if strings.TrimSpace(spec) == "" {
+		spec = "csv"
+	}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case !journalProviders[name]:
+			return nil, fmt.Errorf("unknown provider %q in --providers", name)
+		case seen[name]:
+			return nil, fmt.Errorf("provider %q is listed twice in --providers", name)
+		case name == "cache" && cacheDir == "":
+			return nil, fmt.Errorf("the cache provider needs --cache-dir")
+		}
+		seen[name] = true
+		c.providers = append(c.providers, name)
+	}
+	return c, nil
+}
+
+// Whether the chain has providers besides the metrics CSV
+func (c *providerChain) remote() bool {
+	return len(c.providers) > 1 || c.providers[0] != "csv"
+}
+
+// Look a journal up with one provider. Journals in the database are found
+// by the csv provider without being returned.
+func (c *providerChain) lookup(provider, issn string, db *MetricsDatabase) (JournalMetrics, bool, error) {
+	switch provider {
+	case "csv":
+		_, ok := db.LookupISSN(issn)
+		return JournalMetrics{}, ok, nil
+	case "cache":
+		if err := c.loadCache(); err != nil {
+			return JournalMetrics{}, false, err
+		}
+		cached, ok := c.cache[issn]
+		return cached.Metrics, ok, nil
+	case "openalex":
+		source, err := fetchOpenAlexSource(issn)
+		if source == nil {
+			return JournalMetrics{}, false, err
+		}
+		return source.metrics(), true, nil
+	case "crossref":
+		journal, err := fetchCrossrefJournal(issn)
+		if journal == nil {
+			return JournalMetrics{}, false, err
+		}
+		// Crossref has no journal IDs, so the negated ISSN stands in,
+		// offset to stay clear of OpenAlex's
+		number, _ := strconv.ParseInt(issn[:7], 10, 64)
+		m := JournalMetrics{Title: journal.Title, Publisher: journal.Publisher, SourceID: -(1<<40 + number), Year: int64(time.Now().Year())}
+		for _, issn := range journal.ISSNs {
+			m.ISSNs = append(m.ISSNs, normalizeISSN(issn))
+		}
+		return m, true, nil
+	case "scopus":
+		serial, err := fetchScopusSerial(issn)
+		if serial == nil {
+			return JournalMetrics{}, false, err
+		}
+		return serial.metrics(), true, nil
+	}
+	return JournalMetrics{}, false, fmt.Errorf("unknown provider %q", provider)
+}
+
+// Find the journals of the publications, trying the providers in order.
+// A provider that fails is logged and the next one tried. Journals found
+// by remote providers are kept in the journal cache when the chain has
+// one, and ranked within their fields with the rest of the database.
+func (c *providerChain) resolve(pubs []Publication, db *MetricsDatabase) {
+	added := false
+	caching := slices.Contains(c.providers, "cache")
+	if caching {
+		if err := c.loadCache(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	for _, pub := range pubs {
+		issn := normalizeISSN(pub.ISSN)
+		if len(issn) != 8 {
+			continue
+		}
+		if _, ok := c.found[issn]; ok {
+			continue
+		}
+		c.found[issn] = ""
+		for _, provider := range c.providers {
+			m, ok, err := c.lookup(provider, issn, db)
+			if err != nil {
+				log.Printf("Warning: looking up ISSN %s with %s: %v", formatISSN(issn), provider, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			c.found[issn] = provider
+			if provider == "csv" {
+				break
+			}
+			if !slices.Contains(m.ISSNs, issn) {
+				m.ISSNs = append(m.ISSNs, issn)
+			}
+			db.Add(m)
+			added = true
+			if provider == "cache" {
+				db.setOrigin(m.SourceID, c.cache[issn].Provider)
+			} else {
+				db.setOrigin(m.SourceID, provider)
+			}
+			if caching && provider != "cache" {
+				c.cache[issn] = cachedJournal{Provider: provider, Metrics: m}
+				c.dirty = true
+			}
+			break
+		}
+	}
+	if added {
+		db.RankWithinFields()
+	}
+	if c.dirty {
+		if err := c.saveCache(); err != nil {
+			log.Printf("Warning: error saving the journal cache: %v", err)
+		}
+	}
+}
+
+// Read the journal cache, once. A missing cache is empty.
+func (c *providerChain) loadCache() error {
+	if c.cache != nil {
+		return nil
+	}
+	c.cache = map[string]cachedJournal{}
+	data, err := os.ReadFile(filepath.Join(c.cacheDir, journalCacheFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &c.cache); err != nil {
+		return fmt.Errorf("error reading journal cache: %v", err)
+	}
+	return nil
+}
+
+// Write the journal cache
+func (c *providerChain) saveCache() error {
+	data, err := json.MarshalIndent(c.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.cacheDir, 0o755); err != nil {
+		return err
+	}
+	c.dirty = false
+	return writeFileAtomic(filepath.Join(c.cacheDir, journalCacheFile), data)
+}
+
+// How many publications each provider of a chain found the journal of
+type ProviderStats struct {
+	Providers []string       `json:"providers"` // in chain order
+	Hits      map[string]int `json:"hits"`      // by provider
+	Misses    int            `json:"misses"`    // publications no provider found the journal of
+}
+
+// Count the publications whose journal each provider found. Publications
+// without an ISSN aren't counted.
+func (c *providerChain) stats(pubs []Publication) *ProviderStats {
+	stats := &ProviderStats{Providers: c.providers, Hits: map[string]int{}}
+	for _, pub := range pubs {
+		provider, ok := c.found[normalizeISSN(pub.ISSN)]
+		switch {
+		case !ok:
+		case provider == "":
+			stats.Misses++
+		default:
+			stats.Hits[provider]++
+		}
+	}
+	return stats
+}
+
+// The counts as e.g. "csv 120, openalex 4, none 2"
+func (s ProviderStats) String() string {
+	var parts []string
+	for _, provider := range s.Providers {
+		parts = append(parts, fmt.Sprintf("%s %d", provider, s.Hits[provider]))
+	}
+	return strings.Join(append(parts, fmt.Sprintf("none %d", s.Misses)), ", ")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+)
+
+// Citation-based indicators of a set of publications, computed from the
+// citation counts of the publications that have one
+type CitationSummary struct {
+	Publications int `json:"publications"` // publications with a citation count
+	Citations    int `json:"citations"`
+	HIndex       int `json:"h_index"`
+	H5Index      int `json:"h5_index"` // the h-index of the publications of the last five complete years
+}
+
+// Fill in the citation counts of publications with a DOI from their
+// Crossref records. Lookup failures are logged and leave the count unset.
+func enrichCitationsFromCrossref(pubs []Publication) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if pub.Citations != nil || pub.DOI == "" {
+			continue
+		}
+		work, err := fetchCrossrefWork(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up the citations of %s: %v", pub.DOI, err)
+			continue
+		}
+		if work == nil {
+			continue
+		}
+		count := work.IsReferencedByCount
+		pub.Citations = &count
+	}
+}
+
+// The largest h such that h of the counts are at least h
+func hIndex(counts []int) int {
+	sorted := append([]int(nil), counts...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	h := 0
+	for h < len(sorted) && sorted[h] >= h+1 {
+		h++
+	}
+	return h
+}
+
+// The year the publication came out, from the start of its date
+func publicationYear(pub Publication) (int, bool) {
+	if len(pub.Date) < 4 {
+		return 0, false
+	}
+	year, err := strconv.Atoi(pub.Date[:4])
+	return year, err == nil
+}
+
+// Compute the citation indicators of the publications as of the given
+// year, whose publications don't count towards the h5-index. Returns nil
+// when no publication has a citation count.
+func summarizeCitations(pubs []Publication, year int) *CitationSummary {
+	var counts, recent []int
+	summary := &CitationSummary{}
+	for _, pub := range pubs {
+		if pub.Citations == nil {
+			continue
+		}
+		summary.Publications++
+		summary.Citations += *pub.Citations
+		counts = append(counts, *pub.Citations)
+		if published, ok := publicationYear(pub); ok && published >= year-5 && published < year {
+			recent = append(recent, *pub.Citations)
+		}
+	}
+	if summary.Publications == 0 {
+		return nil
+	}
+	summary.HIndex = hIndex(counts)
+	summary.H5Index = hIndex(recent)
+	return summary
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// One row of an Elsevier CiteScore export. Exports have a row per journal
+// and subject area; the percentile is the journal's in that area.
+type citeScoreRow struct {
+	SourceID   int64 // 0 when the export has no source IDs
+	Year       int64 // 0 when the export doesn't say
+	ISSNs      []string
+	CiteScore  *float64
+	SNIP       *float64
+	Percentile *float64
+}
+
+// Matches the year in column headings like "CiteScore 2023"
+var citeScoreYearPattern = regexp.MustCompile(`\b(19|20)\d\d\b`)
+
+// The columns of a CiteScore export, found by their headings, which vary
+// between the Scopus Sources list and the yearly CiteScore downloads.
+// Columns that are missing are -1.
+type citeScoreColumns struct {
+	sourceID, citeScore, snip, percentile, year int
+	issns                                       []int
+	headingYear                                 int64 // from e.g. "CiteScore 2023"
+}
+
+func findCiteScoreColumns(header []string) (citeScoreColumns, error) {
+	cols := citeScoreColumns{sourceID: -1, citeScore: -1, snip: -1, percentile: -1, year: -1}
+	for i, heading := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(heading, "\ufeff")))
+		switch {
+		case name == "scopus source id" || name == "source id" || name == "sourceid":
+			cols.sourceID = i
+		case strings.HasPrefix(name, "citescore") && !strings.Contains(name, "percentile") && !strings.Contains(name, "rank"):
+			cols.citeScore = i
+		case strings.HasPrefix(name, "snip"):
+			cols.snip = i
+		case name == "percentile" || name == "highest percentile" || name == "citescore percentile":
+			cols.percentile = i
+		case name == "year":
+			cols.year = i
+		case name == "issn" || name == "print issn" || name == "e-issn" || name == "eissn":
+			cols.issns = append(cols.issns, i)
+		default:
+			continue
+		}
+		if match := citeScoreYearPattern.FindString(name); match != "" && cols.headingYear == 0 {
+			cols.headingYear, _ = strconv.ParseInt(match, 10, 64)
+		}
+	}
+	if cols.citeScore < 0 {
+		return cols, fmt.Errorf("no CiteScore column in the header")
+	}
+	if cols.sourceID < 0 && len(cols.issns) == 0 {
+		return cols, fmt.Errorf("no Scopus Source ID or ISSN column in the header")
+	}
+	return cols, nil
+}
+
+// Parse a metric from a CiteScore or Eigenfactor export, which may carry a
+// percent sign or a trailing note, as in "99%" or "99th". Empty values are
+// nil.
+func parseMetricValue(s string) (*float64, error) {
+	s = strings.TrimSpace(strings.ReplaceAll(s, ",", ""))
+	if s == "" || s == "-" {
+		return nil, nil
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end == 0 {
+		return nil, fmt.Errorf("invalid value %q", s)
+	}
+	if end > 0 {
+		s = s[:end]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func parseCiteScoreRecord(record []string, cols citeScoreColumns) (citeScoreRow, error) {
+	row := citeScoreRow{Year: cols.headingYear}
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var err error
+	if s := field(cols.sourceID); s != "" {
+		if row.SourceID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return row, fmt.Errorf("error parsing source ID: %v", err)
+		}
+	}
+	if s := field(cols.year); s != "" {
+		if row.Year, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return row, fmt.Errorf("error parsing year: %v", err)
+		}
+	}
+	for _, i := range cols.issns {
+		// Spreadsheets drop the leading zeros of ISSNs stored as numbers
+		if issn := normalizeISSN(field(i)); issn != "" {
+			row.ISSNs = append(row.ISSNs, strings.Repeat("0", max(0, 8-len(issn)))+issn)
+		}
+	}
+	if row.CiteScore, err = parseMetricValue(field(cols.citeScore)); err != nil {
+		return row, fmt.Errorf("error parsing CiteScore: %v", err)
+	}
+	if row.SNIP, err = parseMetricValue(field(cols.snip)); err != nil {
+		return row, fmt.Errorf("error parsing SNIP: %v", err)
+	}
+	if row.Percentile, err = parseMetricValue(field(cols.percentile)); err != nil {
+		return row, fmt.Errorf("error parsing percentile: %v", err)
+	}
+	return row, nil
+}
+
+// Add the CiteScore metrics of a row to the journal's record for the same
+// year, or its most recent record when the year is unknown or the journal
+// has none for that year. The journal is found by source ID, or by ISSN.
+// Of the rows for a journal's subject areas, the highest percentile is
+// kept. Returns false when the journal isn't in the database.
+func (db *MetricsDatabase) addCiteScore(row citeScoreRow) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var index int32
+	var ok bool
+	if row.SourceID != 0 {
+		if index, ok = db.records[sourceYear{row.SourceID, row.Year}]; !ok {
+			index, ok = db.bySourceID[row.SourceID]
+		}
+	}
+	for _, issn := range row.ISSNs {
+		if ok {
+			break
+		}
+		var key issnKey
+		if key, ok = makeISSNKey(issn); ok {
+			index, ok = db.byISSN[key]
+		}
+	}
+	if !ok {
+		return false
+	}
+	m := &db.journals[index]
+	if row.CiteScore != nil {
+		m.CiteScore = row.CiteScore
+	}
+	if row.SNIP != nil {
+		m.SNIP = row.SNIP
+	}
+	if greater(row.Percentile, m.CiteScorePercentile) {
+		m.CiteScorePercentile = row.Percentile
+	}
+	return true
+}
+
+// Add the metrics of an Elsevier CiteScore export (CiteScore, SNIP and
+// CiteScore percentile) to the journals in the database. Journals missing
+// from the database are counted, not added, since the export has no
+// h-index or SCImago ranks for them. With WithLenient, malformed rows are
+// skipped with a warning; the number of skipped rows is returned.
+func (db *MetricsDatabase) ReadCiteScoreCSV(filename string, options ...MetricsOption) (unmatched, skipped int, err error) {
+	opts := newMetricsReadOptions(options)
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading header: %v", err)
+	}
+	cols, err := findCiteScoreColumns(header)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	missing := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var row citeScoreRow
+		if err != nil {
+			err = fmt.Errorf("error reading record: %v", err)
+		} else if row, err = parseCiteScoreRecord(record, cols); err != nil {
+			line, _ := reader.FieldPos(0)
+			err = fmt.Errorf("line %d: %v", line, err)
+		}
+		if err != nil {
+			if !opts.Lenient {
+				return 0, skipped, err
+			}
+			log.Printf("Warning: skipping CiteScore row: %v", err)
+			skipped++
+			continue
+		}
+
+		// A journal has a row per subject area, so count journals, not rows
+		key := strconv.FormatInt(row.SourceID, 10) + " " + strings.Join(row.ISSNs, ",")
+		if !db.addCiteScore(row) {
+			missing[key] = true
+		}
+	}
+	return len(missing), skipped, nil
+}
+
+// Add a CiteScore export to a database loaded by one of the subcommands,
+// reporting skipped rows and journals that weren't found
+func loadCiteScore(db *MetricsDatabase, filename string, lenient bool) error {
+	unmatched, skipped, err := db.ReadCiteScoreCSV(filename, lenientOptions(lenient)...)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed CiteScore rows", skipped)
+	}
+	if unmatched > 0 {
+		log.Printf("%d journals in %s are not in the metrics csv", unmatched, filename)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the environment variables that override config file settings,
+// e.g. IMPACT_FACTOR_LOOKUP_LENIENT=true.
+const envPrefix = "IMPACT_FACTOR_LOOKUP_"
+
+// Default location of the config file: ~/.config/impact-factor-lookup/config.toml
+// on Linux, or the platform equivalent reported by os.UserConfigDir.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "impact-factor-lookup", "config.toml")
+}
+
+// Read a config file written in a small subset of TOML: `key = value` pairs
+// with string, number, boolean or array values, `#` comments, and optional
+// `[section]` tables. Keys are returned as "key" for the top-level table and
+// "section.key" inside a table. Array values are joined with commas.
+func readConfigFile(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		// Table header
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%s:%d: malformed table header", filename, lineNumber)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", filename, lineNumber)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNumber, err)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Remove a trailing `#` comment, ignoring `#` characters inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Parse a TOML value into the string form expected by flag.Value.Set
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("unterminated array %s", raw)
+		}
+		var items []string
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		// Bare numbers and booleans are passed through as-is
+		return raw, nil
+	}
+}
+
+// Fill in every flag that wasn't given on the command line, first from the
+// environment and then from the config file. Top-level config keys apply to
+// any command that defines a flag of that name; keys inside a `[command]`
+// table apply only to that command and take precedence over top-level ones.
+// The config file location comes from configPath, falling back to the
+// IMPACT_FACTOR_LOOKUP_CONFIG variable and then the default path; a missing
+// default config file is not an error.
+func applyConfig(fs *flag.FlagSet, command, configPath string) error {
+	explicit := configPath != ""
+	if configPath == "" {
+		configPath = os.Getenv(envPrefix + "CONFIG")
+		explicit = configPath != ""
+	}
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+
+	values := map[string]string{}
+	if configPath != "" {
+		var err error
+		values, err = readConfigFile(configPath)
+		if os.IsNotExist(err) && !explicit {
+			values = map[string]string{}
+		} else if err != nil {
+			return fmt.Errorf("error reading config: %v", err)
+		}
+	}
+
+	// Encrypted API keys are decrypted when a provider needs one
+	for key, value := range values {
+		if provider, ok := strings.CutPrefix(key, "keys."); ok {
+			configKeys[provider] = value
+		}
+	}
+
+	// Flags given on the command line always win
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "config" {
+			return
+		}
+		envName := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(envName)
+		source := envName
+		if !ok && command != "" {
+			value, ok = values[command+"."+f.Name]
+			source = configPath
+		}
+		if !ok {
+			source = configPath
+			value, ok = values[f.Name]
+		}
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s from %s: %v", value, f.Name, source, setErr)
+		}
+	})
+	return err
+}
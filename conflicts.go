@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// An ISSN listed by more than one journal (SCImago source ID) in the
+// metrics CSV, usually a data error upstream. Lookups by the ISSN find the
+// journal with the most recent record, Used.
+type ISSNConflict struct {
+	ISSN     string
+	Journals []ISSNClaim
+	Used     int64 // source ID of the journal lookups find
+}
+
+// One of the journals claiming a conflicting ISSN
+type ISSNClaim struct {
+	SourceID int64
+	Title    string
+}
+
+// Note that the journal with sourceID lists an ISSN already claimed by
+// another, the first of which is claimedBy
+func (db *MetricsDatabase) noteISSNConflict(issn issnKey, claimedBy, sourceID int64) {
+	claims, ok := db.issnClaims[issn]
+	if !ok {
+		claims = []int64{claimedBy}
+	}
+	for _, id := range claims {
+		if id == sourceID {
+			return
+		}
+	}
+	db.issnClaims[issn] = append(claims, sourceID)
+}
+
+// The ISSNs listed by more than one journal, in ISSN order
+func (db *MetricsDatabase) ISSNConflicts() []ISSNConflict {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	conflicts := make([]ISSNConflict, 0, len(db.issnClaims))
+	for issn, sourceIDs := range db.issnClaims {
+		conflict := ISSNConflict{ISSN: string(issn[:])}
+		for _, sourceID := range sourceIDs {
+			m, _ := db.lookupSourceID(sourceID)
+			conflict.Journals = append(conflict.Journals, ISSNClaim{SourceID: sourceID, Title: m.Title})
+		}
+		conflict.Used = db.journals[db.byISSN[issn]].SourceID
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].ISSN < conflicts[j].ISSN
+	})
+	return conflicts
+}
+
+// Log a warning for each ISSN listed by more than one journal, so a
+// quartile attributed to the wrong journal doesn't go unnoticed
+func reportISSNConflicts(db *MetricsDatabase) {
+	for _, conflict := range db.ISSNConflicts() {
+		var used ISSNClaim
+		var others []string
+		for _, claim := range conflict.Journals {
+			if claim.SourceID == conflict.Used {
+				used = claim
+			} else {
+				others = append(others, fmt.Sprintf("%s (sourceid %d)", claim.Title, claim.SourceID))
+			}
+		}
+		log.Printf("Warning: ISSN %s is also listed by %s; using %s (sourceid %d)", formatISSN(conflict.ISSN), strings.Join(others, " and "), used.Title, used.SourceID)
+	}
+}
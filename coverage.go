@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Whether the journal was indexed in the given year, according to its
+// SCImago coverage, e.g. "1999-2012, 2015-2023". known is false when the
+// metrics have no coverage or it can't be parsed.
+func (m JournalMetrics) Covers(year int) (covered, known bool) {
+	for _, period := range strings.Split(m.Coverage, ",") {
+		period = strings.TrimSpace(period)
+		if period == "" {
+			continue
+		}
+		from, to, found := strings.Cut(period, "-")
+		if !found {
+			to = from
+		}
+		start, err1 := strconv.Atoi(strings.TrimSpace(from))
+		end, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil {
+			return false, false
+		}
+		known = true
+		if start <= year && year <= end {
+			return true, true
+		}
+	}
+	return false, known
+}
+
+// Warn about publications from years their journal wasn't indexed in,
+// e.g. after it was discontinued, whose metrics are then from another
+// period than the publication. Publications that aren't out yet are
+// skipped. Returns the number of publications warned about.
+func warnUncovered(pubs []Publication, db *MetricsDatabase) int {
+	uncovered := 0
+	for _, pub := range pubs {
+		year, ok := publicationYear(pub)
+		if !ok || forthcomingLabel(pub) != "" {
+			continue
+		}
+		metrics, found := db.LookupISSN(pub.ISSN)
+		if !found {
+			continue
+		}
+		if covered, known := metrics.Covers(year); known && !covered {
+			log.Printf("Warning: publication %s is from %d, but %s is only indexed in %s", pub.ID, year, metrics.Title, metrics.Coverage)
+			uncovered++
+		}
+	}
+	return uncovered
+}
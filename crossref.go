@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Base URL of the Crossref works API
+var crossrefWorksURL = "https://api.crossref.org/works/"
+
+// Base URL of the Crossref journals API
+var crossrefJournalsURL = "https://api.crossref.org/journals/"
+
+// Client for Crossref API requests
+var crossrefClient = &http.Client{Timeout: 30 * time.Second}
+
+// The contact address sent with Crossref requests, which puts them in the
+// "polite" pool, and the Metadata Plus API token, set by crossrefFlags
+var (
+	crossrefMailto string
+	crossrefToken  string
+)
+
+// Define the --mailto and --crossref-token flags of a command that may
+// call Crossref
+func crossrefFlags(fs *flag.FlagSet) {
+	fs.StringVar(&crossrefMailto, "mailto", "", "email address to send with Crossref requests, for its more reliable polite pool")
+	fs.StringVar(&crossrefToken, "crossref-token", "", "Crossref Metadata Plus API token, for higher rate limits")
+}
+
+// A GET request to a Crossref API URL, identifying the tool and carrying
+// the contact address and token when they are given or stored
+func newCrossrefRequest(rawURL string) (*http.Request, error) {
+	userAgent := "impact-factor-lookup (https://github.com/kljensen/impact-factor-lookup)"
+	if crossrefMailto != "" {
+		separator := "?"
+		if strings.Contains(rawURL, "?") {
+			separator = "&"
+		}
+		rawURL += separator + url.Values{"mailto": {crossrefMailto}}.Encode()
+		userAgent = "impact-factor-lookup (https://github.com/kljensen/impact-factor-lookup; mailto:" + crossrefMailto + ")"
+	}
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if token := apiKey("crossref", crossrefToken); token != "" {
+		req.Header.Set("Crossref-Plus-API-Token", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// The parts of a Crossref work record that are used here
+type crossrefWork struct {
+	// Related works by relation type, e.g. "is-preprint-of"
+	Relation map[string][]struct {
+		IDType string `json:"id-type"`
+		ID     string `json:"id"`
+	} `json:"relation"`
+
+	// Funders acknowledged by the work, with the award (grant) numbers
+	Funder []struct {
+		Name  string   `json:"name"`
+		DOI   string   `json:"DOI"`
+		Award []string `json:"award"`
+	} `json:"funder"`
+
+	// How many works Crossref knows of that cite this one
+	IsReferencedByCount int `json:"is-referenced-by-count"`
+}
+
+// Fetch the Crossref record of a DOI. Returns nil if Crossref doesn't know
+// the DOI.
+func fetchCrossrefWork(doi string) (*crossrefWork, error) {
+	var work crossrefWork
+	if found, err := fetchCrossrefMessage(doi, &work); !found {
+		return nil, err
+	}
+	return &work, nil
+}
+
+// Fetch the bibliographic metadata of a DOI from Crossref. Returns nil if
+// Crossref doesn't know the DOI.
+func fetchCrossrefMetadata(doi string) (*crossrefMetadata, error) {
+	var work crossrefMetadata
+	if found, err := fetchCrossrefMessage(doi, &work); !found {
+		return nil, err
+	}
+	return &work, nil
+}
+
+// Fetch the Crossref record of a DOI and decode its message into message.
+// Returns false if Crossref doesn't know the DOI or the request fails.
+func fetchCrossrefMessage(doi string, message any) (bool, error) {
+	req, err := newCrossrefRequest(crossrefWorksURL + url.PathEscape(doiName(doi)))
+	if err != nil {
+		return false, err
+	}
+	resp, err := crossrefClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	response := struct {
+		Message any `json:"message"`
+	}{message}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return false, fmt.Errorf("error parsing Crossref response: %v", err)
+	}
+	return true, nil
+}
+
+// The parts of a Crossref journal record that are used here
+type crossrefJournal struct {
+	Title     string   `json:"title"`
+	Publisher string   `json:"publisher"`
+	ISSNs     []string `json:"ISSN"`
+}
+
+// Fetch the Crossref record of a journal by ISSN. Returns nil if Crossref
+// doesn't know the ISSN.
+func fetchCrossrefJournal(issn string) (*crossrefJournal, error) {
+	req, err := newCrossrefRequest(crossrefJournalsURL + url.PathEscape(formatISSN(normalizeISSN(issn))))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := crossrefClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var response struct {
+		Message crossrefJournal `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing Crossref response: %v", err)
+	}
+	return &response.Message, nil
+}
+
+// A DOI without any resolver URL or "doi:" prefix, lowercased for
+// comparison
+func doiName(doi string) string {
+	return strings.ToLower(strings.TrimPrefix(doiURL(doi), "https://doi.org/"))
+}
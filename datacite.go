@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A DataCite metadata record, as found in DataCite XML (kernel 4) and in the
+// attributes of the DataCite REST API's JSON. Fields that are laid out the
+// same way in both carry both tags.
+type dataCiteResource struct {
+	Identifier struct {
+		Value string `xml:",chardata"`
+		Type  string `xml:"identifierType,attr"`
+	} `xml:"identifier" json:"-"`
+	DOI string `xml:"-" json:"doi"`
+
+	Creators        []dataCiteCreator `xml:"creators>creator" json:"creators"`
+	Titles          []dataCiteTitle   `xml:"titles>title" json:"titles"`
+	Publisher       string            `xml:"publisher" json:"publisher"`
+	PublicationYear json.Number       `xml:"publicationYear" json:"publicationYear"`
+	Subjects        []struct {
+		Subject string `xml:",chardata" json:"subject"`
+	} `xml:"subjects>subject" json:"subjects"`
+	Dates []struct {
+		Date string `xml:",chardata" json:"date"`
+		Type string `xml:"dateType,attr" json:"dateType"`
+	} `xml:"dates>date" json:"dates"`
+	Language     string `xml:"language" json:"language"`
+	Descriptions []struct {
+		Description string `xml:",chardata" json:"description"`
+		Type        string `xml:"descriptionType,attr" json:"descriptionType"`
+	} `xml:"descriptions>description" json:"descriptions"`
+	URL string `xml:"-" json:"url"`
+
+	// Related works, such as the published version of a preprint
+	RelatedIdentifiers []struct {
+		Value        string `xml:",chardata" json:"relatedIdentifier"`
+		Type         string `xml:"relatedIdentifierType,attr" json:"relatedIdentifierType"`
+		RelationType string `xml:"relationType,attr" json:"relationType"`
+	} `xml:"relatedIdentifiers>relatedIdentifier" json:"relatedIdentifiers"`
+
+	// Other identifiers, such as arXiv's
+	AlternateIdentifiers []struct {
+		Value string `xml:",chardata"`
+		Type  string `xml:"alternateIdentifierType,attr"`
+	} `xml:"alternateIdentifiers>alternateIdentifier" json:"-"`
+	Identifiers []struct {
+		Value string `json:"identifier"`
+		Type  string `json:"identifierType"`
+	} `xml:"-" json:"identifiers"`
+
+	// The general resource type, e.g. Dataset or Preprint
+	ResourceType struct {
+		General string `xml:"resourceTypeGeneral,attr"`
+	} `xml:"resourceType" json:"-"`
+	Types struct {
+		General string `json:"resourceTypeGeneral"`
+	} `xml:"-" json:"types"`
+}
+
+type dataCiteCreator struct {
+	Name struct {
+		Value string `xml:",chardata"`
+		Type  string `xml:"nameType,attr"`
+	} `xml:"creatorName" json:"-"`
+	DisplayName     string `xml:"-" json:"name"`
+	NameType        string `xml:"-" json:"nameType"`
+	GivenName       string `xml:"givenName" json:"givenName"`
+	FamilyName      string `xml:"familyName" json:"familyName"`
+	NameIdentifiers []struct {
+		Value  string `xml:",chardata" json:"nameIdentifier"`
+		Scheme string `xml:"nameIdentifierScheme,attr" json:"nameIdentifierScheme"`
+	} `xml:"nameIdentifier" json:"nameIdentifiers"`
+	Affiliations []dataCiteAffiliation `xml:"affiliation" json:"affiliation"`
+}
+
+// A creator's affiliation. The REST API gives affiliations as plain names
+// unless asked for them as objects with ?affiliation=true.
+type dataCiteAffiliation struct {
+	Name       string `xml:",chardata" json:"name"`
+	Identifier string `xml:"affiliationIdentifier,attr" json:"affiliationIdentifier"`
+	Scheme     string `xml:"affiliationIdentifierScheme,attr" json:"affiliationIdentifierScheme"`
+}
+
+func (a *dataCiteAffiliation) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &a.Name)
+	}
+	type plain dataCiteAffiliation
+	return json.Unmarshal(data, (*plain)(a))
+}
+
+type dataCiteTitle struct {
+	Title string `xml:",chardata" json:"title"`
+	Type  string `xml:"titleType,attr" json:"titleType"`
+}
+
+// BibTeX entry types for DataCite resource types. Other types become @misc.
+var dataCiteEntryTypes = map[string]string{
+	"Dataset":        "dataset",
+	"JournalArticle": "",
+	"DataPaper":      "",
+}
+
+// Map a DataCite record onto a Publication
+func (r dataCiteResource) Publication() Publication {
+	pub := Publication{
+		DOI:       r.DOI,
+		Publisher: strings.TrimSpace(r.Publisher),
+		Language:  strings.TrimSpace(r.Language),
+		URL:       r.URL,
+		Type:      r.Types.General,
+	}
+	if strings.EqualFold(r.Identifier.Type, "DOI") {
+		pub.DOI = strings.TrimSpace(r.Identifier.Value)
+	}
+	pub.ID = pub.DOI
+	for _, id := range r.AlternateIdentifiers {
+		if strings.EqualFold(id.Type, "arXiv") {
+			pub.ArXiv = strings.TrimSpace(id.Value)
+		}
+	}
+	for _, related := range r.RelatedIdentifiers {
+		if related.RelationType == "IsPreprintOf" && strings.EqualFold(related.Type, "DOI") {
+			pub.PublishedVersion = strings.TrimSpace(related.Value)
+		}
+	}
+	for _, id := range r.Identifiers {
+		if strings.EqualFold(id.Type, "arXiv") {
+			pub.ArXiv = strings.TrimSpace(id.Value)
+		}
+	}
+	if r.ResourceType.General != "" {
+		pub.Type = r.ResourceType.General
+	}
+	entryType, ok := dataCiteEntryTypes[pub.Type]
+	if !ok {
+		entryType = "misc"
+	}
+	pub.EntryType = entryType
+
+	for _, title := range r.Titles {
+		switch {
+		case title.Type == "" && pub.Title == "":
+			pub.Title = strings.TrimSpace(title.Title)
+		case title.Type == "Subtitle" && pub.Subtitle == "":
+			pub.Subtitle = strings.TrimSpace(title.Title)
+		}
+	}
+
+	for _, creator := range r.Creators {
+		displayName, nameType := creator.DisplayName, creator.NameType
+		if creator.Name.Value != "" {
+			displayName, nameType = creator.Name.Value, creator.Name.Type
+		}
+		displayName = strings.TrimSpace(displayName)
+
+		var person Person
+		switch {
+		case nameType == "Organizational":
+			person = Person{PersonName: PersonName{FamilyNames: displayName}, Organization: true}
+		case creator.FamilyName != "":
+			person = Person{PersonName: PersonName{FamilyNames: creator.FamilyName, FirstNames: creator.GivenName}}
+		default:
+			// Only a display name, as "Family, Given"
+			family, given, _ := strings.Cut(displayName, ",")
+			person = Person{PersonName: PersonName{FamilyNames: strings.TrimSpace(family), FirstNames: strings.TrimSpace(given)}}
+		}
+		for _, id := range creator.NameIdentifiers {
+			if strings.EqualFold(id.Scheme, "ORCID") {
+				person.ORCID = strings.TrimSpace(id.Value)
+			}
+		}
+		author := Author{Person: person}
+		for _, affiliation := range creator.Affiliations {
+			unit := OrgUnit{Name: strings.TrimSpace(affiliation.Name)}
+			if strings.EqualFold(affiliation.Scheme, "ROR") {
+				unit.ROR = affiliation.Identifier
+			}
+			author.Affiliations = append(author.Affiliations, unit)
+		}
+		pub.Authors.AuthorList = append(pub.Authors.AuthorList, author)
+	}
+
+	// The issue date is the closest to a publication date; otherwise only
+	// the year is known
+	pub.Date = strings.TrimSpace(r.PublicationYear.String())
+	for _, date := range r.Dates {
+		if date.Type == "Issued" && strings.TrimSpace(date.Date) != "" {
+			pub.Date = strings.TrimSpace(date.Date)
+			break
+		}
+	}
+
+	for _, subject := range r.Subjects {
+		pub.Keywords = append(pub.Keywords, subject.Subject)
+	}
+	for _, description := range r.Descriptions {
+		if description.Type == "Abstract" {
+			pub.Abstract = strings.TrimSpace(description.Description)
+			break
+		}
+	}
+	return pub
+}
+
+// Read publications from DataCite REST API JSON: a response for a single
+// DOI ({"data": {...}}), a list response ({"data": [...]}), or an array of
+// such items
+func readDataCiteJSON(r io.Reader) ([]Publication, error) {
+	type item struct {
+		Attributes dataCiteResource `json:"attributes"`
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error parsing DataCite JSON: %v", err)
+	}
+
+	var items []item
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &response); err == nil && response.Data != nil {
+		raw = response.Data
+	}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		var single item
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, fmt.Errorf("error parsing DataCite JSON: %v", err)
+		}
+		items = []item{single}
+	}
+
+	pubs := make([]Publication, 0, len(items))
+	for _, item := range items {
+		pubs = append(pubs, item.Attributes.Publication())
+	}
+	return pubs, nil
+}
+
+// Whether the buffered input starts with a JSON object or array rather
+// than XML
+func isJSON(r *bufio.Reader) bool {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		case '{', '[':
+			return true
+		default:
+			return false
+		}
+	}
+}
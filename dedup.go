@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Which record an ISSN finds when several rows of the metrics CSV list it,
+// such as a journal's rows for each year
+type DedupStrategy int
+
+const (
+	// The most recent year's record
+	DedupNewest DedupStrategy = iota
+	// Like DedupNewest, but every record listing the ISSN is kept for
+	// LookupISSNAll
+	DedupKeepAll
+	// The record with the highest SJR, then the most recent
+	DedupHigherSJR
+	// The record for MetricsReadOptions.Year, or the most recent when no
+	// row for the ISSN is from that year
+	DedupPreferYear
+	// Like DedupNewest, but ReadMetricsCSV fails when different journals
+	// list the same ISSN
+	DedupErrorOnConflict
+)
+
+// Whether the ISSN index should find record rather than found, according
+// to the database's DedupStrategy
+func (db *MetricsDatabase) prefers(record, found JournalMetrics) bool {
+	switch db.dedup {
+	case DedupHigherSJR:
+		if greater(record.SJR, found.SJR) {
+			return true
+		}
+		if greater(found.SJR, record.SJR) {
+			return false
+		}
+	case DedupPreferYear:
+		if found.Year == db.dedupYear {
+			return false
+		}
+		if record.Year == db.dedupYear {
+			return true
+		}
+	}
+	return found.Year < record.Year
+}
+
+// Every record listing an ISSN, in the order they were read, when the
+// database was read with DedupKeepAll; otherwise the one record LookupISSN
+// finds
+func (db *MetricsDatabase) LookupISSNAll(issn string) []JournalMetrics {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if key, ok := makeISSNKey(issn); ok && len(db.issnAll[key]) > 0 {
+		records := make([]JournalMetrics, 0, len(db.issnAll[key]))
+		for _, index := range db.issnAll[key] {
+			records = append(records, db.journals[index])
+		}
+		return records
+	}
+	if m, ok := db.lookupISSN(issn); ok {
+		return []JournalMetrics{m}
+	}
+	return nil
+}
+
+// The error for a database read with DedupErrorOnConflict in which
+// different journals list the same ISSN, or nil
+func conflictError(conflicts []ISSNConflict) error {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	var journals []string
+	for _, claim := range conflicts[0].Journals {
+		journals = append(journals, fmt.Sprintf("%s (sourceid %d)", claim.Title, claim.SourceID))
+	}
+	err := fmt.Errorf("ISSN %s is listed by %s", formatISSN(conflicts[0].ISSN), strings.Join(journals, " and "))
+	if len(conflicts) > 1 {
+		err = fmt.Errorf("%v, and %d other ISSNs by more than one journal", err, len(conflicts)-1)
+	}
+	return err
+}
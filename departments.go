@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The publications of one department, with their metrics summary
+type DepartmentReport struct {
+	Department string `json:"department"`
+	MetricsSummary
+	Q1Share float64 `json:"q1_share"` // share of the publications with a known quartile that are in Q1
+}
+
+// An author's department, from a --departments mapping file
+type departmentAssignment struct {
+	author     selfMatcher
+	department string
+}
+
+// Load an author→department mapping from a CSV file of "author,department"
+// rows, where the author is given as for --self: "Family, Initials" or an
+// ORCID iD. An author may appear in several rows. A header row is skipped.
+func loadDepartmentMapping(filename string) ([]departmentAssignment, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening department mapping: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading department mapping: %v", err)
+	}
+	var mapping []departmentAssignment
+	for i, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		author := strings.TrimSpace(record[0])
+		department := strings.Join(strings.Fields(record[1]), " ")
+		if i == 0 && strings.EqualFold(author, "author") {
+			continue
+		}
+		if author == "" || department == "" {
+			continue
+		}
+		mapping = append(mapping, departmentAssignment{author: parseSelf(author), department: department})
+	}
+	return mapping, nil
+}
+
+// A function giving the departments of a publication's authors: those in
+// the mapping when one is given, and otherwise the organisational units in
+// the publication's metadata
+func departmentsOf(mapping []departmentAssignment) func(Publication) []string {
+	if mapping == nil {
+		return orgUnits
+	}
+	return func(pub Publication) []string {
+		seen := map[string]bool{}
+		var departments []string
+		for _, author := range pub.Authors.AuthorList {
+			for _, assignment := range mapping {
+				if assignment.author.matches(author) && !seen[assignment.department] {
+					seen[assignment.department] = true
+					departments = append(departments, assignment.department)
+				}
+			}
+		}
+		return departments
+	}
+}
+
+// Group the publications by the departments given by departments and
+// summarize each group. A publication counts towards each of its
+// departments. Departments are ordered by name, and publications without
+// one come last in a group with an empty Department.
+func buildDepartmentReports(pubs []Publication, db *MetricsDatabase, departments func(Publication) []string) []DepartmentReport {
+	groups := map[string][]Publication{}
+	for _, pub := range pubs {
+		names := departments(pub)
+		for _, name := range names {
+			groups[name] = append(groups[name], pub)
+		}
+		if len(names) == 0 {
+			groups[""] = append(groups[""], pub)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "") != (names[j] == "") {
+			return names[j] == ""
+		}
+		return names[i] < names[j]
+	})
+
+	reports := make([]DepartmentReport, 0, len(names))
+	for _, name := range names {
+		report := DepartmentReport{Department: name, MetricsSummary: summarize(groups[name], db)}
+		if ranked := report.Publications - report.Quartiles["unknown"]; ranked > 0 {
+			report.Q1Share = float64(report.Quartiles["Q1"]) / float64(ranked)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Write the per-department summaries as an aligned table
+func writeDepartmentReportsText(w io.Writer, departments []DepartmentReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Department\tPublications\tWith metrics\tQ1\tQ1 share\tMean SJR")
+	for _, department := range departments {
+		name := department.Department
+		if name == "" {
+			name = "(no department)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f%%\t%.3f\n", name,
+			department.Publications, department.WithMetrics, department.Quartiles["Q1"],
+			100*department.Q1Share, department.MeanSJR)
+	}
+	return tw.Flush()
+}
+
+// Write the per-department summaries as CSV, one row per department.
+// Publications without a department have an empty department column.
+func writeDepartmentReportsCSV(w io.Writer, departments []DepartmentReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"department", "publications", "with_metrics", "q1", "q2", "q3", "q4", "unknown_quartile", "q1_share", "mean_sjr"})
+	for _, department := range departments {
+		writer.Write([]string{
+			department.Department,
+			strconv.Itoa(department.Publications),
+			strconv.Itoa(department.WithMetrics),
+			strconv.Itoa(department.Quartiles["Q1"]),
+			strconv.Itoa(department.Quartiles["Q2"]),
+			strconv.Itoa(department.Quartiles["Q3"]),
+			strconv.Itoa(department.Quartiles["Q4"]),
+			strconv.Itoa(department.Quartiles["unknown"]),
+			strconv.FormatFloat(department.Q1Share, 'f', 3, 64),
+			strconv.FormatFloat(department.MeanSJR, 'f', 3, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// The bibliographic metadata of a Crossref work record, for publications
+// read from a list of DOIs
+type crossrefMetadata struct {
+	DOI            string   `json:"DOI"`
+	Type           string   `json:"type"`
+	Subtype        string   `json:"subtype"`
+	Title          []string `json:"title"`
+	Subtitle       []string `json:"subtitle"`
+	ContainerTitle []string `json:"container-title"`
+	ISSNType       []struct {
+		Value string `json:"value"`
+		Type  string `json:"type"` // "print" or "electronic"
+	} `json:"issn-type"`
+	ISSN   []string `json:"ISSN"`
+	Author []struct {
+		Given       string `json:"given"`
+		Family      string `json:"family"`
+		Name        string `json:"name"` // organizations
+		ORCID       string `json:"ORCID"`
+		Affiliation []struct {
+			Name string `json:"name"`
+		} `json:"affiliation"`
+	} `json:"author"`
+	Published crossrefDate `json:"published"`
+	Issued    crossrefDate `json:"issued"`
+	Volume    string       `json:"volume"`
+	Issue     string       `json:"issue"`
+	URL       string       `json:"URL"`
+	Language  string       `json:"language"`
+	Publisher string       `json:"publisher"`
+	Abstract  string       `json:"abstract"` // JATS markup
+	Subject   []string     `json:"subject"`
+}
+
+// A Crossref partial date, e.g. {"date-parts": [[2021, 3, 15]]}
+type crossrefDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// The date as YYYY, YYYY-MM or YYYY-MM-DD, or "" when unknown
+func (d crossrefDate) String() string {
+	if len(d.DateParts) == 0 || len(d.DateParts[0]) == 0 || d.DateParts[0][0] == 0 {
+		return ""
+	}
+	parts := d.DateParts[0]
+	date := strconv.Itoa(parts[0])
+	for _, part := range parts[1:min(len(parts), 3)] {
+		date += "-" + twoDigits(part)
+	}
+	return date
+}
+
+// n with a leading zero when below ten
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// BibTeX entry types for Crossref work types. Other types become @misc.
+var crossrefEntryTypes = map[string]string{
+	"journal-article":     "",
+	"proceedings-article": "inproceedings",
+	"book-chapter":        "incollection",
+	"book":                "book",
+	"monograph":           "book",
+	"dataset":             "dataset",
+}
+
+// Map a Crossref work record onto a Publication
+func (w crossrefMetadata) Publication() Publication {
+	pub := Publication{
+		ID:        w.DOI,
+		DOI:       w.DOI,
+		Type:      w.Type,
+		Volume:    w.Volume,
+		Issue:     w.Issue,
+		URL:       w.URL,
+		Language:  w.Language,
+		Publisher: w.Publisher,
+		Keywords:  w.Subject,
+		Abstract:  stripMarkup(w.Abstract),
+	}
+	if len(w.Title) > 0 {
+		pub.Title = stripMarkup(w.Title[0])
+	}
+	if len(w.Subtitle) > 0 {
+		pub.Subtitle = stripMarkup(w.Subtitle[0])
+	}
+	entryType, ok := crossrefEntryTypes[w.Type]
+	if !ok {
+		entryType = "misc"
+	}
+	pub.EntryType = entryType
+	if w.Type == "posted-content" && w.Subtype == "preprint" {
+		pub.Type = "Preprint"
+	}
+
+	// Journal articles are looked up by the journal's print ISSN, or its
+	// electronic one
+	if len(w.ContainerTitle) > 0 && w.Type == "journal-article" {
+		pub.Published.Publication.Title = strings.TrimSpace(w.ContainerTitle[0])
+	}
+	for _, issn := range w.ISSNType {
+		if pub.ISSN == "" || issn.Type == "print" {
+			pub.ISSN = issn.Value
+		}
+	}
+	if pub.ISSN == "" && len(w.ISSN) > 0 {
+		pub.ISSN = w.ISSN[0]
+	}
+
+	for _, a := range w.Author {
+		person := Person{PersonName: PersonName{FamilyNames: a.Family, FirstNames: a.Given}, ORCID: a.ORCID}
+		if a.Family == "" && a.Name != "" {
+			person = Person{PersonName: PersonName{FamilyNames: a.Name}, Organization: true}
+		}
+		author := Author{Person: person}
+		for _, affiliation := range a.Affiliation {
+			author.Affiliations = append(author.Affiliations, OrgUnit{Name: strings.TrimSpace(affiliation.Name)})
+		}
+		pub.Authors.AuthorList = append(pub.Authors.AuthorList, author)
+	}
+
+	pub.Date = w.Published.String()
+	if pub.Date == "" {
+		pub.Date = w.Issued.String()
+	}
+	return pub
+}
+
+// Whether a line holds a DOI, bare or as a resolver URL or "doi:" name
+func isDOI(line string) bool {
+	name := doiName(line)
+	return strings.HasPrefix(name, "10.") && strings.Contains(name, "/") && !strings.ContainsAny(name, " \t,;")
+}
+
+// Whether the input is a plain text list of DOIs, going by its first line
+// that isn't blank or a # comment
+func isDOIList(r *bufio.Reader) bool {
+	b, _ := r.Peek(4096)
+	for _, line := range strings.Split(string(bytes.TrimPrefix(b, []byte("\ufeff"))), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return isDOI(line)
+		}
+	}
+	return false
+}
+
+// Read a plain text list of DOIs, one per line, and fetch the metadata of
+// each from Crossref. Blank lines and # comments are ignored, as are
+// repeated DOIs. DOIs that can't be looked up, or that Crossref doesn't
+// know, are skipped with a warning and counted; other lines are an error
+// unless lenient is true, when they are skipped the same way.
+func readDOIList(r *bufio.Reader, lenient bool) ([]Publication, int, error) {
+	var pubs []Publication
+	skipped := 0
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || seen[doiName(line)] {
+			continue
+		}
+		if !isDOI(line) {
+			if !lenient {
+				return nil, skipped, fmt.Errorf("line %d of the DOI list isn't a DOI: %q", lineNumber, line)
+			}
+			log.Printf("Warning: skipping line %d of the DOI list, which isn't a DOI: %q", lineNumber, line)
+			skipped++
+			continue
+		}
+		seen[doiName(line)] = true
+		work, err := fetchCrossrefMetadata(line)
+		switch {
+		case err != nil:
+			log.Printf("Warning: skipping %s: %v", line, err)
+		case work == nil:
+			log.Printf("Warning: skipping %s, which Crossref doesn't know", line)
+		default:
+			pubs = append(pubs, work.Publication())
+			continue
+		}
+		skipped++
+	}
+	return pubs, skipped, scanner.Err()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// One row of an Eigenfactor dataset, such as the downloads from
+// eigenfactor.org, which are keyed by ISSN
+type eigenfactorRow struct {
+	ISSNs            []string
+	Year             int64 // 0 when the dataset doesn't say
+	Eigenfactor      *float64
+	ArticleInfluence *float64
+}
+
+// The columns of an Eigenfactor dataset, found by their headings. Columns
+// that are missing are -1.
+type eigenfactorColumns struct {
+	eigenfactor, articleInfluence, year int
+	issns                               []int
+}
+
+func findEigenfactorColumns(header []string) (eigenfactorColumns, error) {
+	cols := eigenfactorColumns{eigenfactor: -1, articleInfluence: -1, year: -1}
+	for i, heading := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(heading, "\ufeff")))
+		name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == ' ' || r == '_' || r == '-' }), "")
+		switch name {
+		case "eigenfactor", "eigenfactorscore", "ef":
+			cols.eigenfactor = i
+		case "articleinfluence", "articleinfluencescore", "ai":
+			cols.articleInfluence = i
+		case "year":
+			cols.year = i
+		case "issn", "printissn", "eissn":
+			cols.issns = append(cols.issns, i)
+		}
+	}
+	if cols.eigenfactor < 0 && cols.articleInfluence < 0 {
+		return cols, fmt.Errorf("no Eigenfactor or Article Influence column in the header")
+	}
+	if len(cols.issns) == 0 {
+		return cols, fmt.Errorf("no ISSN column in the header")
+	}
+	return cols, nil
+}
+
+func parseEigenfactorRecord(record []string, cols eigenfactorColumns) (eigenfactorRow, error) {
+	var row eigenfactorRow
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var err error
+	if s := field(cols.year); s != "" {
+		if row.Year, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return row, fmt.Errorf("error parsing year: %v", err)
+		}
+	}
+	for _, i := range cols.issns {
+		row.ISSNs = append(row.ISSNs, parseISSNs(field(i))...)
+	}
+	if row.Eigenfactor, err = parseMetricValue(field(cols.eigenfactor)); err != nil {
+		return row, fmt.Errorf("error parsing Eigenfactor: %v", err)
+	}
+	if row.ArticleInfluence, err = parseMetricValue(field(cols.articleInfluence)); err != nil {
+		return row, fmt.Errorf("error parsing Article Influence: %v", err)
+	}
+	return row, nil
+}
+
+// Add the scores of a row to the journal with one of its ISSNs: to its
+// record for the same year, or its most recent record when the year is
+// unknown or the journal has none for that year. Returns false when the
+// journal isn't in the database.
+func (db *MetricsDatabase) addEigenfactor(row eigenfactorRow) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var index int32
+	ok := false
+	for _, issn := range row.ISSNs {
+		var key issnKey
+		if key, ok = makeISSNKey(issn); ok {
+			if index, ok = db.byISSN[key]; ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return false
+	}
+	if row.Year != 0 {
+		if found, ok := db.records[sourceYear{db.journals[index].SourceID, row.Year}]; ok {
+			index = found
+		}
+	}
+	m := &db.journals[index]
+	if row.Eigenfactor != nil {
+		m.Eigenfactor = row.Eigenfactor
+	}
+	if row.ArticleInfluence != nil {
+		m.ArticleInfluence = row.ArticleInfluence
+	}
+	return true
+}
+
+// Add the Eigenfactor and Article Influence scores of a dataset keyed by
+// ISSN to the journals in the database. Journals missing from the database
+// are counted, not added. With WithLenient, malformed rows are skipped with
+// a warning; the number of skipped rows is returned.
+func (db *MetricsDatabase) ReadEigenfactorCSV(filename string, options ...MetricsOption) (unmatched, skipped int, err error) {
+	opts := newMetricsReadOptions(options)
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading header: %v", err)
+	}
+	cols, err := findEigenfactorColumns(header)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var row eigenfactorRow
+		if err != nil {
+			err = fmt.Errorf("error reading record: %v", err)
+		} else if row, err = parseEigenfactorRecord(record, cols); err != nil {
+			line, _ := reader.FieldPos(0)
+			err = fmt.Errorf("line %d: %v", line, err)
+		}
+		if err != nil {
+			if !opts.Lenient {
+				return unmatched, skipped, err
+			}
+			log.Printf("Warning: skipping Eigenfactor row: %v", err)
+			skipped++
+			continue
+		}
+		if !db.addEigenfactor(row) {
+			unmatched++
+		}
+	}
+	return unmatched, skipped, nil
+}
+
+// Add an Eigenfactor dataset to a loaded database, reporting skipped rows
+// and journals that weren't found
+func loadEigenfactor(db *MetricsDatabase, filename string, lenient bool) error {
+	unmatched, skipped, err := db.ReadEigenfactorCSV(filename, lenientOptions(lenient)...)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed Eigenfactor rows", skipped)
+	}
+	if unmatched > 0 {
+		log.Printf("%d rows of %s are for journals not in the metrics csv", unmatched, filename)
+	}
+	return nil
+}
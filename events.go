@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Base URL of the Crossref Event Data query API
+var eventDataURL = "https://api.eventdata.crossref.org/v1/events"
+
+// Engagement with a set of publications beyond citations, counted from the
+// Event Data events of the publications that were looked up
+type EventSummary struct {
+	Publications int            `json:"publications"` // publications looked up
+	Events       int            `json:"events"`
+	BySource     map[string]int `json:"by_source"` // e.g. "twitter", "newsfeed", "wikipedia"
+}
+
+// Fetch the number of Event Data events about a DOI (mentions on social
+// media, in news, on Wikipedia, ...) by source
+func fetchEventCounts(doi string) (map[string]int, error) {
+	query := url.Values{
+		"obj-id": {doiName(doi)},
+		"rows":   {"0"},
+		"facet":  {"source:*"},
+	}
+	req, err := newCrossrefRequest(eventDataURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := crossrefClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var response struct {
+		Message struct {
+			Facets struct {
+				Source struct {
+					Values map[string]int `json:"values"`
+				} `json:"source"`
+			} `json:"facets"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error parsing Event Data response: %v", err)
+	}
+	counts := response.Message.Facets.Source.Values
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	return counts, nil
+}
+
+// Fill in the Event Data event counts of publications with a DOI. Lookup
+// failures are logged and leave the counts unset.
+func enrichEventsFromCrossref(pubs []Publication) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if pub.Events != nil || pub.DOI == "" {
+			continue
+		}
+		counts, err := fetchEventCounts(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up the Event Data events of %s: %v", pub.DOI, err)
+			continue
+		}
+		pub.Events = counts
+	}
+}
+
+// Add up the event counts of the publications. Returns nil when none of
+// them was looked up.
+func summarizeEvents(pubs []Publication) *EventSummary {
+	summary := &EventSummary{BySource: map[string]int{}}
+	for _, pub := range pubs {
+		if pub.Events == nil {
+			continue
+		}
+		summary.Publications++
+		for source, n := range pub.Events {
+			summary.Events += n
+			summary.BySource[source] += n
+		}
+	}
+	if summary.Publications == 0 {
+		return nil
+	}
+	return summary
+}
+
+// The sources of an EventSummary, most events first
+func (s EventSummary) sources() []string {
+	sources := make([]string, 0, len(s.BySource))
+	for source := range s.BySource {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if s.BySource[sources[i]] != s.BySource[sources[j]] {
+			return s.BySource[sources[i]] > s.BySource[sources[j]]
+		}
+		return sources[i] < sources[j]
+	})
+	return sources
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+)
+
+// Exit codes, so scripts and CI pipelines can tell failures apart
+const (
+	exitError    = 1 // I/O and other unexpected errors
+	exitUsage    = 2 // bad flags, arguments or configuration
+	exitParse    = 3 // the metrics CSV or publication XML couldn't be parsed
+	exitMissRate = 4 // too many publications lack journal metrics
+	exitInvalid  = 5 // the generated BibTeX failed validation
+
+	// Lookups of specific journals that found nothing share the miss-rate
+	// exit code
+	exitLookupFailed = exitMissRate
+
+	// Runs cut short by a signal follow the shell convention of 128 plus
+	// the signal number
+	exitInterrupted = 130 // SIGINT
+	exitTerminated  = 143 // SIGTERM
+)
+
+// Log a message and exit with the given code
+func fatalf(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// The exit code for an error returned while reading an input file: files
+// that can't be opened or read are I/O errors, anything else is a parse
+// failure
+func inputExitCode(err error) int {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return exitError
+	}
+	return exitParse
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// A publication in both exports whose metadata differs
+type PublicationChange struct {
+	Old    Publication
+	New    Publication
+	Fields []string // names of the fields that differ, e.g. "title"
+}
+
+// The publications added to, removed from and changed between two exports,
+// in the order of the export they are taken from
+type ExportDiff struct {
+	Added   []Publication
+	Removed []Publication
+	Changed []PublicationChange
+}
+
+// The key identifying a publication across exports: its DOI, or its record
+// ID when it has none, or its title when it has neither
+func publicationKey(pub Publication) string {
+	switch {
+	case pub.DOI != "":
+		return "doi:" + doiName(pub.DOI)
+	case pub.ID != "":
+		return "id:" + pub.ID
+	default:
+		return "title:" + normalizeTitle(pub.Title)
+	}
+}
+
+// The names of the fields that differ between two versions of a
+// publication
+func changedFields(old, new Publication) []string {
+	authors := func(pub Publication) string {
+		var names []string
+		for _, author := range pub.Authors.AuthorList {
+			names = append(names, author.Person.PersonName.FamilyNames+", "+author.Person.PersonName.FirstNames)
+		}
+		return strings.Join(names, "; ")
+	}
+	var fields []string
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"title", old.Title, new.Title},
+		{"authors", authors(old), authors(new)},
+		{"journal", old.Published.Publication.Title, new.Published.Publication.Title},
+		{"date", old.Date, new.Date},
+		{"volume", old.Volume, new.Volume},
+		{"issue", old.Issue, new.Issue},
+		{"doi", old.DOI, new.DOI},
+		{"issn", old.ISSN, new.ISSN},
+		{"url", old.URL, new.URL},
+		{"status", old.Status, new.Status},
+	} {
+		if strings.Join(strings.Fields(field.old), " ") != strings.Join(strings.Fields(field.new), " ") {
+			fields = append(fields, field.name)
+		}
+	}
+	return fields
+}
+
+// Compare two exports, matching publications by publicationKey
+func diffExports(old, new []Publication) ExportDiff {
+	oldByKey := map[string]Publication{}
+	for _, pub := range old {
+		oldByKey[publicationKey(pub)] = pub
+	}
+	newKeys := map[string]bool{}
+
+	var diff ExportDiff
+	for _, pub := range new {
+		key := publicationKey(pub)
+		newKeys[key] = true
+		previous, ok := oldByKey[key]
+		if !ok {
+			diff.Added = append(diff.Added, pub)
+		} else if fields := changedFields(previous, pub); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, PublicationChange{Old: previous, New: pub, Fields: fields})
+		}
+	}
+	for _, pub := range old {
+		if !newKeys[publicationKey(pub)] {
+			diff.Removed = append(diff.Removed, pub)
+		}
+	}
+	return diff
+}
+
+// Write the differences as a readable list, one section each for added,
+// removed and changed publications
+func writeExportDiffText(w io.Writer, diff ExportDiff) error {
+	describe := func(pub Publication) string {
+		s := createCitationKey(pub) + "  " + pub.Title
+		if pub.DOI != "" {
+			s += " (" + doiURL(pub.DOI) + ")"
+		}
+		return s
+	}
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "Added publications: %d\n", len(diff.Added))
+	for _, pub := range diff.Added {
+		fmt.Fprintf(buffered, "+ %s\n", describe(pub))
+	}
+	fmt.Fprintf(buffered, "\nRemoved publications: %d\n", len(diff.Removed))
+	for _, pub := range diff.Removed {
+		fmt.Fprintf(buffered, "- %s\n", describe(pub))
+	}
+	fmt.Fprintf(buffered, "\nChanged publications: %d\n", len(diff.Changed))
+	for _, change := range diff.Changed {
+		fmt.Fprintf(buffered, "~ %s [%s]\n", describe(change.New), strings.Join(change.Fields, ", "))
+	}
+	return buffered.Flush()
+}
+
+// The `diff` subcommand: the publications added, removed or changed
+// between two XML exports, such as two harvests by `serve --refresh`
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows and XML records instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv, for the metrics of --format other than text")
+	sources := metricsSourceFlags(fs)
+	format := fs.String("format", "text", "output format: text (a list of the differences), or bibtex, json, latex, atom, or zotero-rdf for the added and changed publications")
+	repoProfile := fs.String("repo-profile", "", "repository platform whose metadata quirks to handle: dspace, eprints, or pure")
+	metadataFormat := fs.String("metadata-format", "auto", "metadata format of the paper records: auto, cerif, datacite, mods, marcxml, csv, or doi")
+	crossrefFlags(fs)
+	network := networkFlags(fs)
+	fs.Usage = func() {
+		log.Printf("Usage: %s diff [flags] <old xml filename> <new xml filename> [impact factor csv]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "diff", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+
+	output, ok := outputFormats[*format]
+	if *format != "text" && (!ok || output.Companion != nil) {
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if err := applyRepoProfileFlags(fs, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if !metadataFormats[*metadataFormat] {
+		log.Printf("Unknown metadata format %q", *metadataFormat)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	diffArgs := fs.Args()
+	if len(diffArgs) == 2 && *metricsPath != "" {
+		diffArgs = append(diffArgs, *metricsPath)
+	}
+	if len(diffArgs) != 2 && len(diffArgs) != 3 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	read := func(filename string) []Publication {
+		xmlFile, err := os.Open(filename)
+		if err != nil {
+			fatalf(exitError, "Error reading file: %v", err)
+		}
+		defer xmlFile.Close()
+		pubs, skipped, err := ReadPublications(xmlFile, *metadataFormat, *lenient)
+		if err != nil {
+			fatalf(exitParse, "Error parsing %s: %v", filename, err)
+		}
+		if skipped > 0 {
+			log.Printf("Skipped %d malformed XML records in %s", skipped, filename)
+		}
+		if profile, ok := repoProfiles[*repoProfile]; ok {
+			applyRepoProfile(pubs, profile)
+		}
+		return pubs
+	}
+	diff := diffExports(read(diffArgs[0]), read(diffArgs[1]))
+
+	if *format == "text" {
+		if err := writeExportDiffText(os.Stdout, diff); err != nil {
+			fatalf(exitError, "%v", err)
+		}
+		return
+	}
+
+	// The other formats carry the new versions of the added and changed
+	// publications, so removals are only reported here
+	journalDB := NewMetricsDatabase()
+	if len(diffArgs) == 3 {
+		var err error
+		if journalDB, err = loadMetrics(diffArgs[2], *sources, *lenient); err != nil {
+			fatalf(inputExitCode(err), "%v", err)
+		}
+	}
+	if len(diff.Removed) > 0 {
+		log.Printf("%d publications were removed", len(diff.Removed))
+	}
+	pubs := diff.Added
+	for _, change := range diff.Changed {
+		pubs = append(pubs, change.New)
+	}
+
+	buffered := bufio.NewWriter(os.Stdout)
+	if output.Sort != nil {
+		output.Sort(pubs)
+	}
+	buffered.WriteString(output.begin(pubs))
+	first := true
+	renderEntries(pubs, journalDB, output.Entry, defaultBibtexOptions(), 1, func(r renderedEntry) {
+		if r.Err != nil {
+			fatalf(exitError, "Error rendering publication %s: %v", r.Pub.ID, r.Err)
+		}
+		if !first {
+			buffered.WriteString(output.Delimiter)
+		}
+		first = false
+		buffered.WriteString(r.Entry)
+		buffered.WriteString(output.Separator)
+	})
+	buffered.WriteString(output.End)
+	if err := buffered.Flush(); err != nil {
+		fatalf(exitError, "Error writing output: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// How a publication in a journal listed under several subject fields is
+// counted in the per-field summaries
+type fieldAttribution string
+
+const (
+	attributePrimary    fieldAttribution = "primary"    // to the journal's best field only
+	attributeFractional fieldAttribution = "fractional" // an equal share to each field
+	attributeAll        fieldAttribution = "all"        // fully to each field
+)
+
+var fieldAttributions = map[fieldAttribution]bool{
+	attributePrimary:    true,
+	attributeFractional: true,
+	attributeAll:        true,
+}
+
+// The publications attributed to one subject field. Counts are fractional
+// with --field-attribution fractional, and quartiles are those of the
+// journals within this field rather than their best ones.
+type FieldReport struct {
+	Field        int64              `json:"field"` // ASJC code, or 0 for publications without a field
+	Name         string             `json:"name,omitempty"`
+	Publications float64            `json:"publications"`
+	Quartiles    map[string]float64 `json:"quartiles"` // "Q1".."Q4", and "unknown"
+	MeanSJR      float64            `json:"mean_sjr"`
+}
+
+// The journal's best field, the one where its SJR percentile is highest,
+// which its overall quartile is taken from. The first field when no
+// percentiles are known.
+func primaryField(fields []SubjectField) SubjectField {
+	best := 0
+	for i, f := range fields {
+		if greater(f.SJRPercentile, fields[best].SJRPercentile) {
+			best = i
+		}
+	}
+	return fields[best]
+}
+
+// Attribute the publications to the subject fields of their journals and
+// summarize each field. Fields are ordered by code, and publications
+// without metrics or fields come last in a group with Field 0.
+func buildFieldReports(pubs []Publication, db *MetricsDatabase, attribution fieldAttribution) []FieldReport {
+	reports := map[int64]*FieldReport{}
+	sjrWeights := map[int64]float64{}
+	add := func(f SubjectField, weight float64, sjr *float64) {
+		report := reports[f.Code]
+		if report == nil {
+			report = &FieldReport{Field: f.Code, Name: asjcName(f.Code), Quartiles: map[string]float64{}}
+			reports[f.Code] = report
+		}
+		report.Publications += weight
+		if q := formatQuartile(f.Quartile); q != "" {
+			report.Quartiles[q] += weight
+		} else {
+			report.Quartiles["unknown"] += weight
+		}
+		if sjr != nil {
+			report.MeanSJR += weight * *sjr
+			sjrWeights[f.Code] += weight
+		}
+	}
+	for _, pub := range pubs {
+		metrics, ok := db.LookupISSN(pub.ISSN)
+		if !ok || len(metrics.Fields) == 0 {
+			add(SubjectField{}, 1, nil)
+			continue
+		}
+		switch attribution {
+		case attributePrimary:
+			add(primaryField(metrics.Fields), 1, metrics.SJR)
+		case attributeFractional:
+			for _, f := range metrics.Fields {
+				add(f, 1/float64(len(metrics.Fields)), metrics.SJR)
+			}
+		default:
+			for _, f := range metrics.Fields {
+				add(f, 1, metrics.SJR)
+			}
+		}
+	}
+
+	out := make([]FieldReport, 0, len(reports))
+	for code, report := range reports {
+		if sjrWeights[code] > 0 {
+			report.MeanSJR /= sjrWeights[code]
+		}
+		out = append(out, *report)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Field == 0) != (out[j].Field == 0) {
+			return out[j].Field == 0
+		}
+		return out[i].Field < out[j].Field
+	})
+	return out
+}
+
+// Format a possibly fractional publication count with at most two
+// decimals, e.g. "3" or "1.33"
+func formatCount(n float64) string {
+	return strconv.FormatFloat(math.Round(n*100)/100, 'f', -1, 64)
+}
+
+// Write the per-field summaries as an aligned table
+func writeFieldReportsText(w io.Writer, fields []FieldReport, attribution fieldAttribution) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Subject fields (%s attribution)\n", attribution)
+	fmt.Fprintln(tw, "Field\tName\tPublications\tQ1\tQ2\tQ3\tQ4\tMean SJR")
+	for _, field := range fields {
+		code, name := strconv.FormatInt(field.Field, 10), field.Name
+		if field.Field == 0 {
+			code, name = "", "(no field)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.3f\n", code, name,
+			formatCount(field.Publications), formatCount(field.Quartiles["Q1"]),
+			formatCount(field.Quartiles["Q2"]), formatCount(field.Quartiles["Q3"]),
+			formatCount(field.Quartiles["Q4"]), field.MeanSJR)
+	}
+	return tw.Flush()
+}
+
+// Write the per-field summaries as CSV, one row per field. Publications
+// without a field have an empty field column.
+func writeFieldReportsCSV(w io.Writer, fields []FieldReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"field", "name", "publications", "q1", "q2", "q3", "q4", "unknown_quartile", "mean_sjr"})
+	for _, field := range fields {
+		code := ""
+		if field.Field != 0 {
+			code = strconv.FormatInt(field.Field, 10)
+		}
+		writer.Write([]string{
+			code,
+			field.Name,
+			formatCount(field.Publications),
+			formatCount(field.Quartiles["Q1"]),
+			formatCount(field.Quartiles["Q2"]),
+			formatCount(field.Quartiles["Q3"]),
+			formatCount(field.Quartiles["Q4"]),
+			formatCount(field.Quartiles["unknown"]),
+			strconv.FormatFloat(field.MeanSJR, 'f', 3, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
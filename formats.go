@@ -0,0 +1,75 @@
+package main
+
+import "io"
+
+// An output format of the default mode. Each publication is rendered on
+// its own by Entry, and the entries are written between Begin and End,
+// each followed by Separator and separated by Delimiter.
+type outputFormat struct {
+	Begin     string
+	BeginFunc func(pubs []Publication) string // used instead of Begin when it depends on the entries
+	Entry     func(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error)
+	Separator string
+	Delimiter string // written between entries
+	End       string
+	Sort      func(pubs []Publication) // orders the entries instead of --sort, e.g. newest first for feeds
+	BibTeX    bool                     // whether the entries are BibTeX, which --validate checks
+	Extension string                   // of the output files of a directory of paper XML files
+
+	// An optional second file written next to the -o file, whose path
+	// CompanionPath derives from the -o path
+	CompanionPath func(outputPath string) string
+	Companion     func(w io.Writer, pubs []Publication, opts bibtexOptions) error
+}
+
+// The text written before the entries of pubs
+func (f outputFormat) begin(pubs []Publication) string {
+	if f.BeginFunc != nil {
+		return f.BeginFunc(pubs)
+	}
+	return f.Begin
+}
+
+// Output formats by the name used with --format
+var outputFormats = map[string]outputFormat{
+	"bibtex": {
+		Entry:     toBibTeX,
+		Separator: "\n",
+		BibTeX:    true,
+		Extension: ".bib",
+	},
+	"pandoc": {
+		Entry:         toBibTeX,
+		Separator:     "\n",
+		BibTeX:        true,
+		Extension:     ".bib",
+		CompanionPath: pandocCitationsPath,
+		Companion:     writePandocCitations,
+	},
+	"json": {
+		Begin:     "[\n",
+		Entry:     toJSON,
+		Delimiter: ",\n",
+		End:       "\n]\n",
+		Extension: ".json",
+	},
+	"latex": {
+		Begin:     latexTableHeader,
+		Entry:     toLaTeXRow,
+		End:       latexTableFooter,
+		Extension: ".tex",
+	},
+	"atom": {
+		BeginFunc: atomFeedHeader,
+		Sort:      sortNewestFirst,
+		Entry:     toAtomEntry,
+		End:       atomFeedFooter,
+		Extension: ".atom",
+	},
+	"zotero-rdf": {
+		Begin:     zoteroRDFHeader,
+		Entry:     toZoteroRDF,
+		End:       zoteroRDFFooter,
+		Extension: ".rdf",
+	},
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// The inputs and settings of one run of the default mode, which turns a
+// paper XML file into a sorted bibliography
+type generateConfig struct {
+	XMLFilename       string
+	CSVFilename       string
+	Sources           metricsSources
+	MetadataFormat    string // one of metadataFormats
+	RepoProfile       string // key of repoProfiles, or "" for none
+	OutputPath        string // "" for standard output
+	Format            string // one of outputFormats
+	Template          string // text/template file to render entries with instead of Format, or ""
+	ManifestPath      string // where to write a RunManifest of the run, or "" for none
+	AuditPath         string // where to write the CSV of how each publication's metrics were found, or "" for none
+	Lenient           bool
+	SortBy            string // one of sortKeys
+	Language          string // comma-separated languages to keep, or "" for all
+	LinkPreprints     string // one of linkPreprintModes
+	Statuses          string // comma-separated publication statuses to keep, or "" for all
+	InPress           string // one of inPressModes
+	PeerReviewed      bool   // keep only peer-reviewed publications
+	OrgUnit           string // keep only publications from this organisational unit, or "" for all
+	Corresponding     string // keep only publications this person is a corresponding author of, or "" for all
+	MatchROR          bool   // match affiliations to ROR identifiers
+	Institution       string // keep only publications with an author from this institution, or "" for all
+	Publishers        string // keep only publications in journals of these comma-separated publishers, or "" for all
+	ExcludePublishers string // leave out publications in journals of these comma-separated publishers
+	Countries         string // keep only publications in journals from these comma-separated countries, or "" for all
+	OpenAccess        string // keep only publications in journals with these comma-separated open access statuses, or "" for all
+	EventData         bool   // look up Event Data event counts
+	Scopus            bool   // look up article metadata and journal metrics on Scopus
+	WoS               bool   // look up article metadata, citations and journal coverage on Web of Science
+	Providers         string // --providers chain to find journals with, or "" for the metrics CSV alone
+	CacheDir          string // directory of the journal cache for the cache provider, or ""
+	Jobs              int
+	Validate          string // one of validateModes
+	FailOnMissRate    float64
+	MaxMetricsYearGap int // warn about metrics more than this many years from the publication, or -1 to not
+	BibOpts           bibtexOptions
+}
+
+// An error that ends a run, with the exit code it maps to
+type runError struct {
+	code int
+	err  error
+}
+
+func (e *runError) Error() string { return e.err.Error() }
+func (e *runError) Unwrap() error { return e.err }
+
+// A runError with the given exit code and message
+func runErrorf(code int, format string, args ...any) error {
+	return &runError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// The exit code for an error returned by generate
+func exitCodeFor(err error) int {
+	var re *runError
+	if errors.As(err, &re) {
+		return re.code
+	}
+	return exitError
+}
+
+// Load the metrics CSV, logging any rows skipped in lenient mode
+func loadGenerateMetrics(cfg generateConfig) (*MetricsDatabase, error) {
+	db, skipped, err := ReadMetricsCSV(cfg.CSVFilename, lenientOptions(cfg.Lenient)...)
+	if err != nil {
+		return nil, &runError{code: inputExitCode(err), err: err}
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed CSV rows", skipped)
+	}
+	reportISSNConflicts(db)
+	if err := cfg.Sources.addTo(db, cfg.Lenient); err != nil {
+		return nil, &runError{code: inputExitCode(err), err: err}
+	}
+	return db, nil
+}
+
+// Read the papers, sort them, and write them out in cfg.Format using the
+// journal metrics in db. Output to a file only appears once it is complete.
+// A run that writes its output but has too many papers without metrics
+// still returns an error, with the exitMissRate code. The counts of the
+// publications are returned as well, once the output is written.
+func generate(cfg generateConfig, db *MetricsDatabase) (ManifestCounts, error) {
+	started := time.Now()
+
+	// Read the XML file
+	xmlFile, err := os.Open(cfg.XMLFilename)
+	if err != nil {
+		return ManifestCounts{}, runErrorf(exitError, "Error reading file: %v", err)
+	}
+	defer xmlFile.Close()
+
+	// Parse the XML and extract the Publication from each Record
+	pubs, skippedRecords, err := ReadPublications(xmlFile, cfg.MetadataFormat, cfg.Lenient)
+	if err != nil {
+		return ManifestCounts{}, runErrorf(exitParse, "Error parsing XML: %v", err)
+	}
+	if skippedRecords > 0 {
+		log.Printf("Skipped %d malformed XML records", skippedRecords)
+	}
+	if profile, ok := repoProfiles[cfg.RepoProfile]; ok {
+		applyRepoProfile(pubs, profile)
+	}
+	if cfg.Scopus {
+		enrichFromScopus(pubs, db)
+	}
+	if cfg.WoS {
+		enrichFromWoS(pubs)
+		if s := summarizeWoS(pubs); s != nil {
+			log.Printf("Web of Science has records of %d publications and indexes the journals of %d of %d", s.Records, s.Indexed, s.Publications)
+		}
+	}
+	chain, err := parseProviderChain(cfg.Providers, cfg.CacheDir)
+	if err != nil {
+		return ManifestCounts{}, runErrorf(exitUsage, "%v", err)
+	}
+	if chain.remote() {
+		chain.resolve(pubs, db)
+	}
+	read := len(pubs)
+
+	if cfg.Language != "" {
+		pubs = filterLanguages(pubs, cfg.Language)
+	}
+	if cfg.Statuses != "" {
+		pubs = filterStatuses(pubs, cfg.Statuses)
+	}
+	if cfg.InPress != "" {
+		pubs = filterInPress(pubs, cfg.InPress)
+	}
+	if cfg.PeerReviewed {
+		pubs = filterPeerReviewed(pubs)
+	}
+	if cfg.OrgUnit != "" {
+		pubs = filterOrgUnit(pubs, cfg.OrgUnit)
+	}
+	if cfg.Corresponding != "" {
+		pubs = filterCorresponding(pubs, cfg.Corresponding)
+	}
+	if cfg.MatchROR {
+		matchAffiliationsToROR(pubs)
+	}
+	if cfg.Institution != "" {
+		pubs = filterInstitution(pubs, cfg.Institution)
+	}
+	if cfg.Publishers != "" || cfg.ExcludePublishers != "" || cfg.Countries != "" {
+		pubs = filterPublishers(pubs, db, cfg.Publishers, cfg.ExcludePublishers, cfg.Countries)
+	}
+	if cfg.OpenAccess != "" {
+		pubs = filterOpenAccess(pubs, db, cfg.OpenAccess)
+	}
+	if cfg.LinkPreprints != "" && cfg.LinkPreprints != "off" {
+		pubs = linkPreprints(pubs, cfg.LinkPreprints)
+	}
+	if cfg.EventData {
+		enrichEventsFromCrossref(pubs)
+	}
+	pubs = sortPapers(pubs, db, cfg.SortBy, cfg.BibOpts.MetricsYear)
+	uncovered := warnUncovered(pubs, db)
+	yearGaps := warnMetricsYearGaps(pubs, db, cfg.BibOpts.MetricsYear, cfg.MaxMetricsYearGap)
+
+	format := outputFormats[cfg.Format]
+	var entryTmpl *entryTemplate
+	if cfg.Template != "" {
+		if entryTmpl, err = parseEntryTemplate(cfg.Template); err != nil {
+			return ManifestCounts{}, runErrorf(exitUsage, "%v", err)
+		}
+		format = entryTmpl.format()
+	}
+
+	// Write to a temporary file that is only moved into place once the run
+	// completes, so an interrupted run leaves no partial output behind.
+	// Standard output is streamed, unless a failed validation must keep
+	// the output from being written, when it is held back in memory.
+	var output io.Writer = os.Stdout
+	var outputFile *atomicFile
+	var heldOutput *bytes.Buffer
+	switch {
+	case cfg.OutputPath != "":
+		outputFile, err = createAtomicFile(cfg.OutputPath)
+		if err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error creating output file: %v", err)
+		}
+		defer outputFile.Abort()
+		output = outputFile
+	case cfg.Validate == "error" && format.BibTeX:
+		heldOutput = &bytes.Buffer{}
+		output = heldOutput
+	}
+
+	// The audit report is committed along with the output, as the
+	// companion file is
+	var auditFile *atomicFile
+	var audit *csv.Writer
+	if cfg.AuditPath != "" {
+		auditFile, err = createAtomicFile(cfg.AuditPath)
+		if err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error creating %s: %v", cfg.AuditPath, err)
+		}
+		defer auditFile.Abort()
+		audit = csv.NewWriter(auditFile)
+		audit.Write(auditHeader)
+	}
+
+	// Render the papers in parallel, writing them out in sorted order.
+	// Validation runs as entries are written, since it checks citation
+	// keys across entries. Only BibTeX is validated.
+	buffered := bufio.NewWriter(output)
+	if format.Sort != nil {
+		format.Sort(pubs)
+	}
+	assignCitationKeys(pubs)
+	buffered.WriteString(format.begin(pubs))
+	first := true
+	misses := 0
+	preprints := 0
+	invalid := 0
+	var missList []ManifestMiss
+	var renderErr error
+	validator := newBibValidator()
+	renderEntries(pubs, db, format.Entry, cfg.BibOpts, cfg.Jobs, func(r renderedEntry) {
+		if r.Err != nil && renderErr == nil {
+			renderErr = fmt.Errorf("publication %s: %v", r.Pub.ID, r.Err)
+		}
+		switch {
+		case r.Preprint:
+			preprints++
+		case !r.Found:
+			misses++
+			missList = append(missList, ManifestMiss{
+				ID:      r.Pub.ID,
+				Title:   r.Pub.Title,
+				Journal: r.Pub.Published.Publication.Title,
+				ISSN:    r.Pub.ISSN,
+			})
+		}
+		if audit != nil {
+			writeAuditRow(audit, r)
+		}
+		if cfg.Validate != "off" && format.BibTeX {
+			if problems := validator.Check(r.Entry); len(problems) > 0 {
+				invalid++
+				for _, problem := range problems {
+					log.Printf("Invalid BibTeX for publication %s: %s", r.Pub.ID, problem)
+				}
+			}
+		}
+		if !first {
+			buffered.WriteString(format.Delimiter)
+		}
+		first = false
+		buffered.WriteString(r.Entry)
+		buffered.WriteString(format.Separator)
+	})
+	buffered.WriteString(format.End)
+	if renderErr != nil {
+		return ManifestCounts{}, runErrorf(exitError, "Error rendering %v", renderErr)
+	}
+	if err := buffered.Flush(); err != nil {
+		return ManifestCounts{}, runErrorf(exitError, "Error writing output: %v", err)
+	}
+	if entryTmpl != nil {
+		if err := entryTmpl.Err(); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error executing template: %v", err)
+		}
+	}
+
+	// Keep invalid output out of the way of bibliographies built from it
+	if invalid > 0 && cfg.Validate == "error" {
+		return ManifestCounts{}, runErrorf(exitInvalid, "%d of %d entries failed validation", invalid, len(pubs))
+	}
+
+	// The companion file is committed along with the output, so the two
+	// always describe the same run
+	var companionFile *atomicFile
+	if format.Companion != nil {
+		companionPath := format.CompanionPath(cfg.OutputPath)
+		companionFile, err = createAtomicFile(companionPath)
+		if err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error creating %s: %v", companionPath, err)
+		}
+		defer companionFile.Abort()
+		buffered := bufio.NewWriter(companionFile)
+		if err := format.Companion(buffered, pubs, cfg.BibOpts); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing %s: %v", companionPath, err)
+		}
+		if err := buffered.Flush(); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing %s: %v", companionPath, err)
+		}
+	}
+
+	if audit != nil {
+		if audit.Flush(); audit.Error() != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing %s: %v", cfg.AuditPath, audit.Error())
+		}
+	}
+
+	if outputFile != nil {
+		if err := outputFile.Commit(); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing output file: %v", err)
+		}
+	}
+	if heldOutput != nil {
+		if _, err := heldOutput.WriteTo(os.Stdout); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing output: %v", err)
+		}
+	}
+	if companionFile != nil {
+		if err := companionFile.Commit(); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing output file: %v", err)
+		}
+	}
+	if auditFile != nil {
+		if err := auditFile.Commit(); err != nil {
+			return ManifestCounts{}, runErrorf(exitError, "Error writing %s: %v", cfg.AuditPath, err)
+		}
+	}
+
+	counts := ManifestCounts{
+		Read:           read,
+		SkippedRecords: skippedRecords,
+		Output:         len(pubs),
+		WithMetrics:    len(pubs) - misses - preprints,
+		WithoutMetrics: misses,
+		Preprints:      preprints,
+		Invalid:        invalid,
+		YearGaps:       yearGaps,
+	}
+	var providerStats *ProviderStats
+	if chain.remote() {
+		providerStats = chain.stats(pubs)
+		counts.Providers = providerStats.Hits
+		counts.ProviderMisses = providerStats.Misses
+	}
+	if cfg.ManifestPath != "" {
+		manifest := RunManifest{
+			Tool:      manifestTool(),
+			Arguments: os.Args[1:],
+			Started:   started,
+			Metrics:   db.summary(),
+			Counts:    counts,
+			Misses:    missList,
+		}
+		files := []struct{ role, path string }{
+			{"papers", cfg.XMLFilename},
+			{"metrics", cfg.CSVFilename},
+			{"citescore", cfg.Sources.CiteScore},
+			{"eigenfactor", cfg.Sources.Eigenfactor},
+			{"output", cfg.OutputPath},
+		}
+		if companionFile != nil {
+			files = append(files, struct{ role, path string }{"companion", format.CompanionPath(cfg.OutputPath)})
+		}
+		if auditFile != nil {
+			files = append(files, struct{ role, path string }{"audit", cfg.AuditPath})
+		}
+		for _, f := range files {
+			if f.path == "" {
+				continue
+			}
+			file, err := hashFile(f.role, f.path)
+			if err != nil {
+				return counts, runErrorf(exitError, "Error writing manifest: %v", err)
+			}
+			if f.role != "output" && f.role != "companion" && f.role != "audit" {
+				manifest.Inputs = append(manifest.Inputs, file)
+			} else {
+				manifest.Outputs = append(manifest.Outputs, file)
+			}
+		}
+		manifest.Finished = time.Now()
+		if err := writeManifest(cfg.ManifestPath, manifest); err != nil {
+			return counts, runErrorf(exitError, "Error writing manifest: %v", err)
+		}
+	}
+
+	// Fail the run when too many publications lack metrics, so degraded
+	// runs don't go unnoticed. Unpublished preprints have no journal to
+	// look up, so they don't count.
+	if preprints > 0 {
+		log.Printf("%d unpublished preprints were not looked up", preprints)
+	}
+	if uncovered > 0 {
+		log.Printf("%d publications are from years their journal wasn't indexed in", uncovered)
+	}
+	if providerStats != nil {
+		log.Printf("Journals found by provider: %s", providerStats)
+	}
+	if yearGaps > 0 {
+		log.Printf("%d publications have metrics from more than %d years before or after them", yearGaps, cfg.MaxMetricsYearGap)
+	}
+	if misses > 0 {
+		lookedUp := len(pubs) - preprints
+		missRate := float64(misses) / float64(lookedUp)
+		log.Printf("%d of %d publications (%.1f%%) have no journal metrics", misses, lookedUp, 100*missRate)
+		if missRate > cfg.FailOnMissRate {
+			return counts, runErrorf(exitMissRate, "Miss rate %.3f exceeds --fail-on-miss-rate %g", missRate, cfg.FailOnMissRate)
+		}
+	}
+	return counts, nil
+}
+
+// Regenerate the output whenever the paper XML or metrics CSV changes,
+// checking their modification times every interval. Failed runs are logged
+// and the previous output is left in place; the metrics CSV is only
+// reloaded when it has changed. Runs until the process is stopped.
+func watchAndGenerate(cfg generateConfig, interval time.Duration) {
+	modTime := func(filename string) time.Time {
+		if info, err := os.Stat(filename); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+
+	var db *MetricsDatabase
+	var xmlModified, csvModified time.Time
+	for first := true; ; first = false {
+		if !first {
+			time.Sleep(interval)
+		}
+		xmlNow, csvNow := modTime(cfg.XMLFilename), modTime(cfg.CSVFilename)
+		if !first && xmlNow.Equal(xmlModified) && csvNow.Equal(csvModified) {
+			continue
+		}
+		if !first {
+			log.Printf("Input changed, regenerating %s", cfg.OutputPath)
+		}
+		xmlModified = xmlNow
+
+		// A CSV that fails to load is tried again after its next change,
+		// and the last good one is used until then
+		if db == nil || !csvNow.Equal(csvModified) {
+			if next, err := loadGenerateMetrics(cfg); err != nil {
+				log.Printf("%v", err)
+			} else {
+				db = next
+			}
+		}
+		csvModified = csvNow
+		if db == nil {
+			continue
+		}
+
+		if _, err := generate(cfg, db); err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		log.Printf("Wrote %s", cfg.OutputPath)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// The publications acknowledging one grant, with their metrics summary
+type GrantReport struct {
+	Grant  string `json:"grant"` // grant number, or the funding's name when it has none
+	Funder string `json:"funder"`
+	MetricsSummary
+}
+
+// Fill in the funding of publications whose metadata has none from the
+// funders and award numbers in their Crossref records. Lookup failures are
+// logged and leave the publication as it is.
+func enrichFundingFromCrossref(pubs []Publication) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if len(pub.Funding) > 0 || pub.DOI == "" {
+			continue
+		}
+		work, err := fetchCrossrefWork(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up the funding of %s: %v", pub.DOI, err)
+			continue
+		}
+		if work == nil {
+			continue
+		}
+		for _, funder := range work.Funder {
+			if len(funder.Award) == 0 {
+				pub.Funding = append(pub.Funding, Funding{Funder: funder.Name})
+			}
+			for _, award := range funder.Award {
+				pub.Funding = append(pub.Funding, Funding{Funder: funder.Name, Identifier: award})
+			}
+		}
+	}
+}
+
+// Group the publications by the grants they acknowledge and summarize each
+// group. A publication counts towards each of its grants. Grants are
+// ordered by funder and grant number, and publications without funding
+// come last in a group with an empty Grant and Funder.
+func buildGrantReports(pubs []Publication, db *MetricsDatabase) []GrantReport {
+	type grantKey struct{ funder, grant string }
+	groups := map[grantKey][]Publication{}
+	for _, pub := range pubs {
+		seen := map[grantKey]bool{}
+		for _, funding := range pub.Funding {
+			key := grantKey{
+				funder: strings.Join(strings.Fields(funding.Funder), " "),
+				grant:  strings.TrimSpace(funding.Identifier),
+			}
+			if key.grant == "" {
+				key.grant = strings.Join(strings.Fields(funding.Name), " ")
+			}
+			if key.grant == "" && key.funder == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			groups[key] = append(groups[key], pub)
+		}
+		if len(seen) == 0 {
+			groups[grantKey{}] = append(groups[grantKey{}], pub)
+		}
+	}
+
+	keys := make([]grantKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if (a == grantKey{}) != (b == grantKey{}) {
+			return b == grantKey{}
+		}
+		if a.funder != b.funder {
+			return a.funder < b.funder
+		}
+		return a.grant < b.grant
+	})
+
+	reports := make([]GrantReport, 0, len(keys))
+	for _, key := range keys {
+		reports = append(reports, GrantReport{
+			Grant:          key.grant,
+			Funder:         key.funder,
+			MetricsSummary: summarize(groups[key], db),
+		})
+	}
+	return reports
+}
+
+// Write the per-grant summaries as an aligned table
+func writeGrantReportsText(w io.Writer, grants []GrantReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Grant\tFunder\tPublications\tWith metrics\tQ1\tQ2\tQ3\tQ4\tMean SJR")
+	for _, grant := range grants {
+		name, funder := grant.Grant, grant.Funder
+		if name == "" && funder == "" {
+			name = "(no funding)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.3f\n", name, funder,
+			grant.Publications, grant.WithMetrics,
+			grant.Quartiles["Q1"], grant.Quartiles["Q2"], grant.Quartiles["Q3"], grant.Quartiles["Q4"],
+			grant.MeanSJR)
+	}
+	return tw.Flush()
+}
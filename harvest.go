@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Metadata format requested from OAI-PMH endpoints by default: the CERIF
+// profile of the OpenAIRE guidelines for CRIS managers, which is what
+// ReadPublications understands
+const defaultMetadataPrefix = "oai_cerif_openaire"
+
+// Client for harvests; OAI-PMH endpoints can be slow to build large pages
+var harvestClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Harvest every record from an OAI-PMH endpoint with ListRecords, following
+// resumption tokens across pages, and write them to w as a single OAI-PMH
+// document that ReadPublications can read. set may be empty. Returns the
+// number of records harvested.
+func harvestOAI(baseURL, metadataPrefix, set string, w io.Writer) (int, error) {
+	params := url.Values{"verb": {"ListRecords"}, "metadataPrefix": {metadataPrefix}}
+	if set != "" {
+		params.Set("set", set)
+	}
+
+	fmt.Fprintf(w, "%s<OAI-PMH xmlns=\"http://www.openarchives.org/OAI/2.0/\">\n<ListRecords>\n", xml.Header)
+	count := 0
+	for page := 1; ; page++ {
+		body, err := fetchOAIPage(baseURL + "?" + params.Encode())
+		if err != nil {
+			return count, fmt.Errorf("page %d: %v", page, err)
+		}
+		n, token, err := copyOAIRecords(body, w)
+		if err != nil {
+			return count, fmt.Errorf("page %d: %v", page, err)
+		}
+		count += n
+		if token == "" {
+			break
+		}
+		params = url.Values{"verb": {"ListRecords"}, "resumptionToken": {token}}
+	}
+	fmt.Fprintf(w, "</ListRecords>\n</OAI-PMH>\n")
+	return count, nil
+}
+
+// Fetch one OAI-PMH response
+func fetchOAIPage(pageURL string) ([]byte, error) {
+	resp, err := harvestClient.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Where and how `serve --refresh` republishes the bibliography
+type publishConfig struct {
+	HarvestURL     string // OAI-PMH base URL
+	MetadataPrefix string
+	Set            string
+	Profile        string // key of repoProfiles, or "" for none
+	Dir            string // receives publications.xml and publications.bib
+	Notify         notifyConfig
+}
+
+// Harvest the publications into cfg.Dir/publications.xml and generate
+// cfg.Dir/publications.bib from them with the default options, using the
+// journal metrics in db. Each file is replaced atomically, and a failed
+// harvest leaves the previous files in place. When notifications are
+// configured, what changed since the previous harvest is reported.
+func harvestAndPublish(cfg publishConfig, db *MetricsDatabase) error {
+	xmlPath := filepath.Join(cfg.Dir, "publications.xml")
+	xmlFile, err := createAtomicFile(xmlPath)
+	if err != nil {
+		return err
+	}
+	count, err := harvestOAI(cfg.HarvestURL, cfg.MetadataPrefix, cfg.Set, xmlFile)
+	if err != nil {
+		xmlFile.Abort()
+		return fmt.Errorf("error harvesting %s: %v", cfg.HarvestURL, err)
+	}
+	var previous []Publication
+	if cfg.Notify.enabled() {
+		previous, err = readPublished(xmlPath, cfg.Profile)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: reading the previous harvest: %v", err)
+		}
+	}
+	if err := xmlFile.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Harvested %d records from %s", count, cfg.HarvestURL)
+
+	_, err = generate(generateConfig{
+		XMLFilename:    xmlPath,
+		OutputPath:     filepath.Join(cfg.Dir, "publications.bib"),
+		Format:         "bibtex",
+		MetadataFormat: repoProfiles[cfg.Profile].MetadataFormat,
+		RepoProfile:    cfg.Profile,
+		SortBy:         "avg_citations",
+		Jobs:           runtime.NumCPU(),
+		Validate:       "warn",
+		FailOnMissRate: 1,
+		BibOpts:        defaultBibtexOptions(),
+	}, db)
+	if err != nil {
+		return err
+	}
+
+	// There is nothing to compare the first harvest to
+	if previous == nil {
+		return nil
+	}
+	current, err := readPublished(xmlPath, cfg.Profile)
+	if err != nil {
+		return err
+	}
+	diff := diffExports(previous, current)
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		return nil
+	}
+	var report strings.Builder
+	writeExportDiffText(&report, diff)
+	subject := fmt.Sprintf("Publications from %s: %d added, %d removed, %d changed", cfg.HarvestURL, len(diff.Added), len(diff.Removed), len(diff.Changed))
+	if err := notify(cfg.Notify, subject, report.String()); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return nil
+}
+
+// Read the publications of a harvest, skipping malformed records
+func readPublished(xmlPath, profile string) ([]Publication, error) {
+	xmlFile, err := os.Open(xmlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer xmlFile.Close()
+	pubs, _, err := ReadPublications(xmlFile, repoProfiles[profile].MetadataFormat, true)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := repoProfiles[profile]; ok {
+		applyRepoProfile(pubs, p)
+	}
+	return pubs, nil
+}
+
+// Copy the <record> elements of an OAI-PMH response to w verbatim. Returns
+// the number of records and the resumption token for the next page, which
+// is empty on the last page.
+func copyOAIRecords(page []byte, w io.Writer) (int, string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(page))
+	count := 0
+	token := ""
+	for {
+		offset := decoder.InputOffset()
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return count, token, nil
+		}
+		if err != nil {
+			return count, "", err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "record":
+			if err := decoder.Skip(); err != nil {
+				return count, "", err
+			}
+			w.Write(page[offset:decoder.InputOffset()])
+			w.Write([]byte("\n"))
+			count++
+		case "resumptionToken":
+			var text string
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return count, "", err
+			}
+			token = strings.TrimSpace(text)
+		case "error":
+			var oaiErr struct {
+				Code    string `xml:"code,attr"`
+				Message string `xml:",chardata"`
+			}
+			if err := decoder.DecodeElement(&oaiErr, &start); err != nil {
+				return count, "", err
+			}
+			// An empty result is reported as an error, but isn't one here
+			if oaiErr.Code == "noRecordsMatch" {
+				log.Printf("OAI-PMH endpoint has no matching records")
+				continue
+			}
+			return count, "", fmt.Errorf("OAI-PMH error %s: %s", oaiErr.Code, strings.TrimSpace(oaiErr.Message))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The ISSNs sharing a linking ISSN (ISSN-L) with each ISSN, such as the
+// print and electronic ISSNs of a journal, from --issnl-file. Lookups of an
+// ISSN the metrics don't list try the others.
+var issnGroups = map[issnKey][]issnKey{}
+
+// Load an ISSN-L table, such as the ISSN-to-ISSN-L file the ISSN
+// International Centre publishes: lines of an ISSN and its ISSN-L,
+// separated by a tab, comma or semicolon. Lines that don't start with an
+// ISSN, like a header, are skipped.
+func loadISSNL(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening ISSN-L file: %w", err)
+	}
+	defer file.Close()
+
+	linked := map[issnKey][]issnKey{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == '\t' || r == ',' || r == ';'
+		})
+		if len(fields) < 2 {
+			continue
+		}
+		issn, ok1 := makeISSNKey(fields[0])
+		issnl, ok2 := makeISSNKey(fields[1])
+		if !ok1 || !ok2 {
+			continue
+		}
+		if len(linked[issnl]) == 0 && issn != issnl {
+			linked[issnl] = append(linked[issnl], issnl)
+		}
+		linked[issnl] = append(linked[issnl], issn)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading ISSN-L file: %v", err)
+	}
+	for _, group := range linked {
+		for _, issn := range group {
+			issnGroups[issn] = group
+		}
+	}
+	return nil
+}
+
+// The position of the record found for another ISSN linked to key
+func (db *MetricsDatabase) findLinkedISSN(key issnKey) (int32, bool) {
+	for _, linked := range issnGroups[key] {
+		if index, ok := db.byISSN[linked]; ok && linked != key {
+			return index, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// A job of a job file: the settings of one run, by flag name, plus its
+// input, and a name to report it by
+type job struct {
+	Name     string
+	Line     int // where the job starts in the job file
+	Settings map[string]string
+}
+
+// Read a job file written in a small subset of YAML: top-level `key:
+// value` settings, which apply to every job, and a `jobs:` list whose
+// items are `key: value` mappings, e.g.
+//
+//	metrics: all.csv
+//	jobs:
+//	  - name: biology
+//	    input: harvests/biology.xml
+//	    output: bib/biology.bib
+//	    org-unit: Department of Biology
+//
+// Values may be quoted, and lists like [en, da] are joined with commas, as
+// in the config file.
+func readJobFile(filename string) (map[string]string, []job, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	defaults := map[string]string{}
+	var jobs []job
+	inJobs := false
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := stripComment(scanner.Text())
+		line := strings.TrimSpace(text)
+		if line == "" || line == "---" {
+			continue
+		}
+		indented := text[0] == ' ' || text[0] == '\t'
+
+		// A new job starts at each list item
+		if item, ok := strings.CutPrefix(line, "-"); ok && inJobs {
+			jobs = append(jobs, job{Line: lineNumber, Settings: map[string]string{}})
+			if line = strings.TrimSpace(item); line == "" {
+				continue
+			}
+			indented = true
+		}
+
+		key, raw, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("%s:%d: expected key: value", filename, lineNumber)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		raw = strings.TrimSpace(raw)
+
+		if !indented {
+			inJobs = key == "jobs"
+			if inJobs {
+				if raw != "" {
+					return nil, nil, fmt.Errorf("%s:%d: jobs must be a list of mappings", filename, lineNumber)
+				}
+				continue
+			}
+		} else if !inJobs || len(jobs) == 0 {
+			return nil, nil, fmt.Errorf("%s:%d: unexpected indentation", filename, lineNumber)
+		}
+
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s: %v", filename, lineNumber, key, err)
+		}
+		if !indented {
+			defaults[key] = value
+			continue
+		}
+		current := &jobs[len(jobs)-1]
+		if key == "name" {
+			current.Name = value
+		} else {
+			current.Settings[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no jobs", filename)
+	}
+	return defaults, jobs, nil
+}
+
+// The flag a job file setting sets
+func jobFlagName(key string) string {
+	if key == "output" {
+		return "o"
+	}
+	return key
+}
+
+// Run the jobs of a job file, one after the other. Each job starts from
+// the flags given on the command line, then the file's top-level settings,
+// then its own; configure checks them and builds its configuration, as
+// for a single run. All jobs are checked before any runs, and jobs with
+// the same metrics share the loaded database. A job that fails doesn't
+// stop the others.
+func runJobFile(filename string, configure func(args []string) (generateConfig, bool)) []BatchResult {
+	defaults, jobs, err := readJobFile(filename)
+	if err != nil {
+		fatalf(exitUsage, "Error reading job file: %v", err)
+	}
+
+	// Messages are prefixed with the name of the job they're about
+	defer log.SetFlags(log.Flags())
+	log.SetFlags(log.Flags() | log.Lmsgprefix)
+
+	// The flags as given, which each job starts from
+	given := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	type plannedJob struct {
+		name       string
+		cfg        generateConfig
+		batch      bool
+		outputRoot string
+	}
+	var planned []plannedJob
+	for i, j := range jobs {
+		name := j.Name
+		if name == "" {
+			name = fmt.Sprintf("job %d", i+1)
+		}
+		log.SetPrefix(name + ": ")
+		for flagName, value := range given {
+			flag.Set(flagName, value)
+		}
+		for _, settings := range []map[string]string{defaults, j.Settings} {
+			for key, value := range settings {
+				if key == "input" {
+					continue
+				}
+				if key == "job-file" || key == "watch" {
+					fatalf(exitUsage, "%s:%d: %s can't be used in a job file", filename, j.Line, key)
+				}
+				if flag.Lookup(jobFlagName(key)) == nil {
+					fatalf(exitUsage, "%s:%d: unknown setting %q", filename, j.Line, key)
+				}
+				if err := flag.Set(jobFlagName(key), value); err != nil {
+					fatalf(exitUsage, "%s:%d: invalid value %q for %s: %v", filename, j.Line, value, key, err)
+				}
+			}
+		}
+		if profile, ok := repoProfiles[flag.Lookup("repo-profile").Value.String()]; ok && j.Settings["metadata-format"] == "" && defaults["metadata-format"] == "" {
+			flag.Set("metadata-format", profile.MetadataFormat)
+		}
+		input := j.Settings["input"]
+		if input == "" {
+			fatalf(exitUsage, "%s:%d: no input", filename, j.Line)
+		}
+		if flag.Lookup("metrics").Value.String() == "" {
+			fatalf(exitUsage, "%s:%d: no metrics", filename, j.Line)
+		}
+		cfg, batch := configure([]string{input})
+		planned = append(planned, plannedJob{name, cfg, batch, flag.Lookup("output-root").Value.String()})
+	}
+	log.SetPrefix("")
+
+	// Load each set of metrics once
+	type metricsKey struct {
+		csv     string
+		sources metricsSources
+		lenient bool
+	}
+	databases := map[metricsKey]*MetricsDatabase{}
+	var results []BatchResult
+	for _, p := range planned {
+		log.SetPrefix(p.name + ": ")
+		key := metricsKey{p.cfg.CSVFilename, p.cfg.Sources, p.cfg.Lenient}
+		db, ok := databases[key]
+		if !ok {
+			if db, err = loadGenerateMetrics(p.cfg); err != nil {
+				log.Printf("%v", err)
+				results = append(results, BatchResult{Input: p.name, Output: p.cfg.OutputPath, Err: err})
+				continue
+			}
+			databases[key] = db
+		}
+		if p.batch {
+			batchResults, err := generateBatch(p.cfg, db, p.cfg.XMLFilename, p.outputRoot)
+			if err != nil {
+				log.Printf("%v", err)
+				results = append(results, BatchResult{Input: p.name, Err: err})
+			}
+			results = append(results, batchResults...)
+			continue
+		}
+		result := BatchResult{Input: p.name, Output: p.cfg.OutputPath}
+		if result.Counts, result.Err = generate(p.cfg, db); result.Err != nil {
+			log.Printf("%v", result.Err)
+		}
+		results = append(results, result)
+	}
+	log.SetPrefix("")
+	return results
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Find journals whose title contains every word of the query, where each
+// query word may be the start of a title word ("nature comm" matches
+// "Nature Communications"). Exact title matches come first, then titles
+// starting with the query, then the rest by descending SJR.
+func (db *MetricsDatabase) SearchTitles(query string) []JournalMetrics {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	query = normalizeTitle(query)
+	queryWords := strings.Fields(query)
+	if len(queryWords) == 0 {
+		return nil
+	}
+
+	// Only the titles matching the most selective query word can match
+	// the whole query
+	index := db.prefixIndex()
+	var candidates []string
+	for i, q := range queryWords {
+		if titles := index.withPrefix(q); i == 0 || len(titles) < len(candidates) {
+			candidates = titles
+		}
+	}
+
+	var matches []JournalMetrics
+	for _, title := range candidates {
+		if titleMatches(strings.Fields(title), queryWords) {
+			matches = append(matches, db.journals[db.byTitle[title]])
+		}
+	}
+
+	rank := func(m JournalMetrics) int {
+		title := normalizeTitle(m.Title)
+		switch {
+		case title == query:
+			return 0
+		case strings.HasPrefix(title, query):
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		ri, rj := rank(matches[i]), rank(matches[j])
+		if ri != rj {
+			return ri < rj
+		}
+		if greater(matches[i].SJR, matches[j].SJR) {
+			return true
+		}
+		if greater(matches[j].SJR, matches[i].SJR) {
+			return false
+		}
+		return matches[i].Title < matches[j].Title
+	})
+	return matches
+}
+
+// Whether every query word is a prefix of some word of the title
+func titleMatches(titleWords, queryWords []string) bool {
+	for _, q := range queryWords {
+		found := false
+		for _, t := range titleWords {
+			if strings.HasPrefix(t, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Write journals as an aligned text table
+func writeJournalsText(w io.Writer, db *MetricsDatabase, journals []JournalMetrics) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TITLE\tISSN\tYEAR\tSJR\tH-INDEX\tQUARTILE")
+	for _, m := range journals {
+		issns := make([]string, 0, len(m.ISSNs))
+		for _, issn := range m.ISSNs {
+			issns = append(issns, formatISSN(issn))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\n", m.Title, strings.Join(issns, ", "), m.Year, formatOptional(m.SJR, 3), m.HIndex, formatQuartile(m.Quartile))
+	}
+	return tw.Flush()
+}
+
+// A journal in the JSON output of `journals search`
+type journalWithTrend struct {
+	JournalMetrics
+	Trend *SJRTrend `json:"trend"`
+}
+
+// Write journals, with their SJR trends, as an indented JSON array
+func writeJournalsJSON(w io.Writer, db *MetricsDatabase, journals []JournalMetrics) error {
+	out := make([]journalWithTrend, 0, len(journals))
+	for _, m := range journals {
+		out = append(out, journalWithTrend{JournalMetrics: m, Trend: db.SJRTrend(m.SourceID)})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// The `journals` subcommand, which groups commands that work on the metrics
+// database alone
+func runJournals(args []string) {
+	if len(args) == 0 || args[0] != "search" {
+		log.Printf("Usage: %s journals search [flags] <title words>", os.Args[0])
+		os.Exit(exitUsage)
+	}
+	runJournalsSearch(args[1:])
+}
+
+// The `journals search` subcommand: list journals whose titles match the
+// given words
+func runJournalsSearch(args []string) {
+	fs := flag.NewFlagSet("journals search", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv")
+	sources := metricsSourceFlags(fs)
+	format := fs.String("format", "text", "output format: text or json")
+	limit := fs.Int("limit", 20, "maximum number of journals to list (0 for no limit)")
+	fs.Usage = func() {
+		log.Printf("Usage: %s journals search [flags] <title words>", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "journals", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var write func(io.Writer, *MetricsDatabase, []JournalMetrics) error
+	switch *format {
+	case "text":
+		write = writeJournalsText
+	case "json":
+		write = writeJournalsJSON
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	journalDB, err := loadMetrics(*metricsPath, *sources, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+
+	journals := journalDB.SearchTitles(strings.Join(fs.Args(), " "))
+	if *limit > 0 && len(journals) > *limit {
+		journals = journals[:*limit]
+	}
+	if err := write(os.Stdout, journalDB, journals); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The providers whose API keys `config set-key` stores
+var apiKeyProviders = []string{"crossref", "scopus", "wos", "semanticscholar"}
+
+// The service name API keys are stored under in the OS keyring
+const keyringService = "impact-factor-lookup"
+
+// The variable holding the passphrase of API keys encrypted in the config
+// file, which is asked for on the terminal when it isn't set
+const passphraseEnv = envPrefix + "PASSPHRASE"
+
+// The encrypted API keys of the `[keys]` table of the config file, by
+// provider, recorded by applyConfig
+var configKeys = map[string]string{}
+
+// API keys looked up in the keyring or config file so far, so that the
+// keyring is asked and the passphrase entered at most once per run
+var storedKeys struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// The API key of a provider: the one given with a flag, in the environment
+// or in plain text in the config file, or else the one stored with
+// `config set-key`, in the OS keyring or encrypted in the config file
+func apiKey(provider, given string) string {
+	if given != "" {
+		return given
+	}
+	storedKeys.Lock()
+	defer storedKeys.Unlock()
+	if key, ok := storedKeys.values[provider]; ok {
+		return key
+	}
+	if storedKeys.values == nil {
+		storedKeys.values = map[string]string{}
+	}
+	var err error
+	key := keyringGet(provider)
+	if key == "" && configKeys[provider] != "" {
+		var passphrase string
+		if passphrase, err = readPassphrase("Passphrase for the API keys in the config file: "); err == nil {
+			key, err = decryptAPIKey(configKeys[provider], passphrase)
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: not using the stored %s API key: %v", provider, err)
+	}
+	storedKeys.values[provider] = key
+	return key
+}
+
+// The keyring tool of the platform: security on macOS and secret-tool
+// (libsecret) elsewhere, or "" when there is none
+func keyringTool() string {
+	tool := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		tool = "security"
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return ""
+	}
+	return tool
+}
+
+// The API key of a provider in the OS keyring, or "" when there is none or
+// no keyring
+func keyringGet(provider string) string {
+	var cmd *exec.Cmd
+	switch keyringTool() {
+	case "security":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", provider, "-w")
+	case "secret-tool":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "provider", provider)
+	default:
+		return ""
+	}
+	// Both tools fail when there is no such key
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Store the API key of a provider in the OS keyring
+func keyringSet(provider, key string) error {
+	var cmd *exec.Cmd
+	switch keyringTool() {
+	case "security":
+		// -w last, without a value, prompts for the key and then again to
+		// confirm it, so the key isn't in the command line where ps shows it
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", provider, "-w")
+		cmd.Stdin = strings.NewReader(key + "\n" + key + "\n")
+	case "secret-tool":
+		cmd = exec.Command("secret-tool", "store", "--label", keyringService+" "+provider+" API key", "service", keyringService, "provider", provider)
+		cmd.Stdin = strings.NewReader(key)
+	default:
+		return fmt.Errorf("no OS keyring found (security on macOS, secret-tool elsewhere); use --store config")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error storing key in the keyring: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Iterations of the passphrase key derivation, PBKDF2 with HMAC-SHA256
+const keyIterations = 600000
+
+// Derive a 256-bit AES key from a passphrase: one block of PBKDF2 with
+// HMAC-SHA256
+func deriveKey(passphrase string, salt []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := slices.Clone(u)
+	for i := 1; i < keyIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// Encrypt an API key with a passphrase, as "enc:" and the base64 of the
+// salt, the AES-GCM nonce and the sealed key
+func encryptAPIKey(key, passphrase string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(deriveKey(passphrase, salt))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(key), nil)
+	return "enc:" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt an API key encrypted by encryptAPIKey
+func decryptAPIKey(value, passphrase string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, "enc:")
+	if !ok {
+		return "", fmt.Errorf("not an encrypted key")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < 16+12 {
+		return "", fmt.Errorf("malformed encrypted key")
+	}
+	block, err := aes.NewCipher(deriveKey(passphrase, data[:16]))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := data[16 : 16+gcm.NonceSize()]
+	key, err := gcm.Open(nil, nonce, data[16+gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("wrong passphrase or damaged key")
+	}
+	return string(key), nil
+}
+
+// Whether standard input is a terminal, rather than a pipe or file
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Read a secret, such as the passphrase or an API key, from standard
+// input, prompting and turning off echo when it is a terminal
+func readSecret(prompt string) (string, error) {
+	if stdinIsTerminal() {
+		fmt.Fprint(os.Stderr, prompt)
+		if stty("-echo") == nil {
+			defer stty("echo")
+		}
+		defer fmt.Fprintln(os.Stderr)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("error reading from standard input: %v", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Change a setting of the terminal on standard input
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// The passphrase of the API keys encrypted in the config file, from the
+// environment or the terminal
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("no passphrase; set %s", passphraseEnv)
+	}
+	passphrase, err := readSecret(prompt)
+	if err == nil && passphrase == "" {
+		err = fmt.Errorf("empty passphrase")
+	}
+	return passphrase, err
+}
+
+// Set key = value in a table of a config file, replacing the key's line
+// if the table has one, and creating the file and the table as needed. A
+// symlinked config is written through the link, and the file keeps its
+// permissions; a new one is only readable by the user, as it holds keys.
+func setConfigValue(filename, table, key, value string) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	perm := os.FileMode(0o600)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	line := key + " = " + strconv.Quote(value)
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	// Find the table, the key in it, and the table's last line
+	inTable, found, last := false, false, -1
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(stripComment(l))
+		if strings.HasPrefix(trimmed, "[") {
+			inTable = trimmed == "["+table+"]"
+			if inTable {
+				last = i
+			}
+			continue
+		}
+		if !inTable {
+			continue
+		}
+		if trimmed != "" {
+			last = i
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && strings.Trim(strings.TrimSpace(k), `"`) == key {
+			lines[i] = line
+			found = true
+		}
+	}
+	switch {
+	case found:
+	case last >= 0:
+		lines = slices.Insert(lines, last+1, line)
+	default:
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+table+"]", line)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomicPerm(filename, []byte(strings.Join(lines, "\n")+"\n"), perm)
+}
+
+// The `config` subcommand, which manages the configuration
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "set-key" {
+		log.Printf("Usage: %s config set-key [flags] <provider> [key]", os.Args[0])
+		os.Exit(exitUsage)
+	}
+	runConfigSetKey(args[1:])
+}
+
+// The `config set-key` subcommand: store the API key of a provider in the
+// OS keyring or encrypted in the config file, where commands find it when
+// no key is given with a flag or in the environment
+func runConfigSetKey(args []string) {
+	fs := flag.NewFlagSet("config set-key", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file to store encrypted keys in (default "+defaultConfigPath()+")")
+	store := fs.String("store", "keyring", "where to store the key: keyring (the OS keyring) or config (encrypted with a passphrase in the config file's [keys] table)")
+	fs.Usage = func() {
+		log.Printf("Usage: %s config set-key [flags] <provider> [key]", os.Args[0])
+		log.Printf("Providers: %s. The key is read from standard input when not given.", strings.Join(apiKeyProviders, ", "))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	provider := fs.Arg(0)
+	if !slices.Contains(apiKeyProviders, provider) {
+		log.Printf("Unknown provider %q", provider)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// Reading the key from standard input keeps it out of the shell history
+	key := fs.Arg(1)
+	if key == "" {
+		var err error
+		if key, err = readSecret(provider + " API key: "); err != nil {
+			fatalf(exitError, "%v", err)
+		}
+	}
+	if key == "" {
+		fatalf(exitUsage, "No API key given")
+	}
+
+	switch *store {
+	case "keyring":
+		if err := keyringSet(provider, key); err != nil {
+			fatalf(exitError, "%v", err)
+		}
+		log.Printf("Stored the %s API key in the keyring", provider)
+	case "config":
+		path := *configPath
+		if path == "" {
+			path = os.Getenv(envPrefix + "CONFIG")
+		}
+		if path == "" {
+			path = defaultConfigPath()
+		}
+		passphrase, err := readPassphrase("Passphrase to encrypt the key with: ")
+		if err != nil {
+			fatalf(exitError, "%v", err)
+		}
+		value, err := encryptAPIKey(key, passphrase)
+		if err != nil {
+			fatalf(exitError, "Error encrypting key: %v", err)
+		}
+		if err := setConfigValue(path, "keys", provider, value); err != nil {
+			fatalf(exitError, "Error writing config: %v", err)
+		}
+		log.Printf("Stored the encrypted %s API key in %s", provider, path)
+	default:
+		log.Printf("Unknown --store %q", *store)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "strings"
+
+// Languages by ISO 639-1 code, with their ISO 639-2 codes and the babel
+// names BibLaTeX expects in langid
+var languages = map[string]struct {
+	iso6392 []string
+	babel   string
+}{
+	"da": {[]string{"dan"}, "danish"},
+	"de": {[]string{"deu", "ger"}, "ngerman"},
+	"en": {[]string{"eng"}, "english"},
+	"es": {[]string{"spa"}, "spanish"},
+	"fi": {[]string{"fin"}, "finnish"},
+	"fr": {[]string{"fra", "fre"}, "french"},
+	"is": {[]string{"isl", "ice"}, "icelandic"},
+	"it": {[]string{"ita"}, "italian"},
+	"nb": {[]string{"nob"}, "norsk"},
+	"nl": {[]string{"nld", "dut"}, "dutch"},
+	"nn": {[]string{"nno"}, "nynorsk"},
+	"no": {[]string{"nor"}, "norsk"},
+	"pl": {[]string{"pol"}, "polish"},
+	"pt": {[]string{"por"}, "portuguese"},
+	"sv": {[]string{"swe"}, "swedish"},
+}
+
+// Reduce a language tag as found in repository metadata ("en", "en-GB",
+// "eng") to its ISO 639-1 code where one is known, or to the lowercased
+// primary subtag otherwise
+func normalizeLanguage(tag string) string {
+	code := strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if len(code) == 3 {
+		for iso6391, language := range languages {
+			for _, iso6392 := range language.iso6392 {
+				if code == iso6392 {
+					return iso6391
+				}
+			}
+		}
+	}
+	return code
+}
+
+// The BibLaTeX langid for a language tag, or "" if it isn't known
+func languageID(tag string) string {
+	return languages[normalizeLanguage(tag)].babel
+}
+
+// Keep only the publications in one of the given comma-separated languages.
+// Publications without a language are dropped.
+func filterLanguages(pubs []Publication, list string) []Publication {
+	wanted := map[string]bool{}
+	for _, tag := range strings.Split(list, ",") {
+		if code := normalizeLanguage(tag); code != "" {
+			wanted[code] = true
+		}
+	}
+	var out []Publication
+	for _, pub := range pubs {
+		if wanted[normalizeLanguage(pub.Language)] {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kljensen/impact-factor-lookup/bibtex"
+)
+
+// The start of the table written by --format latex: a longtable, which
+// breaks across pages, with the column headings repeated on each page
+const latexTableHeader = `% Requires \usepackage{longtable}
+\begin{longtable}{p{0.35\textwidth}p{0.25\textwidth}rrcrr}
+\hline
+Publication & Journal & Year & SJR & Quartile & CiteScore & SNIP \\
+\hline
+\endhead
+\hline
+\endfoot
+`
+
+const latexTableFooter = "\\end{longtable}\n"
+
+// Characters with a special meaning in LaTeX, and how to typeset them
+var latexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// Escape text for use in a LaTeX document, keeping its inline math
+func latexEscape(s string) string {
+	return bibtex.EscapeMath(strings.Join(strings.Fields(s), " "), latexReplacer.Replace)
+}
+
+// Render a publication as a row of the --format latex table. Metrics the
+// journal doesn't have are shown as a dash.
+func toLaTeXRow(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+	journal := ""
+	if pub.Published.Publication.Title != "" {
+		journal = abbreviateJournalTitle(pub.Published.Publication.Title, opts.JournalStyle)
+	}
+	year := forthcomingLabel(pub)
+	if year == "" && len(pub.Date) >= 4 {
+		year = pub.Date[:4]
+	}
+	sjr, quartile, citeScore, snip := "--", "--", "--", "--"
+	if metrics != nil {
+		if metrics.SJR != nil {
+			sjr = formatOptional(metrics.SJR, opts.MetricPrecision)
+		}
+		if metrics.Quartile > 0 {
+			quartile = formatQuartile(metrics.Quartile)
+		}
+		if metrics.CiteScore != nil {
+			citeScore = formatOptional(metrics.CiteScore, opts.MetricPrecision)
+		}
+		if metrics.SNIP != nil {
+			snip = formatOptional(metrics.SNIP, opts.MetricPrecision)
+		}
+	}
+	return fmt.Sprintf("%s & %s & %s & %s & %s & %s & %s \\\\\n",
+		latexEscape(displayTitle(pub, opts)), latexEscape(journal), latexEscape(year), sjr, quartile, citeScore, snip), nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Matches an ISSN with or without the hyphen, e.g. 1234-567X or 1234567X
+var issnPattern = regexp.MustCompile(`^\d{4}-?\d{3}[\dxX]$`)
+
+// The result of looking up a single ISSN or journal title
+type LookupResult struct {
+	Query   string          `json:"query"`
+	Found   bool            `json:"found"`
+	Metrics *JournalMetrics `json:"metrics"`
+	Trend   *SJRTrend       `json:"trend"`
+
+	// The journal's former title and ISSNs, when the query matched those
+	// rather than the current ones
+	Formerly *JournalRename `json:"formerly,omitempty"`
+}
+
+// Prefix marking a lookup query as a SCImago source ID, e.g. sourceid:21206
+const sourceIDPrefix = "sourceid:"
+
+// Look up a single query, which is treated as a SCImago source ID when it
+// starts with "sourceid:", as an ISSN when it looks like one, and as a
+// journal title otherwise. Former titles and ISSNs of a journal find its
+// current record, noting what it was called.
+func (db *MetricsDatabase) Lookup(query string) LookupResult {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.lookup(query)
+}
+
+func (db *MetricsDatabase) lookup(query string) LookupResult {
+	query = strings.TrimSpace(query)
+	var index int32
+	var ok bool
+	if id, isID := strings.CutPrefix(strings.ToLower(query), sourceIDPrefix); isID {
+		if sourceID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil {
+			index, ok = db.bySourceID[sourceID]
+		}
+	} else if issnPattern.MatchString(query) {
+		index, ok = db.findISSN(query)
+	} else {
+		index, ok = db.byTitle[normalizeTitle(query)]
+	}
+	result := LookupResult{Query: query, Found: ok}
+	if ok {
+		index, result.Formerly = db.current(index)
+		metrics := db.journals[index]
+		result.Metrics = &metrics
+		result.Trend = db.sjrTrend(metrics.SourceID)
+	}
+	return result
+}
+
+// Look up many ISSNs or journal titles at once, returning one result per
+// query in the same order. All queries in a batch see the same version of
+// the database.
+func (db *MetricsDatabase) LookupBatch(queries []string) []LookupResult {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	results := make([]LookupResult, 0, len(queries))
+	for _, query := range queries {
+		results = append(results, db.lookup(query))
+	}
+	return results
+}
+
+// Other journal metrics to add to the SCImago data, by file name, or ""
+// for none
+type metricsSources struct {
+	CiteScore   string // Elsevier CiteScore export
+	Eigenfactor string // Eigenfactor and Article Influence scores
+}
+
+// Register the flags naming the metricsSources on a subcommand's flags
+func metricsSourceFlags(fs *flag.FlagSet) *metricsSources {
+	var sources metricsSources
+	fs.StringVar(&sources.CiteScore, "citescore", "", "Elsevier CiteScore export csv to add CiteScore, SNIP and CiteScore percentiles from")
+	fs.StringVar(&sources.Eigenfactor, "eigenfactor", "", "csv of Eigenfactor and Article Influence scores by ISSN to add to the journals")
+	return &sources
+}
+
+// Add the metrics of each source to the database
+func (s metricsSources) addTo(db *MetricsDatabase, lenient bool) error {
+	if s.CiteScore != "" {
+		if err := loadCiteScore(db, s.CiteScore, lenient); err != nil {
+			return err
+		}
+	}
+	if s.Eigenfactor != "" {
+		if err := loadEigenfactor(db, s.Eigenfactor, lenient); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load the metrics database for one of the subcommands, adding the other
+// sources and reporting any rows skipped in lenient mode
+func loadMetrics(filename string, sources metricsSources, lenient bool) (*MetricsDatabase, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("no impact factor csv given; use --metrics or set metrics in the config file")
+	}
+	db, skipped, err := ReadMetricsCSV(filename, lenientOptions(lenient)...)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed CSV rows", skipped)
+	}
+	reportISSNConflicts(db)
+	if err := sources.addTo(db, lenient); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Write lookup results as CSV, one row per query. Misses have empty
+// metrics columns, as do metrics the journal has no value for.
+func writeLookupCSV(w io.Writer, results []LookupResult) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"query", "found", "title", "issn", "year", "fields", "quartile", "sjr", "h_index", "avg_citations", "sourceid", "sjr_percentile", "h_index_percentile", "field_normalized_citations", "citescore", "snip", "citescore_percentile", "eigenfactor", "article_influence", "publisher", "country", "open_access", "coverage", "formerly"})
+	for _, result := range results {
+		row := []string{result.Query, strconv.FormatBool(result.Found), "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", ""}
+		if m := result.Metrics; m != nil {
+			row[2] = m.Title
+			row[3] = strings.Join(m.ISSNs, ", ")
+			row[4] = strconv.FormatInt(m.Year, 10)
+			row[5] = formatFieldCodes(m.Fields, "; ")
+			row[6] = formatQuartile(m.Quartile)
+			row[7] = formatOptional(m.SJR, -1)
+			row[8] = strconv.FormatInt(m.HIndex, 10)
+			row[9] = formatOptional(m.AvgCitations, -1)
+			row[10] = strconv.FormatInt(m.SourceID, 10)
+			row[11] = formatOptional(m.SJRPercentile, 1)
+			row[12] = formatOptional(m.HIndexPercentile, 1)
+			row[13] = formatOptional(m.FieldNormalizedCitations, 3)
+			row[14] = formatOptional(m.CiteScore, -1)
+			row[15] = formatOptional(m.SNIP, -1)
+			row[16] = formatOptional(m.CiteScorePercentile, 1)
+			row[17] = formatOptional(m.Eigenfactor, -1)
+			row[18] = formatOptional(m.ArticleInfluence, -1)
+			row[19] = m.Publisher
+			row[20] = m.Country
+			row[21] = m.OpenAccessStatus()
+			row[22] = m.Coverage
+		}
+		if f := result.Formerly; f != nil {
+			row[23] = formatRename(*f)
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Format a normalized ISSN for display, e.g. 1234-567X
+func formatISSN(issn string) string {
+	if len(issn) != 8 {
+		return issn
+	}
+	return issn[:4] + "-" + issn[4:]
+}
+
+// Format a journal's former name, e.g. "Old Title (1234-5678, until 2015)"
+func formatRename(f JournalRename) string {
+	details := make([]string, 0, len(f.ISSNs)+1)
+	for _, issn := range f.ISSNs {
+		details = append(details, formatISSN(issn))
+	}
+	details = append(details, fmt.Sprintf("until %d", f.LastYear))
+	return fmt.Sprintf("%s (%s)", f.Title, strings.Join(details, ", "))
+}
+
+// Format an optional metric with the given number of decimals (-1 for as
+// many as needed), or an empty string when unknown
+func formatOptional(v *float64, decimals int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', decimals, 64)
+}
+
+// Format a quartile as Q1-Q4, or an empty string when unknown
+func formatQuartile(q int) string {
+	if q == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Q%d", q)
+}
+
+// Format a journal's field codes with their quartiles, e.g. "1000 (Q1)"
+func formatFieldCodes(fields []SubjectField, sep string) string {
+	var parts []string
+	for _, f := range fields {
+		part := strconv.FormatInt(f.Code, 10)
+		if f.Quartile > 0 {
+			part += " (" + formatQuartile(f.Quartile) + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, sep)
+}
+
+// Write lookup results as human-readable records, one block per query
+func writeLookupText(w io.Writer, results []LookupResult) error {
+	for i, result := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		m := result.Metrics
+		if m == nil {
+			fmt.Fprintf(w, "%s: not found\n", result.Query)
+			continue
+		}
+		issns := make([]string, 0, len(m.ISSNs))
+		for _, issn := range m.ISSNs {
+			issns = append(issns, formatISSN(issn))
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(tw, "Title:\t%s\n", m.Title)
+		fmt.Fprintf(tw, "ISSN:\t%s\n", strings.Join(issns, ", "))
+		if f := result.Formerly; f != nil {
+			fmt.Fprintf(tw, "Formerly:\t%s\n", formatRename(*f))
+		}
+		if m.Publisher != "" {
+			fmt.Fprintf(tw, "Publisher:\t%s\n", m.Publisher)
+		}
+		if m.Country != "" {
+			fmt.Fprintf(tw, "Country:\t%s\n", m.Country)
+		}
+		if oa := m.OpenAccessStatus(); oa != "" {
+			fmt.Fprintf(tw, "Open access:\t%s\n", oa)
+		}
+		if m.Coverage != "" {
+			fmt.Fprintf(tw, "Coverage:\t%s\n", m.Coverage)
+		}
+		fmt.Fprintf(tw, "Year:\t%d\n", m.Year)
+		fmt.Fprintf(tw, "Fields:\t%s\n", formatFieldCodes(m.Fields, ", "))
+		fmt.Fprintf(tw, "Quartile:\t%s\n", formatQuartile(m.Quartile))
+		if m.SJR != nil {
+			fmt.Fprintf(tw, "SJR:\t%g\n", *m.SJR)
+		} else {
+			fmt.Fprintf(tw, "SJR:\tunknown\n")
+		}
+		if t := result.Trend; t != nil {
+			var points []string
+			for _, p := range t.Points {
+				points = append(points, fmt.Sprintf("%d: %g", p.Year, p.SJR))
+			}
+			fmt.Fprintf(tw, "SJR trend:\t%s (%+.3f)\n", strings.Join(points, ", "), t.Delta)
+		}
+		if m.SJRPercentile != nil {
+			fmt.Fprintf(tw, "SJR percentile:\t%.1f (best field)\n", *m.SJRPercentile)
+		}
+		fmt.Fprintf(tw, "h-index:\t%d\n", m.HIndex)
+		if m.HIndexPercentile != nil {
+			fmt.Fprintf(tw, "h-index percentile:\t%.1f (best field)\n", *m.HIndexPercentile)
+		}
+		if m.AvgCitations != nil {
+			fmt.Fprintf(tw, "Avg. citations:\t%g\n", *m.AvgCitations)
+		} else {
+			fmt.Fprintf(tw, "Avg. citations:\tunknown\n")
+		}
+		if m.FieldNormalizedCitations != nil {
+			fmt.Fprintf(tw, "Field-normalized citations:\t%.3f (best field; 1 is the field median)\n", *m.FieldNormalizedCitations)
+		}
+		if m.CiteScore != nil {
+			fmt.Fprintf(tw, "CiteScore:\t%g\n", *m.CiteScore)
+		}
+		if m.SNIP != nil {
+			fmt.Fprintf(tw, "SNIP:\t%g\n", *m.SNIP)
+		}
+		if m.CiteScorePercentile != nil {
+			fmt.Fprintf(tw, "CiteScore percentile:\t%.1f (best subject area)\n", *m.CiteScorePercentile)
+		}
+		if m.Eigenfactor != nil {
+			fmt.Fprintf(tw, "Eigenfactor:\t%g\n", *m.Eigenfactor)
+		}
+		if m.ArticleInfluence != nil {
+			fmt.Fprintf(tw, "Article Influence:\t%g\n", *m.ArticleInfluence)
+		}
+		fmt.Fprintf(tw, "Source ID:\t%d\n", m.SourceID)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write lookup results as an indented JSON array
+func writeLookupJSON(w io.Writer, results []LookupResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// Read the non-blank lines of r as lookup queries
+func readQueries(r io.Reader) ([]string, error) {
+	var queries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			queries = append(queries, line)
+		}
+	}
+	return queries, scanner.Err()
+}
+
+// The `lookup` subcommand: look up ISSNs or journal titles in the metrics
+// database without needing a paper XML file. Queries come from the
+// arguments or, with --stdin, one per line from standard input.
+func runLookup(args []string) {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv")
+	sources := metricsSourceFlags(fs)
+	stdin := fs.Bool("stdin", false, "read one ISSN, journal title or sourceid:ID per line from standard input")
+	format := fs.String("format", "", "output format: text, csv or json (default text, or csv with --stdin)")
+	fs.Usage = func() {
+		log.Printf("Usage: %s lookup [flags] <ISSN, title or sourceid:ID>...", os.Args[0])
+		log.Printf("       %s lookup [flags] --stdin", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "lookup", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if *stdin == (fs.NArg() != 0) {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *format == "" {
+		*format = "text"
+		if *stdin {
+			*format = "csv"
+		}
+	}
+	var write func(io.Writer, []LookupResult) error
+	switch *format {
+	case "text":
+		write = writeLookupText
+	case "csv":
+		write = writeLookupCSV
+	case "json":
+		write = writeLookupJSON
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	journalDB, err := loadMetrics(*metricsPath, *sources, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+
+	queries := fs.Args()
+	if *stdin {
+		queries, err = readQueries(os.Stdin)
+		if err != nil {
+			fatalf(exitError, "Error reading standard input: %v", err)
+		}
+	}
+
+	results := journalDB.LookupBatch(queries)
+	if err := write(os.Stdout, results); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+
+	// Queries given on the command line are expected to match, so a miss
+	// is reported through the exit code
+	if !*stdin {
+		for _, result := range results {
+			if !result.Found {
+				os.Exit(exitLookupFailed)
+			}
+		}
+	}
+}
@@ -1,27 +1,112 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+
+	"github.com/kljensen/impact-factor-lookup/bibtex"
 )
 
+// A subject field (ASJC category) a journal is listed under, with the
+// journal's standing among the other journals in that field and year
+type SubjectField struct {
+	Code int64 `db:"field" json:"field"`
+
+	// Quartile of the journal's SJR within the field (1 is the top 25%), or
+	// 0 when unknown. Set by RankWithinFields, as are the values below,
+	// which are nil when unknown.
+	Quartile                 int      `db:"quartile" json:"quartile,omitempty"`
+	SJRPercentile            *float64 `db:"sjr_percentile" json:"sjr_percentile,omitempty"`
+	HIndexPercentile         *float64 `db:"h_index_percentile" json:"h_index_percentile,omitempty"`
+	FieldNormalizedCitations *float64 `db:"field_normalized_citations" json:"field_normalized_citations,omitempty"`
+}
+
 type JournalMetrics struct {
-	Title        string   `db:"title"`
-	Field        int64    `db:"field"`
-	Year         int64    `db:"year"`
-	SJR          float64  `db:"sjr"`
-	HIndex       int64    `db:"h_index"`
-	AvgCitations float64  `db:"avg_citations"`
-	ISSNs        []string `db:"issn"` // Splitting the comma-separated ISSNs into a slice
-	SourceID     int64    `db:"sourceid"`
+	Title        string         `db:"title" json:"title"`
+	Fields       []SubjectField `db:"fields" json:"fields"` // SCImago lists journals under several fields
+	Year         int64          `db:"year" json:"year"`
+	SJR          *float64       `db:"sjr" json:"sjr,omitempty"` // nil when SCImago has no value
+	HIndex       int64          `db:"h_index" json:"h_index"`
+	AvgCitations *float64       `db:"avg_citations" json:"avg_citations,omitempty"` // nil when SCImago has no value
+	ISSNs        []string       `db:"issn" json:"issn"`                             // Splitting the comma-separated ISSNs into a slice
+	SourceID     int64          `db:"sourceid" json:"sourceid"`
+
+	// The journal's standing in its best field, i.e. the one where its SJR
+	// percentile is highest, as SCImago does for its "best quartile".
+	// Quartile is 0 and the others nil when unknown. Set by RankWithinFields.
+	Quartile         int      `db:"quartile" json:"quartile,omitempty"`
+	SJRPercentile    *float64 `db:"sjr_percentile" json:"sjr_percentile,omitempty"`
+	HIndexPercentile *float64 `db:"h_index_percentile" json:"h_index_percentile,omitempty"`
+
+	// Average citations divided by the median for the same field and year,
+	// or nil when unknown. Set by RankWithinFields.
+	FieldNormalizedCitations *float64 `db:"field_normalized_citations" json:"field_normalized_citations,omitempty"`
+
+	// Elsevier's CiteScore, the source-normalized impact per paper, and the
+	// journal's highest CiteScore percentile among its subject areas, from
+	// a CiteScore export given with --citescore. nil when unknown.
+	CiteScore           *float64 `db:"citescore" json:"citescore,omitempty"`
+	SNIP                *float64 `db:"snip" json:"snip,omitempty"`
+	CiteScorePercentile *float64 `db:"citescore_percentile" json:"citescore_percentile,omitempty"`
+
+	// The Eigenfactor score and Article Influence score, from a dataset
+	// given with --eigenfactor. nil when unknown.
+	Eigenfactor      *float64 `db:"eigenfactor" json:"eigenfactor,omitempty"`
+	ArticleInfluence *float64 `db:"article_influence" json:"article_influence,omitempty"`
+
+	// From the Publisher and Country columns of the full SCImago CSV, or
+	// "" when the CSV has none
+	Publisher string `db:"publisher" json:"publisher,omitempty"`
+	Country   string `db:"country" json:"country,omitempty"`
+
+	// The years SCImago has indexed the journal, e.g. "1999-2012,
+	// 2015-2023", from the Coverage column of the full CSV, or ""
+	Coverage string `db:"coverage" json:"coverage,omitempty"`
+
+	// Whether the journal is open access, and diamond open access (free to
+	// publish in as well as to read), from the Open Access columns of
+	// recent SCImago CSVs. nil when the CSV doesn't say.
+	OpenAccess        *bool `db:"open_access" json:"open_access,omitempty"`
+	DiamondOpenAccess *bool `db:"diamond_open_access" json:"diamond_open_access,omitempty"`
+}
+
+// The journal's open access status: "diamond", "yes", "no", or "" when
+// unknown
+func (m JournalMetrics) OpenAccessStatus() string {
+	switch {
+	case m.DiamondOpenAccess != nil && *m.DiamondOpenAccess:
+		return "diamond"
+	case m.OpenAccess == nil:
+		return ""
+	case *m.OpenAccess:
+		return "yes"
+	default:
+		return "no"
+	}
+}
+
+// The field codes of the journal, e.g. for display
+func (m JournalMetrics) FieldCodes() []int64 {
+	codes := make([]int64, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		codes = append(codes, f.Code)
+	}
+	return codes
 }
 
 // Helper function to parse comma-separated ISSNs into a slice
@@ -38,13 +123,24 @@ func parseISSNs(issnString string) []string {
 	return result
 }
 
-// Function to create a new JournalMetrics from raw data
-func NewJournalMetrics(title string, field, year int64, sjr float64, hIndex int64,
-	avgCitations float64, issnString string, sourceID int64) JournalMetrics {
+// A pointer to v, for setting optional metrics
+func optionalFloat(v float64) *float64 {
+	return &v
+}
+
+// A pointer to b, for setting optional flags
+func optionalBool(b bool) *bool {
+	return &b
+}
+
+// Function to create a new JournalMetrics from raw data. sjr and
+// avgCitations may be nil when the data has no value for them.
+func NewJournalMetrics(title string, field, year int64, sjr *float64, hIndex int64,
+	avgCitations *float64, issnString string, sourceID int64) JournalMetrics {
 
 	return JournalMetrics{
 		Title:        title,
-		Field:        field,
+		Fields:       []SubjectField{newSubjectField(field)},
 		Year:         year,
 		SJR:          sjr,
 		HIndex:       hIndex,
@@ -54,43 +150,538 @@ func NewJournalMetrics(title string, field, year int64, sjr float64, hIndex int6
 	}
 }
 
-// Add a map type for easy ISSN lookup
-type MetricsDatabase map[string]JournalMetrics
+// A subject field whose ranks haven't been computed yet
+func newSubjectField(code int64) SubjectField {
+	return SubjectField{Code: code}
+}
+
+// Database of journal metrics with indexes for easy ISSN and title lookup.
+// A MetricsDatabase is safe for concurrent use: any number of goroutines
+// may look up journals while another adds records or replaces the whole
+// database with Replace. Each lookup sees the database either entirely
+// before or entirely after a Replace, never a mix of the two.
+type MetricsDatabase struct {
+	mu sync.RWMutex
+	metricsIndexes
+
+	titleIndexMu sync.Mutex // guards building titleIndex under a read lock
+}
+
+// Identifies the row of one journal in one year
+type sourceYear struct {
+	sourceID int64
+	year     int64
+}
+
+// A normalized ISSN: eight digits, the last of which may be X
+type issnKey [8]byte
+
+// The key for an ISSN, or false if it doesn't have eight digits once
+// cleaned up
+func makeISSNKey(issn string) (issnKey, bool) {
+	var key issnKey
+	issn = normalizeISSN(issn)
+	if len(issn) != len(key) {
+		return key, false
+	}
+	copy(key[:], issn)
+	return key, true
+}
+
+// The contents of a MetricsDatabase, guarded by its mutex. Each record is
+// stored once, in journals; the indexes hold positions in that slice, so
+// a journal with several ISSNs isn't copied for each of them.
+type metricsIndexes struct {
+	journals   []JournalMetrics
+	records    map[sourceYear]int32
+	bySourceID map[int64]int32
+	byISSN     map[issnKey]int32
+	byTitle    map[string]int32
+	sjrByID    map[int64]map[int64]float64 // sourceid -> year -> SJR
+	fields     map[fieldYear]*fieldDistribution
+	titleIndex *titleIndex // built by prefixIndex, nil when out of date
+
+	// Source IDs of the journals listing an ISSN, for the ISSNs listed by
+	// more than one
+	issnClaims map[issnKey][]int64
+
+	// How the ISSN index picks among records listing the same ISSN, and
+	// with DedupKeepAll, every record listing each ISSN
+	dedup     DedupStrategy
+	dedupYear int64
+	issnAll   map[issnKey][]int32
+
+	// Titles and ISSNs repeat in every year's rows, so one copy of each
+	// is shared by all records
+	interned map[string]string
+
+	// The providers that found the journals not from the impact factor
+	// csv, by source ID
+	origins map[int64]string
+}
+
+// Create an empty metrics database
+func NewMetricsDatabase() *MetricsDatabase {
+	return &MetricsDatabase{
+		metricsIndexes: metricsIndexes{
+			records:    make(map[sourceYear]int32),
+			bySourceID: make(map[int64]int32),
+			byISSN:     make(map[issnKey]int32),
+			byTitle:    make(map[string]int32),
+			sjrByID:    make(map[int64]map[int64]float64),
+			fields:     make(map[fieldYear]*fieldDistribution),
+			interned:   make(map[string]string),
+			issnClaims: make(map[issnKey][]int64),
+			issnAll:    make(map[issnKey][]int32),
+			origins:    make(map[int64]string),
+		},
+	}
+}
 
-// Add a lookup function to the database
-func (db MetricsDatabase) LookupISSN(issn string) (JournalMetrics, bool) {
-	// remove non-numeric characters from the ISSN
-	issn = strings.Map(func(r rune) rune {
+// Atomically replace the contents of the database with those of next,
+// typically a freshly loaded copy. next must not be used afterwards.
+func (db *MetricsDatabase) Replace(next *MetricsDatabase) {
+	next.mu.Lock()
+	indexes := next.metricsIndexes
+	next.metricsIndexes = metricsIndexes{}
+	next.mu.Unlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.metricsIndexes = indexes
+}
+
+// Clean up an ISSN so it can be used as a key: keep only the digits and
+// the X check digit
+func normalizeISSN(issn string) string {
+	return strings.Map(func(r rune) rune {
 		if r >= '0' && r <= '9' {
 			return r
 		}
+		if r == 'x' || r == 'X' {
+			return 'X'
+		}
 		return -1
 	}, issn)
+}
+
+// Clean up a journal title so it can be used as a key: lowercase, with
+// punctuation removed and runs of whitespace collapsed
+func normalizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, title)
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// Add a journal to the database. Rows for the same journal (by SCImago
+// source ID) and year are merged, collecting the subject fields and ISSNs
+// of each. When another record already exists for one of its ISSNs or its
+// title, the most recent year wins, though the database's DedupStrategy
+// may pick another record for the ISSN; ISSNs listed by another journal
+// are noted for ISSNConflicts. The SJR of every year is kept for SJRTrend.
+func (db *MetricsDatabase) Add(metrics JournalMetrics) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if metrics.SJR != nil {
+		years, ok := db.sjrByID[metrics.SourceID]
+		if !ok {
+			years = make(map[int64]float64)
+			db.sjrByID[metrics.SourceID] = years
+		}
+		years[metrics.Year] = *metrics.SJR
+	}
+	db.addToFieldDistribution(metrics)
+
+	metrics.Title = db.intern(metrics.Title)
+	metrics.Publisher = db.intern(metrics.Publisher)
+	metrics.Country = db.intern(metrics.Country)
+	metrics.Coverage = db.intern(metrics.Coverage)
+	issns := make([]string, len(metrics.ISSNs))
+	for i, issn := range metrics.ISSNs {
+		issns[i] = db.intern(issn)
+	}
+	metrics.ISSNs = issns
+
+	key := sourceYear{metrics.SourceID, metrics.Year}
+	index, ok := db.records[key]
+	if ok {
+		metrics = mergeRows(db.journals[index], metrics)
+		db.journals[index] = metrics
+	} else {
+		index = int32(len(db.journals))
+		db.journals = append(db.journals, metrics)
+		db.records[key] = index
+	}
+
+	// Index the record, replacing older years
+	replaces := func(found int32) bool {
+		return db.journals[found].Year < metrics.Year
+	}
+	for _, issn := range metrics.ISSNs {
+		issn, ok := makeISSNKey(issn)
+		if !ok {
+			continue
+		}
+		found, ok := db.byISSN[issn]
+		if ok && db.journals[found].SourceID != metrics.SourceID {
+			db.noteISSNConflict(issn, db.journals[found].SourceID, metrics.SourceID)
+		}
+		if !ok || db.prefers(metrics, db.journals[found]) {
+			db.byISSN[issn] = index
+		}
+		if db.dedup == DedupKeepAll && !slices.Contains(db.issnAll[issn], index) {
+			db.issnAll[issn] = append(db.issnAll[issn], index)
+		}
+	}
+	title := normalizeTitle(metrics.Title)
+	if found, ok := db.byTitle[title]; !ok || replaces(found) {
+		db.byTitle[db.intern(title)] = index
+		if !ok {
+			db.titleIndex = nil
+		}
+	}
+	if found, ok := db.bySourceID[metrics.SourceID]; !ok || replaces(found) {
+		db.bySourceID[metrics.SourceID] = index
+	}
+}
+
+// The shared copy of s. The first copy is cloned, so it doesn't keep alive
+// the larger string, such as a whole CSV line, that s may be part of.
+func (db *MetricsDatabase) intern(s string) string {
+	if shared, ok := db.interned[s]; ok {
+		return shared
+	}
+	s = strings.Clone(s)
+	db.interned[s] = s
+	return s
+}
+
+// Merge another row for the same journal and year into a record, adding
+// any subject fields and ISSNs it doesn't have yet
+func mergeRows(record, row JournalMetrics) JournalMetrics {
+	fields := append([]SubjectField(nil), record.Fields...)
+	for _, f := range row.Fields {
+		if !hasField(fields, f.Code) {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Code < fields[j].Code
+	})
+	record.Fields = fields
+
+	issns := append([]string(nil), record.ISSNs...)
+	for _, issn := range row.ISSNs {
+		if !slices.Contains(issns, issn) {
+			issns = append(issns, issn)
+		}
+	}
+	record.ISSNs = issns
+	if record.SNIP == nil {
+		record.SNIP = row.SNIP
+	}
+	if record.Publisher == "" {
+		record.Publisher = row.Publisher
+	}
+	if record.Country == "" {
+		record.Country = row.Country
+	}
+	if record.Coverage == "" {
+		record.Coverage = row.Coverage
+	}
+	if record.OpenAccess == nil {
+		record.OpenAccess = row.OpenAccess
+	}
+	if record.DiamondOpenAccess == nil {
+		record.DiamondOpenAccess = row.DiamondOpenAccess
+	}
+	return record
+}
+
+// Whether the fields include the given code
+func hasField(fields []SubjectField, code int64) bool {
+	for _, f := range fields {
+		if f.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Look up a journal by ISSN. ISSNs the journal had in earlier years find
+// its current record.
+func (db *MetricsDatabase) LookupISSN(issn string) (JournalMetrics, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.lookupISSN(issn)
+}
+
+func (db *MetricsDatabase) lookupISSN(issn string) (JournalMetrics, bool) {
+	index, ok := db.findISSN(issn)
+	if ok {
+		index, _ = db.current(index)
+	}
+	return db.record(index, ok)
+}
+
+// The most recent record listing an ISSN, which is from an earlier year
+// than the journal's current record when the journal has since changed its
+// ISSNs. ISSNs the metrics don't list find the record of another ISSN with
+// the same ISSN-L, when an ISSN-L table is loaded.
+func (db *MetricsDatabase) findISSN(issn string) (int32, bool) {
 	// keys in the database are the cleaned-up ISSNs
-	jm, ok := db[issn]
-	return jm, ok
+	key, ok := makeISSNKey(issn)
+	if !ok {
+		return 0, false
+	}
+	index, ok := db.byISSN[key]
+	if !ok {
+		return db.findLinkedISSN(key)
+	}
+	return index, ok
+}
+
+// The record at index, if the index was found
+func (db *MetricsDatabase) record(index int32, found bool) (JournalMetrics, bool) {
+	if !found {
+		return JournalMetrics{}, false
+	}
+	return db.journals[index], true
+}
+
+// Look up a journal by its SCImago source ID, which stays the same when a
+// journal's ISSNs change. Returns the most recent year's record.
+func (db *MetricsDatabase) LookupSourceID(sourceID int64) (JournalMetrics, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.lookupSourceID(sourceID)
 }
 
-// Load
-func ReadMetricsCSV(filename string) (MetricsDatabase, error) {
+func (db *MetricsDatabase) lookupSourceID(sourceID int64) (JournalMetrics, bool) {
+	index, ok := db.bySourceID[sourceID]
+	return db.record(index, ok)
+}
+
+// Look up a journal by its title, ignoring case and punctuation. Former
+// titles find the journal's current record.
+func (db *MetricsDatabase) LookupTitle(title string) (JournalMetrics, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.lookupTitle(title)
+}
+
+func (db *MetricsDatabase) lookupTitle(title string) (JournalMetrics, bool) {
+	index, ok := db.byTitle[normalizeTitle(title)]
+	if ok {
+		index, _ = db.current(index)
+	}
+	return db.record(index, ok)
+}
+
+// The indexes of the columns of the metrics CSV, found by their headings
+// after mapping them with columnMap. The required columns default to the
+// order Title,field,year,SJR,h-index,avg_citations,Issn,Sourceid; the
+// optional ones are -1 when the CSV doesn't have them.
+type metricsColumns struct {
+	title, field, year, sjr, hIndex, avgCitations, issn, sourceID int
+
+	snip, publisher, country, coverage, openAccess, diamondOpenAccess int
+}
+
+func findMetricsColumns(header []string, columnMap map[string]string) metricsColumns {
+	cols := metricsColumns{
+		title: 0, field: 1, year: 2, sjr: 3, hIndex: 4, avgCitations: 5, issn: 6, sourceID: 7,
+		snip: -1, publisher: -1, country: -1, coverage: -1, openAccess: -1, diamondOpenAccess: -1,
+	}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if mapped, ok := columnMap[name]; ok {
+			name = strings.ToLower(strings.TrimSpace(mapped))
+		}
+		switch name {
+		case "title":
+			cols.title = i
+		case "field":
+			cols.field = i
+		case "year":
+			cols.year = i
+		case "sjr":
+			cols.sjr = i
+		case "h-index", "h index":
+			cols.hIndex = i
+		case "avg_citations":
+			cols.avgCitations = i
+		case "issn":
+			cols.issn = i
+		case "sourceid":
+			cols.sourceID = i
+		case "snip":
+			cols.snip = i
+		case "publisher":
+			cols.publisher = i
+		case "country":
+			cols.country = i
+		case "coverage":
+			cols.coverage = i
+		case "open access":
+			cols.openAccess = i
+		case "open access diamond", "diamond open access":
+			cols.diamondOpenAccess = i
+		}
+	}
+	return cols
+}
+
+// Parse a single row of the metrics CSV into a JournalMetrics
+func parseMetricsRecord(record []string, cols metricsColumns) (JournalMetrics, error) {
+	field, err := strconv.ParseInt(record[cols.field], 10, 64)
+	if err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing field value: %v", err)
+	}
+
+	year, err := strconv.ParseInt(record[cols.year], 10, 64)
+	if err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing year value: %v", err)
+	}
+
+	// Parse the values
+	var sjr *float64
+	if record[cols.sjr] != "" {
+		v, err := parseFiniteFloat(record[cols.sjr])
+		if err != nil {
+			return JournalMetrics{}, fmt.Errorf("error parsing SJR value: %v", err)
+		}
+		sjr = &v
+	}
+
+	hIndex, err := strconv.ParseInt(record[cols.hIndex], 10, 64)
+	if err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing h-index value: %v", err)
+	}
+
+	var avgCitations *float64
+	if record[cols.avgCitations] != "" {
+		v, err := parseFiniteFloat(record[cols.avgCitations])
+		if err != nil {
+			return JournalMetrics{}, fmt.Errorf("error parsing average citations value: %v", err)
+		}
+		avgCitations = &v
+	}
+
+	sourceID, err := strconv.ParseInt(record[cols.sourceID], 10, 64)
+	if err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing sourceID value: %v", err)
+	}
+
+	// Create the journal metrics
+	metrics := NewJournalMetrics(
+		record[cols.title], // Title
+		field,
+		year,
+		sjr,               // SJR
+		hIndex,            // h-index
+		avgCitations,      // avg_citations
+		record[cols.issn], // ISSN string
+		sourceID,          // SourceID
+	)
+	if cols.snip >= 0 && record[cols.snip] != "" {
+		v, err := parseFiniteFloat(record[cols.snip])
+		if err != nil {
+			return JournalMetrics{}, fmt.Errorf("error parsing SNIP value: %v", err)
+		}
+		metrics.SNIP = &v
+	}
+	if cols.publisher >= 0 {
+		metrics.Publisher = strings.TrimSpace(record[cols.publisher])
+	}
+	if cols.country >= 0 {
+		metrics.Country = strings.TrimSpace(record[cols.country])
+	}
+	if cols.coverage >= 0 {
+		metrics.Coverage = strings.TrimSpace(record[cols.coverage])
+	}
+	if metrics.OpenAccess, err = parseYesNo(record, cols.openAccess); err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing open access value: %v", err)
+	}
+	if metrics.DiamondOpenAccess, err = parseYesNo(record, cols.diamondOpenAccess); err != nil {
+		return JournalMetrics{}, fmt.Errorf("error parsing diamond open access value: %v", err)
+	}
+	return metrics, nil
+}
+
+// Parse a metric of the metrics CSV. ParseFloat accepts "NaN" and "Inf",
+// which aren't metrics and can't be written as JSON, so they are errors.
+func parseFiniteFloat(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, err
+}
+
+// Parse a Yes/No column of the metrics CSV, or nil when the column is
+// missing or empty
+func parseYesNo(record []string, column int) (*bool, error) {
+	if column < 0 {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(record[column])) {
+	case "":
+		return nil, nil
+	case "yes", "true", "1":
+		return optionalBool(true), nil
+	case "no", "false", "0":
+		return optionalBool(false), nil
+	default:
+		return nil, fmt.Errorf("expected Yes or No, got %q", record[column])
+	}
+}
+
+// Load the metrics CSV into a database keyed by ISSN, e.g.
+//
+//	ReadMetricsCSV(path, WithYear(2022), WithLenient())
+//
+// With WithLenient, malformed rows are skipped with a warning instead of
+// aborting the load; the number of skipped rows is returned alongside the
+// database.
+func ReadMetricsCSV(filename string, options ...MetricsOption) (*MetricsDatabase, int, error) {
 	// Open the CSV file
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("error opening file: %v", err)
+		return nil, 0, fmt.Errorf("error opening file: %w", err)
 	}
 	defer file.Close()
 
+	return ReadMetricsFrom(file, options...)
+}
+
+// Load a metrics CSV from r, such as an HTTP response body or a file in
+// an archive, as ReadMetricsCSV does
+func ReadMetricsFrom(r io.Reader, options ...MetricsOption) (*MetricsDatabase, int, error) {
+	opts := newMetricsReadOptions(options)
+
 	// Create a CSV reader
-	reader := csv.NewReader(file)
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
 
-	// Read the header
-	_, err = reader.Read()
+	// Read the header. Besides the fixed columns, it may name the
+	// Publisher and Country columns of the full SCImago CSV, or a SNIP
+	// column, e.g. when the CWTS Journal Indicators have been joined in.
+	header, err := reader.Read()
 	if err != nil {
-		return nil, fmt.Errorf("error reading header: %v", err)
+		return nil, 0, fmt.Errorf("error reading header: %v", err)
 	}
+	cols := findMetricsColumns(header, opts.ColumnMap)
 
 	// Create the database
-	db := make(MetricsDatabase)
+	db := NewMetricsDatabase()
+	db.dedup, db.dedupYear = opts.Dedup, opts.Year
+	skipped := 0
 
 	// Read the rest of the records
 	for {
@@ -98,76 +689,87 @@ func ReadMetricsCSV(filename string) (MetricsDatabase, error) {
 		if err == io.EOF {
 			break
 		}
+		var metrics JournalMetrics
 		if err != nil {
-			return nil, fmt.Errorf("error reading record: %v", err)
+			err = fmt.Errorf("error reading record: %v", err)
+		} else {
+			metrics, err = parseMetricsRecord(record, cols)
+			if err != nil {
+				line, _ := reader.FieldPos(0)
+				err = fmt.Errorf("line %d: %v", line, err)
+			}
 		}
-
-		field, err := strconv.ParseInt(record[1], 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing field value: %v", err)
+			if !opts.Lenient {
+				return nil, skipped, err
+			}
+			log.Printf("Warning: skipping CSV row: %v", err)
+			skipped++
+			continue
 		}
 
-		year, err := strconv.ParseInt(record[2], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing year value: %v", err)
+		// Index this journal's metrics by each of its ISSNs and its title
+		db.Add(metrics)
+	}
+	db.RankWithinFields()
+	if opts.Dedup == DedupErrorOnConflict {
+		if err := conflictError(db.ISSNConflicts()); err != nil {
+			return nil, skipped, err
 		}
+	}
 
-		// Parse the values
-		// Assuming the CSV columns are in order:
-		// Title,field,year,SJR,h-index,avg_citations,Issn,Sourceid
-		sjr := -1.0
-		if record[3] != "" {
-			sjr, err = strconv.ParseFloat(record[3], 64)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing SJR value: %v", err)
-			}
-		}
+	return db, skipped, nil
+}
 
-		hIndex, err := strconv.ParseInt(record[4], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing h-index value: %v", err)
-		}
+// Parse a whole OAI-PMH ListRecords response from r, such as an HTTP
+// response body. ReadPublications reads large harvests one record at a
+// time instead.
+func ParseOAIPMH(r io.Reader) (*OAIPMH, error) {
+	var doc OAIPMH
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error parsing OAI-PMH response: %v", err)
+	}
+	return &doc, nil
+}
 
-		avgCitations := -1.0
-		if record[5] != "" {
-			avgCitations, err = strconv.ParseFloat(record[5], 64)
-			if err != nil {
-				return nil, fmt.Errorf("error parsing average citations value: %v", err)
-			}
+// The publications of the records, from metadata in format as for
+// ReadPublications. Records without such metadata are left out.
+func (doc *OAIPMH) Publications(format string) []Publication {
+	var pubs []Publication
+	for _, record := range doc.ListRecords.Records {
+		if pub, ok := record.Publication(format); ok {
+			pubs = append(pubs, pub)
 		}
+	}
+	return pubs
+}
 
-		sourceID, err := strconv.ParseInt(record[7], 10, 64)
+// Call fn with each record of an OAI-PMH document read from r, decoding one
+// record at a time, so huge harvests can be processed in constant memory.
+// An error from fn stops the iteration and is returned.
+func ForEachRecord(r io.Reader, fn func(Record) error) error {
+	decoder := xml.NewDecoder(bufio.NewReader(r))
+	for n := 1; ; {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("error parsing sourceID value: %v", err)
-		}
-
-		// Create the journal metrics
-		// Using 0 for field, year, and sourceID as they're not in the CSV
-		metrics := NewJournalMetrics(
-			record[0], // Title
-			field,
-			year,
-			sjr,          // SJR
-			hIndex,       // h-index
-			avgCitations, // avg_citations
-			record[6],    // ISSN string
-			sourceID,     // SourceID
-		)
-
-		// Add each ISSN as a key pointing to this journal's metrics
-		for _, issn := range metrics.ISSNs {
-			// See if the ISSN is already in the database
-			if found, ok := db[issn]; ok {
-				if found.Year < metrics.Year {
-					db[issn] = metrics
-				}
-			} else {
-				db[issn] = metrics
-			}
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" || start.Name.Space == marcNamespace {
+			continue
+		}
+		var record Record
+		if err := decoder.DecodeElement(&record, &start); err != nil {
+			return fmt.Errorf("error parsing record %d: %v", n, err)
 		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		n++
 	}
-
-	return db, nil
 }
 
 type OAIPMH struct {
@@ -192,6 +794,12 @@ type Record struct {
 	Metadata Metadata `xml:"metadata"`
 }
 
+// The publication of a record, from metadata in format as for
+// ReadPublications, or false when the record has no such metadata
+func (r Record) Publication(format string) (Publication, bool) {
+	return r.Metadata.publication(format)
+}
+
 type Header struct {
 	Identifier string `xml:"identifier"`
 	Datestamp  string `xml:"datestamp"`
@@ -200,56 +808,295 @@ type Header struct {
 
 type Metadata struct {
 	Publication Publication `xml:"Publication"`
+
+	// DataCite records, harvested with the oai_datacite metadata prefix
+	// (wrapped in a payload) or with plain datacite
+	DataCite        *dataCiteResource `xml:"resource"`
+	WrappedDataCite *dataCiteResource `xml:"oai_datacite>payload>resource"`
+
+	MODS *modsRecord `xml:"mods"`
+	MARC *marcRecord `xml:"record"`
+
+	// MODS wrapped in METS, as EPrints serves it
+	METSMODS *modsRecord `xml:"mets>dmdSec>mdWrap>xmlData>mods"`
+}
+
+// Metadata formats that records can be read from, by the name used with
+// --metadata-format. "auto" reads whichever one each record holds, and
+// "csv" and "doi" read a publication or DOI list rather than records.
+var metadataFormats = map[string]bool{
+	"auto":     true,
+	"cerif":    true,
+	"datacite": true,
+	"mods":     true,
+	"marcxml":  true,
+	"csv":      true,
+	"doi":      true,
+}
+
+// Namespace of MARCXML, whose record elements share their name with
+// OAI-PMH's
+const marcNamespace = "http://www.loc.gov/MARC21/slim"
+
+// The publication a record describes in the given metadata format, or in
+// whichever one it holds for "auto". Returns false if the record has no
+// metadata in that format.
+func (m Metadata) publication(format string) (Publication, bool) {
+	dataCite := m.DataCite
+	if dataCite == nil {
+		dataCite = m.WrappedDataCite
+	}
+	if m.MODS == nil {
+		m.MODS = m.METSMODS
+	}
+	auto := format == "auto" || format == ""
+	switch {
+	case dataCite != nil && (auto || format == "datacite"):
+		return dataCite.Publication(), true
+	case m.MODS != nil && (auto || format == "mods"):
+		return m.MODS.Publication(), true
+	case m.MARC != nil && (auto || format == "marcxml"):
+		return m.MARC.Publication(), true
+	case auto:
+		return resolveAuthorNames(m.Publication), true
+	case format == "cerif":
+		return resolveAuthorNames(m.Publication), m.Publication.ID != "" || m.Publication.Title != ""
+	}
+	return Publication{}, false
 }
 
 type Publication struct {
-	ID        string      `xml:"id,attr"`
-	Type      string      `xml:"Type"`
-	Language  string      `xml:"Language"`
-	Title     string      `xml:"Title"`
-	Subtitle  string      `xml:"Subtitle"`
-	Published PublishedIn `xml:"PublishedIn"`
-	Date      string      `xml:"PublicationDate"`
-	Volume    string      `xml:"Volume"`
-	Issue     string      `xml:"Issue"`
-	DOI       string      `xml:"DOI"`
-	ISSN      string      `xml:"ISSN"`
-	URL       string      `xml:"URL"`
-	Authors   Authors     `xml:"Authors"`
+	ID        string      `xml:"id,attr" json:"id"`
+	Type      string      `xml:"Type" json:"type,omitempty"`
+	Language  string      `xml:"Language" json:"language,omitempty"`
+	Title     string      `xml:"Title" json:"title"`
+	Subtitle  string      `xml:"Subtitle" json:"subtitle,omitempty"`
+	Published PublishedIn `xml:"PublishedIn" json:"published_in"`
+	Date      string      `xml:"PublicationDate" json:"date,omitempty"`
+	Volume    string      `xml:"Volume" json:"volume,omitempty"`
+	Issue     string      `xml:"Issue" json:"issue,omitempty"`
+	DOI       string      `xml:"DOI" json:"doi,omitempty"`
+	ISSN      string      `xml:"ISSN" json:"issn,omitempty"`
+	URL       string      `xml:"URL" json:"url,omitempty"`
+	Authors   Authors     `xml:"Authors" json:"authors"`
+	Abstract  string      `xml:"Abstract" json:"abstract,omitempty"`
+	Keywords  []string    `xml:"Keyword" json:"keywords,omitempty"`
+
+	// Pure's extensions: the publication status ("Published", "E-pub ahead
+	// of print", ...), whether it was peer reviewed, and its funding
+	Status       string    `xml:"Status" json:"status,omitempty"`
+	PeerReviewed string    `xml:"PeerReviewed" json:"peer_reviewed,omitempty"` // see peerReviewed
+	Funding      []Funding `xml:"OriginatesFrom>Funding" json:"funding,omitempty"`
+
+	// Only filled in from DataCite metadata
+	Publisher string `xml:"-" json:"publisher,omitempty"`
+	EntryType string `xml:"-" json:"entry_type,omitempty"` // BibTeX entry type, "" for article
+	ArXiv     string `xml:"-" json:"arxiv,omitempty"`      // see arxivID for the identifier from any metadata
+
+	// DOI of the journal version of a preprint, from DataCite metadata or
+	// found by linkPreprints
+	PublishedVersion string `xml:"-" json:"published_version,omitempty"`
+
+	// How often the publication has been cited, when looked up by
+	// enrichCitationsFromCrossref
+	Citations *int `xml:"-" json:"citations,omitempty"`
+
+	// The Web of Science accession number, and whether Web of Science
+	// indexes the journal (nil when unknown), when looked up by
+	// enrichFromWoS
+	WoSID      string `xml:"-" json:"wos_id,omitempty"`
+	WoSIndexed *bool  `xml:"-" json:"wos_indexed,omitempty"`
+
+	// The volume and issue as the metadata gave them, when
+	// normalizeVolumeIssue found them at odds
+	VolumeNote string `xml:"-" json:"volume_note,omitempty"`
+
+	// How the journal's metrics were found, set by renderEntries when
+	// they were
+	Match *MetricsMatch `xml:"-" json:"match,omitempty"`
+
+	// The SJR trend of the journal, set by renderEntries along with Match
+	// when the database has at least two years of it
+	Trend *SJRTrend `xml:"-" json:"-"`
+
+	// Event Data event counts by source, e.g. "twitter", when looked up by
+	// enrichEventsFromCrossref
+	Events map[string]int `xml:"-" json:"events,omitempty"`
+
+	// The citation key assignCitationKeys gave the publication, or "" when
+	// createCitationKey should make one
+	Key string `xml:"-" json:"-"`
 }
 
 type Authors struct {
-	AuthorList []Author `xml:"Author"`
+	AuthorList []Author `xml:"Author" json:"author_list,omitempty"`
 }
 
 type Author struct {
-	Person Person `xml:"Person"`
+	Person       Person    `xml:"Person" json:"person"`
+	Affiliations []OrgUnit `xml:"Affiliation>OrgUnit" json:"affiliations,omitempty"`
+
+	// Pure's markers of the corresponding author and of authors who
+	// contributed equally, see corresponding and equalContribution
+	Corresponding     string `xml:"Corresponding" json:"corresponding,omitempty"`
+	EqualContribution string `xml:"EqualContribution" json:"equal_contribution,omitempty"`
+
+	// A group or consortium author, which CERIF gives as an OrgUnit or
+	// just a DisplayName in place of the Person; see resolveAuthorNames
+	Group       *OrgUnit `xml:"OrgUnit" json:"-"`
+	DisplayName string   `xml:"DisplayName" json:"-"`
 }
 
 type Person struct {
-	PersonName PersonName `xml:"PersonName"`
+	PersonName PersonName `xml:"PersonName" json:"name"`
+	ORCID      string     `xml:"ORCID" json:"orcid,omitempty"`
+
+	// Whether the "person" is an organization named by FamilyNames, which
+	// only DataCite metadata tells
+	Organization bool `xml:"-" json:"organization,omitempty"`
 }
 
 type PersonName struct {
-	FamilyNames string `xml:"FamilyNames"`
-	FirstNames  string `xml:"FirstNames"`
+	FamilyNames string `xml:"FamilyNames" json:"family_names"`
+	FirstNames  string `xml:"FirstNames" json:"first_names,omitempty"`
 }
 
 type PublishedIn struct {
-	Publication JournalInfo `xml:"Publication"`
+	Publication JournalInfo `xml:"Publication" json:"publication"`
 }
 
 type JournalInfo struct {
-	Type  string `xml:"Type"`
-	Title string `xml:"Title"`
+	Type  string `xml:"Type" json:"type,omitempty"`
+	Title string `xml:"Title" json:"title,omitempty"`
+}
+
+// Read the publications from an OAI-PMH XML document, one record at a
+// time. Records may hold CERIF, DataCite, MODS or MARCXML metadata; format
+// is one of metadataFormats, and records without metadata in a format
+// other than "auto" are skipped. Standalone DataCite, MODS and MARCXML
+// documents, DataCite REST API JSON, CSV or TSV publication lists, and
+// lists of DOIs, whose metadata is fetched from Crossref, are read as
+// well. When lenient is true the decoder is relaxed and a record that
+// fails to decode is skipped with a warning, and reading goes on with the
+// next record; since the XML stream can't be resynchronized after a syntax
+// error, reading stops there but the publications decoded so far are
+// kept. The number of skipped records is returned alongside the
+// publications. HTML that repositories leave in titles is cleaned up, see
+// cleanText, as are noisy volumes and issues, see normalizeVolumeIssue.
+func ReadPublications(r io.Reader, format string, lenient bool) ([]Publication, int, error) {
+	pubs, skipped, err := readPublications(r, format, lenient)
+	cleanTitles(pubs)
+	normalizeVolumes(pubs)
+	return pubs, skipped, err
+}
+
+// ReadPublications, before titles are cleaned up
+func readPublications(r io.Reader, format string, lenient bool) ([]Publication, int, error) {
+	auto := format == "auto" || format == ""
+	buffered := bufio.NewReader(r)
+	if (auto || format == "datacite") && isJSON(buffered) {
+		pubs, err := readDataCiteJSON(buffered)
+		return pubs, 0, err
+	}
+	if format == "doi" || auto && isDOIList(buffered) {
+		return readDOIList(buffered, lenient)
+	}
+	if format == "csv" || auto && isDelimited(buffered) {
+		return readPublicationsCSV(buffered, lenient)
+	}
+
+	decoder := xml.NewDecoder(buffered)
+	if lenient {
+		decoder.Strict = false
+		decoder.AutoClose = xml.HTMLAutoClose
+		decoder.Entity = xml.HTMLEntity
+	}
+
+	var pubs []Publication
+	skipped := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !lenient {
+				return nil, skipped, err
+			}
+			log.Printf("Warning: stopping at malformed XML: %v", err)
+			skipped++
+			break
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		// Standalone documents in the other formats, outside of OAI-PMH
+		// records
+		switch {
+		case start.Name.Local == "resource" && (auto || format == "datacite"):
+			var resource dataCiteResource
+			if err := decoder.DecodeElement(&resource, &start); err != nil {
+				return nil, skipped, fmt.Errorf("error parsing DataCite resource: %v", err)
+			}
+			pubs = append(pubs, resource.Publication())
+			continue
+		case start.Name.Local == "mods" && (auto || format == "mods"):
+			var mods modsRecord
+			if err := decoder.DecodeElement(&mods, &start); err != nil {
+				return nil, skipped, fmt.Errorf("error parsing MODS record %d: %v", len(pubs)+skipped+1, err)
+			}
+			pubs = append(pubs, mods.Publication())
+			continue
+		case start.Name.Local == "record" && start.Name.Space == marcNamespace && (auto || format == "marcxml"):
+			var marc marcRecord
+			if err := decoder.DecodeElement(&marc, &start); err != nil {
+				return nil, skipped, fmt.Errorf("error parsing MARCXML record %d: %v", len(pubs)+skipped+1, err)
+			}
+			pubs = append(pubs, marc.Publication())
+			continue
+		}
+		if start.Name.Local != "record" {
+			continue
+		}
+
+		var record Record
+		if err := decoder.DecodeElement(&record, &start); err != nil {
+			if !lenient {
+				return nil, skipped, fmt.Errorf("error parsing record %d: %v", len(pubs)+skipped+1, err)
+			}
+			log.Printf("Warning: skipping record %d: %v", len(pubs)+skipped+1, err)
+			skipped++
+			if err := decoder.Skip(); err != nil {
+				break
+			}
+			continue
+		}
+		pub, ok := record.Metadata.publication(format)
+		if !ok {
+			log.Printf("Warning: skipping record %d without %s metadata", len(pubs)+skipped+1, format)
+			skipped++
+			continue
+		}
+		pubs = append(pubs, pub)
+	}
+
+	return pubs, skipped, nil
 }
 
-// Function to create a BibTeX citation key
+// Function to create a BibTeX citation key: the one assignCitationKeys
+// gave the publication, or the first author's family name and the year
 func createCitationKey(pub Publication) string {
+	if pub.Key != "" {
+		return pub.Key
+	}
+
 	// Get first author's last name or "Unknown"
 	authorName := "Unknown"
-	if len(pub.Authors.AuthorList) > 0 {
-		authorName = pub.Authors.AuthorList[0].Person.PersonName.FamilyNames
+	if authors := namedAuthors(pub.Authors.AuthorList); len(authors) > 0 {
+		authorName = authors[0].Person.PersonName.FamilyNames
 	}
 
 	// Get year from date
@@ -272,44 +1119,200 @@ func createCitationKey(pub Publication) string {
 	return key
 }
 
-// Function to format authors for BibTeX
-func formatAuthors(authors []Author) string {
+// Give publications whose citation keys would collide keys with a letter
+// added, in order: two Jensen2021 become Jensen2021a and Jensen2021b.
+// BibTeX compares keys case-insensitively, and so does this.
+func assignCitationKeys(pubs []Publication) {
+	count := map[string]int{}
+	for i := range pubs {
+		pubs[i].Key = ""
+		count[strings.ToLower(createCitationKey(pubs[i]))]++
+	}
+	used := map[string]bool{}
+	for i := range pubs {
+		key := createCitationKey(pubs[i])
+		if count[strings.ToLower(key)] > 1 {
+			for n := 0; ; n++ {
+				suffixed := key + keySuffix(n)
+				if !used[strings.ToLower(suffixed)] && count[strings.ToLower(suffixed)] == 0 {
+					key = suffixed
+					break
+				}
+			}
+		}
+		used[strings.ToLower(key)] = true
+		pubs[i].Key = key
+	}
+}
+
+// The letters added to the nth colliding key: a to z, then aa, ab, ...
+func keySuffix(n int) string {
+	suffix := string(rune('a' + n%26))
+	for n /= 26; n > 0; n = (n - 1) / 26 {
+		suffix = string(rune('a'+(n-1)%26)) + suffix
+	}
+	return suffix
+}
+
+// Function to format authors for BibTeX. Lists cut by --max-authors end
+// in "and others", which BibTeX styles print as "et al."
+func formatAuthors(authors []Author, opts bibtexOptions) string {
+	listed, cut := listedAuthors(authors, opts)
 	var names []string
-	for _, author := range authors {
-		name := fmt.Sprintf("%s, %s",
-			author.Person.PersonName.FamilyNames,
-			author.Person.PersonName.FirstNames)
-		names = append(names, name)
+	for _, author := range listed {
+		names = append(names, formatAuthorName(author, opts))
+	}
+	if cut {
+		names = append(names, "others")
 	}
 	return strings.Join(names, " and ")
 }
 
-// Function to convert a publication to BibTeX format
-func toBibTeX(pub Publication, metrics JournalMetrics) string {
-	var bibtex strings.Builder
+// Options controlling how publications are rendered as BibTeX
+type bibtexOptions struct {
+	JournalStyle     string // one of journalStyles
+	AuthorStyle      string // one of authorStyles
+	NormalizeAuthors bool   // clean up whitespace, capitals and particles in names
+	URLFromDOI       bool   // fill a missing url field with the DOI resolver link
+	Abstracts        bool   // include the abstract field
+	Keywords         bool   // include the keywords field
+	SubjectKeywords  bool   // add the journal's ASJC subject categories to the keywords
+	MetricPrecision  int    // digits after the decimal point in metrics fields
+	MetricsYear      string // --metrics-year policy, or "" for the record LookupISSN finds
+	MaxAuthors       int    // authors to list before "and others", or 0 for all
+	TitleProtection  string // one of titleProtections, or "" for all
+	SubtitleStyle    string // one of subtitleStyles, or "" for drop
+	Self             string // person to bold in the author lists of Markdown output, given as for --self, or ""
+
+	// Field order, indentation, alignment and trailing commas of the
+	// entries. The escaping is left at none, since bibtexEntry escapes the
+	// text fields itself and leaves the \url in notes alone.
+	Layout bibtex.Options
+}
+
+// Collapse the whitespace in keywords, dropping blanks and duplicates
+func cleanKeywords(keywords []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, keyword := range keywords {
+		keyword = strings.Join(strings.Fields(keyword), " ")
+		if keyword == "" || seen[strings.ToLower(keyword)] {
+			continue
+		}
+		seen[strings.ToLower(keyword)] = true
+		out = append(out, keyword)
+	}
+	return out
+}
+
+// Join keywords for the BibTeX keywords field, dropping blanks and
+// duplicates. Keywords are escaped for BibTeX, and those containing commas
+// are braced so that BibLaTeX keeps them whole.
+func formatKeywords(keywords []string) string {
+	var out []string
+	for _, keyword := range cleanKeywords(keywords) {
+		keyword = bibtex.EscapeText(keyword)
+		if strings.Contains(keyword, ",") {
+			keyword = "{" + keyword + "}"
+		}
+		out = append(out, keyword)
+	}
+	return strings.Join(out, ", ")
+}
+
+// The options of the default mode when no flags are given
+func defaultBibtexOptions() bibtexOptions {
+	return bibtexOptions{
+		JournalStyle:    "full",
+		AuthorStyle:     "full",
+		MetricPrecision: 6,
+	}
+}
+
+// Parse a --field-order or --omit-fields value: comma-separated field
+// names
+func parseFieldNames(spec string) []string {
+	var fields []string
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// Parse an --indent value: a number of spaces, at least one, or "tab"
+func parseIndent(spec string) (string, error) {
+	if strings.EqualFold(strings.TrimSpace(spec), "tab") {
+		return "\t", nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(spec))
+	if err != nil || n < 1 {
+		return "", fmt.Errorf("--indent must be a number of spaces or tab, not %q", spec)
+	}
+	return strings.Repeat(" ", n), nil
+}
+
+// Prefixes that DOIs are found with in metadata: resolver URLs and "doi:"
+var doiPrefixes = []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"}
 
+// The https://doi.org/ link for a DOI, which may already be given as a
+// resolver URL or with a "doi:" prefix
+func doiURL(doi string) string {
+	doi = strings.TrimSpace(doi)
+	for _, prefix := range doiPrefixes {
+		if len(doi) >= len(prefix) && strings.EqualFold(doi[:len(prefix)], prefix) {
+			doi = doi[len(prefix):]
+			break
+		}
+	}
+	return "https://doi.org/" + doi
+}
+
+// Function to convert a publication to BibTeX format. metrics is nil when
+// the publication's journal has no metrics.
+func toBibTeX(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+	var out strings.Builder
+	err := bibtex.NewEncoder(&out, opts.Layout).Encode(bibtexEntry(pub, metrics, opts))
+	return out.String(), err
+}
+
+// The BibTeX entry of a publication, with its journal's metrics unless
+// metrics is nil. Text fields are escaped with bibtex.EscapeText.
+func bibtexEntry(pub Publication, metrics *JournalMetrics, opts bibtexOptions) bibtex.Entry {
 	// Start entry
-	citationKey := createCitationKey(pub)
-	bibtex.WriteString(fmt.Sprintf("@article{%s,\n", citationKey))
+	entry := bibtex.Entry{Type: pub.EntryType, Key: createCitationKey(pub)}
+	if entry.Type == "" {
+		entry.Type = "article"
+		if isUnpublishedPreprint(pub) {
+			entry.Type = "misc"
+		}
+	}
 
 	// Authors
 	if len(pub.Authors.AuthorList) > 0 {
-		authors := formatAuthors(pub.Authors.AuthorList)
-		bibtex.WriteString(fmt.Sprintf("  author = {%s},\n", authors))
+		entry.Add("author", formatAuthors(escapedAuthors(pub.Authors.AuthorList), opts))
+		entry.Add("author+an", formatEqualContributions(pub.Authors.AuthorList, opts))
 	}
 
 	// Title
 	if pub.Title != "" {
-		bibtex.WriteString(fmt.Sprintf("  title = {{%s}},\n", pub.Title))
+		entry.Add("title", protectTitle(bibtex.EscapeText(displayTitle(pub, opts)), opts.TitleProtection))
+	}
+	if opts.SubtitleStyle == "field" && pub.Subtitle != "" {
+		entry.Add("subtitle", protectTitle(bibtex.EscapeText(pub.Subtitle), opts.TitleProtection))
 	}
 
 	// Journal
 	if pub.Published.Publication.Title != "" {
-		bibtex.WriteString(fmt.Sprintf("  journal = {%s},\n", pub.Published.Publication.Title))
+		entry.Add("journal", bibtex.EscapeText(abbreviateJournalTitle(pub.Published.Publication.Title, opts.JournalStyle)))
 	}
+	entry.Add("publisher", bibtex.EscapeText(pub.Publisher))
 
-	// Year and Month
-	if pub.Date != "" {
+	// Year and Month. Publications that aren't out yet only have the date
+	// they were accepted, so they are labelled instead.
+	forthcoming := forthcomingLabel(pub)
+	if pub.Date != "" && forthcoming == "" {
 		// Try to parse the date
 		t, err := time.Parse("2006-01-02", pub.Date)
 		if err != nil {
@@ -317,71 +1320,154 @@ func toBibTeX(pub Publication, metrics JournalMetrics) string {
 			t, err = time.Parse("2006-01", pub.Date)
 		}
 		if err == nil {
-			bibtex.WriteString(fmt.Sprintf("  year = {%d},\n", t.Year()))
-			bibtex.WriteString(fmt.Sprintf("  month = {%s},\n", strings.ToLower(t.Month().String())))
+			entry.Add("year", strconv.Itoa(t.Year()))
+			entry.Add("month", strings.ToLower(t.Month().String()))
 		} else {
 			// Just use the year part if we have it
 			if len(pub.Date) >= 4 {
-				bibtex.WriteString(fmt.Sprintf("  year = {%s},\n", pub.Date[0:4]))
+				entry.Add("year", pub.Date[0:4])
 			}
 		}
 	}
 
-	// Volume
-	if pub.Volume != "" {
-		bibtex.WriteString(fmt.Sprintf("  volume = {%s},\n", pub.Volume))
+	entry.Add("volume", bibtex.EscapeText(pub.Volume))
+	entry.Add("number", bibtex.EscapeText(pub.Issue))
+	entry.Add("doi", pub.DOI)
+
+	// URL, falling back to the DOI link
+	if pub.URL != "" {
+		entry.Add("url", pub.URL)
+	} else if pub.DOI != "" && opts.URLFromDOI {
+		entry.Add("url", doiURL(pub.DOI))
 	}
 
-	// Issue/Number
-	if pub.Issue != "" {
-		bibtex.WriteString(fmt.Sprintf("  number = {%s},\n", pub.Issue))
+	// arXiv identifier, in the fields arXiv's own BibTeX uses
+	if eprint := arxivID(pub); eprint != "" {
+		entry.Add("eprint", eprint)
+		entry.Add("archivePrefix", "arXiv")
 	}
 
-	// DOI
-	if pub.DOI != "" {
-		bibtex.WriteString(fmt.Sprintf("  doi = {%s},\n", pub.DOI))
+	// Where a preprint was published, that the publication isn't out yet,
+	// or its volume and issue as given when they couldn't be cleaned up
+	var notes []string
+	if forthcoming != "" {
+		notes = append(notes, bibtex.EscapeText(forthcoming))
 	}
+	if pub.PublishedVersion != "" {
+		notes = append(notes, fmt.Sprintf("Published version: \\url{%s}", doiURL(pub.PublishedVersion)))
+	}
+	if pub.VolumeNote != "" {
+		notes = append(notes, bibtex.EscapeText(pub.VolumeNote))
+	}
+	entry.Add("note", strings.Join(notes, ". "))
+
+	entry.Add("issn", pub.ISSN)
 
-	// ISSN
-	if pub.ISSN != "" {
-		bibtex.WriteString(fmt.Sprintf("  issn = {%s},\n", pub.ISSN))
+	// Language, for BibLaTeX hyphenation
+	entry.Add("langid", languageID(pub.Language))
+
+	// Abstract and keywords, for annotated bibliographies
+	if opts.Abstracts {
+		entry.Add("abstract", bibtex.EscapeText(strings.Join(strings.Fields(pub.Abstract), " ")))
+	}
+	var keywords []string
+	if opts.Keywords {
+		keywords = append(keywords, pub.Keywords...)
+	}
+	if opts.SubjectKeywords && metrics != nil {
+		keywords = append(keywords, subjectKeywords(*metrics)...)
 	}
+	entry.Add("keywords", formatKeywords(keywords))
 
-	// Add the impact factor stuff
-	bibtex.WriteString(fmt.Sprintf("  sjr = {%f},\n", metrics.SJR))
-	bibtex.WriteString(fmt.Sprintf("  avg_citations = {%f},\n", metrics.AvgCitations))
-	bibtex.WriteString(fmt.Sprintf("  h_index = {%d},\n", metrics.HIndex))
+	// ORCID iDs, in the "Name/iD" format used by Web of Science exports
+	entry.Add("orcid-numbers", bibtex.EscapeText(formatORCIDs(pub.Authors.AuthorList, opts)))
 
-	// Remove trailing comma and add closing brace
-	output := bibtex.String()
-	output = strings.TrimSuffix(output, ",\n") + "\n}\n"
+	// Add the impact factor stuff. Journals without metrics get none of
+	// these fields, and values missing from the metrics CSV are left out.
+	if metrics != nil {
+		addMetric := func(name string, v *float64) {
+			if v != nil {
+				entry.Add(name, strconv.FormatFloat(*v, 'f', opts.MetricPrecision, 64))
+			}
+		}
+		addMetric("sjr", metrics.SJR)
+		addMetric("avg_citations", metrics.AvgCitations)
+		entry.Add("h_index", strconv.FormatInt(metrics.HIndex, 10))
+		entry.Add("quartile", formatQuartile(metrics.Quartile))
+		addMetric("sjr_percentile", metrics.SJRPercentile)
+		addMetric("h_index_percentile", metrics.HIndexPercentile)
+		addMetric("field_normalized_citations", metrics.FieldNormalizedCitations)
+		addMetric("citescore", metrics.CiteScore)
+		addMetric("snip", metrics.SNIP)
+		addMetric("citescore_percentile", metrics.CiteScorePercentile)
+		addMetric("eigenfactor", metrics.Eigenfactor)
+		addMetric("article_influence", metrics.ArticleInfluence)
+		entry.Add("journal_open_access", metrics.OpenAccessStatus())
+		if opts.MetricsYear != "" {
+			entry.Add("sjr_year", strconv.FormatInt(metrics.Year, 10))
+		}
+	}
+	return entry
+}
 
-	return output
+// Metrics that papers can be sorted by, keyed by the name used on the
+// command line. Each returns nil when the journal has no value.
+var sortKeys = map[string]func(JournalMetrics) *float64{
+	"avg_citations": func(m JournalMetrics) *float64 { return m.AvgCitations },
+	"sjr":           func(m JournalMetrics) *float64 { return m.SJR },
+	"h_index":       func(m JournalMetrics) *float64 { return optionalFloat(float64(m.HIndex)) },
+	"snip":          func(m JournalMetrics) *float64 { return m.SNIP },
 }
 
-// Sort papers by average citations. Takes a slice of publications and a map of journal metrics.
-// Returns a slice of publications sorted by average citations.
-// If a publication's journal is not found in the metrics map, it is placed at the end.
-func sortPapersByCitations(papers []Publication, metrics MetricsDatabase) []Publication {
+// Sort papers by a journal metric, in descending order. Takes a slice of
+// publications, a map of journal metrics, and the name of one of the
+// sortKeys, with each journal's metrics for the year picked by the
+// metricsYear policy. Returns a slice of publications sorted by that metric.
+// If a publication's journal is not found in the metrics map, or has no
+// value for the metric, it is placed at the end.
+// Ties are broken by publication date (newest first), citation key, title
+// and record ID, so the same input always gives the same order.
+func sortPapers(papers []Publication, metrics *MetricsDatabase, by, metricsYear string) []Publication {
+	key := sortKeys[by]
+
 	// Create a slice of papers with metrics
-	var papersWithMetrics []struct {
+	type paperWithMetrics struct {
 		pub     Publication
-		metrics JournalMetrics
+		value   *float64
+		citeKey string
 	}
+	var papersWithMetrics []paperWithMetrics
 	for _, paper := range papers {
-		metrics, ok := metrics.LookupISSN(paper.ISSN)
-		if !ok {
-			metrics = JournalMetrics{}
+		var value *float64
+		if metrics, ok := metrics.lookupForYear(paper, metricsYear); ok {
+			value = key(metrics)
 		}
-		papersWithMetrics = append(papersWithMetrics, struct {
-			pub     Publication
-			metrics JournalMetrics
-		}{pub: paper, metrics: metrics})
+		papersWithMetrics = append(papersWithMetrics, paperWithMetrics{
+			pub:     paper,
+			value:   value,
+			citeKey: createCitationKey(paper),
+		})
 	}
 
-	// Sort the papers by average citations
-	sort.Slice(papersWithMetrics, func(i, j int) bool {
-		return papersWithMetrics[i].metrics.AvgCitations > papersWithMetrics[j].metrics.AvgCitations
+	// Sort the papers by the chosen metric
+	sort.SliceStable(papersWithMetrics, func(i, j int) bool {
+		a, b := papersWithMetrics[i], papersWithMetrics[j]
+		if (a.value == nil) != (b.value == nil) {
+			return a.value != nil
+		}
+		if a.value != nil && *a.value != *b.value {
+			return *a.value > *b.value
+		}
+		if a.pub.Date != b.pub.Date {
+			return a.pub.Date > b.pub.Date
+		}
+		if a.citeKey != b.citeKey {
+			return a.citeKey < b.citeKey
+		}
+		if a.pub.Title != b.pub.Title {
+			return a.pub.Title < b.pub.Title
+		}
+		return a.pub.ID < b.pub.ID
 	})
 
 	// Extract the sorted papers
@@ -394,46 +1480,403 @@ func sortPapersByCitations(papers []Publication, metrics MetricsDatabase) []Publ
 }
 
 func main() {
-	// Get file name from os.Args
-	if len(os.Args) != 3 {
-		log.Printf("Usage: %s <paper xml filename> <impact factor csv>", os.Args[0])
-		os.Exit(1)
+	// Dispatch to a subcommand if one is given
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lookup":
+			runLookup(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "journals":
+			runJournals(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "graph":
+			runGraph(os.Args[2:])
+			return
+		case "metrics":
+			runMetrics(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "site":
+			runSite(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "bundle":
+			runBundle(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		}
 	}
-	xmlFilename := os.Args[1]
-	csvFilename := os.Args[2]
 
-	// Read the XML file
-	xmlData, err := os.ReadFile(xmlFilename)
-	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
-		return
+	configPath := flag.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := flag.Bool("lenient", false, "skip malformed CSV rows and XML records instead of aborting")
+	metricsPath := flag.String("metrics", "", "path to the impact factor csv, instead of passing it as an argument")
+	sources := metricsSourceFlags(flag.CommandLine)
+	crossrefFlags(flag.CommandLine)
+	network := networkFlags(flag.CommandLine)
+	bundlePath := flag.String("bundle", "", "run offline against this bundle made with the bundle command, using its data files and cached responses")
+	sortBy := flag.String("sort", "avg_citations", "journal metric to sort papers by: avg_citations, sjr, h_index, or snip")
+	outputPath := flag.String("o", "", "write the output to this file instead of standard output")
+	outputRoot := flag.String("output-root", "", "when given a directory of paper XML files, write each output under this directory, mirroring the input tree, instead of next to its input")
+	repoProfile := flag.String("repo-profile", "", "repository platform whose metadata quirks to handle: dspace, eprints, or pure")
+	metadataFormat := flag.String("metadata-format", "auto", "metadata format of the paper records: auto, cerif, datacite, mods, marcxml, csv, or doi")
+	format := flag.String("format", "bibtex", "output format: bibtex, pandoc (BibTeX plus a Markdown list of citations next to the -o file), latex (a table of publications and metrics), json, atom, or zotero-rdf")
+	templatePath := flag.String("template", "", "render each publication with this Go text/template file instead of --format")
+	journalStyle := flag.String("journal-style", "full", "journal title style: full, iso4, or nlm")
+	ltwaPath := flag.String("ltwa", "", "file of additional LTWA title word abbreviations for --journal-style iso4 and nlm")
+	authorStyle := flag.String("author-style", "full", "author given name style: full or initials")
+	titleProtection := flag.String("title-protection", "all", "how to keep BibTeX styles from lowercasing titles: all (brace the whole title), words (brace only acronyms, formulas, math and likely proper nouns), or none")
+	fieldOrder := flag.String("field-order", "", "comma-separated BibTeX fields to write first, in this order, e.g. author,title,journal,year; the others follow")
+	indent := flag.String("indent", "2", "indentation of BibTeX fields: a number of spaces, or tab")
+	alignFields := flag.Bool("align-fields", false, "pad BibTeX field names so the equals signs line up")
+	omitFields := flag.String("omit-fields", "", "comma-separated BibTeX fields to leave out, e.g. quartile,sjr_percentile,langid")
+	trailingComma := flag.Bool("trailing-comma", false, "end the last field of each BibTeX entry with a comma too")
+	subtitleStyle := flag.String("subtitle", "drop", "what to do with subtitles: drop, append (to the title, as \"Title: Subtitle\"), or field (a BibLaTeX subtitle field)")
+	self := flag.String("self", "", "with --format pandoc, list the authors after each citation in the Markdown file, with this person, given as \"Family, Initials\" or an ORCID iD, in bold")
+	protectedWordsPath := flag.String("protected-words", "", "file of words to brace in titles with --title-protection words, one per line as they should be capitalized")
+	maxAuthors := flag.Int("max-authors", 0, "list at most this many authors of a publication, ending longer lists in \"and others\" (\"et al.\" in Atom feeds); 0 for all")
+	normalizeAuthors := flag.Bool("normalize-authors", false, "collapse whitespace, fix ALL-CAPS names and place particles like \"van der\" in the family name")
+	urlFromDOI := flag.Bool("url-from-doi", false, "fill in the url field from the DOI when a publication has no URL")
+	abstracts := flag.Bool("abstracts", false, "include publication abstracts in an abstract field")
+	keywords := flag.Bool("keywords", false, "include publication keywords in a keywords field")
+	subjectKeywords := flag.Bool("subject-keywords", false, "add the names of the journal's ASJC subject categories to the keywords field")
+	asjcPath := flag.String("asjc-file", "", "CSV file of ASJC category codes and names for --subject-keywords")
+	issnlPath := flag.String("issnl-file", "", "ISSN-to-ISSN-L table, so that papers listing another ISSN of a journal than the metrics still find it")
+	language := flag.String("language", "", "only output publications in these comma-separated languages, e.g. en or en,da")
+	metricsYear := flag.String("metrics-year", "", "which year's metrics to attach to each paper when the impact factor csv has several: publication (the paper's year), latest, or a year such as 2022; records the year in an sjr_year field")
+	maxYearGap := flag.Int("max-metrics-year-gap", 2, "warn about publications whose journal metrics are from more than this many years before or after them; -1 to turn off")
+	metricPrecision := flag.Int("metric-precision", 6, "number of digits after the decimal point in metrics fields")
+	statuses := flag.String("status", "", "only output publications with these comma-separated statuses, e.g. published or \"published,e-pub ahead of print\"")
+	peerReviewedOnly := flag.Bool("peer-reviewed", false, "only output peer-reviewed publications")
+	orgUnit := flag.String("org-unit", "", "only output publications with an author in this organisational unit, given by ID, name or acronym")
+	correspondingAuthor := flag.String("corresponding-author", "", "only output publications this person is marked as a corresponding author of, given as \"Family, Initials\" or an ORCID iD")
+	matchROR := flag.Bool("match-ror", false, "match author affiliations without a ROR identifier to ROR by name")
+	institution := flag.String("institution", "", "only output publications with an author from this institution, given as a ROR identifier or a name")
+	inPress := flag.String("in-press", "include", "publications that are accepted but not yet published: include (labelled in place of a year), exclude, or only")
+	publishers := flag.String("publisher", "", "only output publications in journals of these comma-separated publishers, matched as whole words, e.g. Elsevier")
+	excludePublishers := flag.String("exclude-publisher", "", "leave out publications in journals of these comma-separated publishers")
+	countries := flag.String("country", "", "only output publications in journals from these comma-separated countries, e.g. \"United States,Netherlands\"")
+	openAccess := flag.String("open-access", "", "only output publications in journals with these comma-separated open access statuses: yes (including diamond), diamond, or no")
+	scopus := flag.Bool("scopus", false, "look up the publications by DOI and their journals by ISSN on Scopus, filling in missing article metadata and adding CiteScore, SJR and SNIP for journals the metrics lack; needs an Elsevier API key")
+	scopusFlags(flag.CommandLine)
+	wos := flag.Bool("wos", false, "look up the publications by DOI and their journals by ISSN on the Web of Science Starter API, filling in missing article metadata and citation counts and noting whether Web of Science indexes the journal; needs a Clarivate API key")
+	wosFlags(flag.CommandLine)
+	providers := flag.String("providers", "csv", "comma-separated journal providers to try in order until one finds a publication's journal: csv (the impact factor csv), cache (journals found before, kept in --cache-dir), openalex, crossref, and scopus")
+	eventData := flag.Bool("event-data", false, "look up mentions of the publications in social media, news and Wikipedia in Crossref Event Data, for --format json and --template")
+	linkMode := flag.String("link-preprints", "off", "look up the published versions of preprints on Crossref: off, annotate (add a note linking them), or replace (drop preprints whose published version is listed)")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "number of publications to render in parallel")
+	validate := flag.String("validate", "warn", "check the generated BibTeX for syntax errors and duplicate keys: off, warn, or error")
+	watch := flag.Bool("watch", false, "keep running and regenerate the -o file whenever the paper XML or impact factor csv changes")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "how often --watch checks the inputs for changes")
+	auditPath := flag.String("audit", "", "write a CSV of how the journal of each publication was matched (exact ISSN, ISSN-L or former ISSN), and the source and year of its metrics, to this file")
+	manifestPath := flag.String("manifest", "", "write a JSON manifest of the run (inputs and outputs with their SHA-256, metrics years, counts, and publications without metrics) to this file")
+	failOnMissRate := flag.Float64("fail-on-miss-rate", 1, "exit with status 4 when more than this fraction of publications lack journal metrics")
+	jobFile := flag.String("job-file", "", "run the jobs listed in this YAML file, each with its own input, output and flags, sharing loaded metrics")
+	flag.Usage = func() {
+		log.Printf("Usage: %s [flags] <paper xml filename or directory> [impact factor csv]", os.Args[0])
+		log.Printf("       %s lookup [flags] --stdin", os.Args[0])
+		log.Printf("       %s serve [flags]", os.Args[0])
+		log.Printf("       %s journals search [flags] <title words>", os.Args[0])
+		log.Printf("       %s report [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		log.Printf("       %s bench [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		log.Printf("       %s graph [flags] <paper xml filename>", os.Args[0])
+		log.Printf("       %s metrics diff [flags] <old csv> <new csv>", os.Args[0])
+		log.Printf("       %s diff [flags] <old xml filename> <new xml filename> [impact factor csv]", os.Args[0])
+		log.Printf("       %s site [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		log.Printf("       %s schema <output name|openapi>", os.Args[0])
+		log.Printf("       %s bundle [flags] -o <bundle file> [impact factor csv]", os.Args[0])
+		log.Printf("       %s config set-key [flags] <provider> [key]", os.Args[0])
+		flag.PrintDefaults()
 	}
-
-	journalDB, err := ReadMetricsCSV(csvFilename)
-	if err != nil {
-		log.Fatalln(err)
+	flag.Parse()
+	if err := applyConfig(flag.CommandLine, "", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if *bundlePath != "" {
+		if err := applyBundle(flag.CommandLine, *bundlePath); err != nil {
+			fatalf(inputExitCode(err), "%v", err)
+		}
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := applyRepoProfileFlags(flag.CommandLine, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
 	}
 
-	// Parse the XML
-	var oaiData OAIPMH
-	err = xml.Unmarshal(xmlData, &oaiData)
-	if err != nil {
-		fmt.Printf("Error parsing XML: %v\n", err)
-		return
+	// Check the flags and build the configuration of a run from them and
+	// the paper XML and impact factor csv arguments. Exits on usage errors.
+	configure := func(args []string) (generateConfig, bool) {
+		if _, ok := sortKeys[*sortBy]; !ok {
+			log.Printf("Unknown sort metric %q", *sortBy)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+
+		if _, ok := outputFormats[*format]; !ok {
+			log.Printf("Unknown output format %q", *format)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if *templatePath != "" {
+			if _, err := parseEntryTemplate(*templatePath); err != nil {
+				fatalf(exitUsage, "%v", err)
+			}
+			*format = "bibtex" // only the template is used; this skips the companion file check
+		}
+		if !metadataFormats[*metadataFormat] {
+			log.Printf("Unknown metadata format %q", *metadataFormat)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !journalStyles[*journalStyle] {
+			log.Printf("Unknown journal style %q", *journalStyle)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if *metricPrecision < 0 {
+			log.Printf("--metric-precision must not be negative")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if *scopus {
+			if _, err := scopusAPIKey(); err != nil {
+				fatalf(exitUsage, "%v", err)
+			}
+		}
+		if *wos {
+			if _, err := wosAPIKey(); err != nil {
+				fatalf(exitUsage, "%v", err)
+			}
+		}
+		if _, err := parseProviderChain(*providers, network.CacheDir); err != nil {
+			log.Printf("%v", err)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if err := checkMetricsYear(*metricsYear); err != nil {
+			log.Printf("%v", err)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !inPressModes[*inPress] {
+			log.Printf("Unknown --in-press mode %q", *inPress)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		for _, status := range strings.Split(*openAccess, ",") {
+			if status = strings.ToLower(strings.TrimSpace(status)); *openAccess != "" && status != "yes" && status != "diamond" && status != "no" {
+				log.Printf("Unknown open access status %q", status)
+				flag.Usage()
+				os.Exit(exitUsage)
+			}
+		}
+		if !linkPreprintModes[*linkMode] {
+			log.Printf("Unknown preprint linking mode %q", *linkMode)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !validateModes[*validate] {
+			log.Printf("Unknown validation mode %q", *validate)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !authorStyles[*authorStyle] {
+			log.Printf("Unknown author style %q", *authorStyle)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !titleProtections[*titleProtection] {
+			log.Printf("Unknown title protection %q", *titleProtection)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		indentation, err := parseIndent(*indent)
+		if err != nil {
+			log.Printf("%v", err)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !subtitleStyles[*subtitleStyle] {
+			log.Printf("Unknown subtitle style %q", *subtitleStyle)
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if *protectedWordsPath != "" {
+			if err := loadProtectedWords(*protectedWordsPath); err != nil {
+				fatalf(inputExitCode(err), "%v", err)
+			}
+		}
+		if *maxAuthors < 0 {
+			log.Printf("--max-authors must not be negative")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if *ltwaPath != "" {
+			if err := loadLTWA(*ltwaPath); err != nil {
+				fatalf(inputExitCode(err), "%v", err)
+			}
+		}
+		if *asjcPath != "" {
+			if err := loadASJC(*asjcPath); err != nil {
+				fatalf(inputExitCode(err), "%v", err)
+			}
+		}
+		if *issnlPath != "" {
+			if err := loadISSNL(*issnlPath); err != nil {
+				fatalf(inputExitCode(err), "%v", err)
+			}
+		}
+		bibOpts := bibtexOptions{
+			JournalStyle:     *journalStyle,
+			AuthorStyle:      *authorStyle,
+			NormalizeAuthors: *normalizeAuthors,
+			URLFromDOI:       *urlFromDOI,
+			Abstracts:        *abstracts,
+			Keywords:         *keywords,
+			SubjectKeywords:  *subjectKeywords,
+			MetricPrecision:  *metricPrecision,
+			MetricsYear:      *metricsYear,
+			MaxAuthors:       *maxAuthors,
+			TitleProtection:  *titleProtection,
+			SubtitleStyle:    *subtitleStyle,
+			Self:             *self,
+			Layout: bibtex.Options{
+				FieldOrder:    parseFieldNames(*fieldOrder),
+				Omit:          parseFieldNames(*omitFields),
+				Indent:        indentation,
+				Align:         *alignFields,
+				TrailingComma: *trailingComma,
+			},
+		}
+
+		// Get file names from the remaining arguments, falling back to the
+		// configured metrics path when only the XML file is given
+		if len(args) == 1 && *metricsPath != "" {
+			args = append(args, *metricsPath)
+		}
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		info, err := os.Stat(args[0])
+		batch := err == nil && info.IsDir()
+		if batch && (*outputPath != "" || *watch || *manifestPath != "" || *auditPath != "") {
+			log.Printf("-o, --watch, --manifest and --audit can't be used with a directory of paper XML files; use --output-root to choose where the outputs go")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if !batch && *outputRoot != "" {
+			log.Printf("--output-root needs a directory of paper XML files")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		if companionPath := outputFormats[*format].CompanionPath; companionPath != nil && !batch {
+			if *outputPath == "" || companionPath(*outputPath) == *outputPath {
+				log.Printf("--format %s needs an output file given with -o, such as -o publications.bib", *format)
+				flag.Usage()
+				os.Exit(exitUsage)
+			}
+		}
+		if *watch && *outputPath == "" {
+			log.Printf("--watch needs an output file given with -o")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		cfg := generateConfig{
+			XMLFilename:       args[0],
+			CSVFilename:       args[1],
+			Sources:           *sources,
+			MetadataFormat:    *metadataFormat,
+			RepoProfile:       *repoProfile,
+			OutputPath:        *outputPath,
+			Format:            *format,
+			Template:          *templatePath,
+			ManifestPath:      *manifestPath,
+			Lenient:           *lenient,
+			SortBy:            *sortBy,
+			Language:          *language,
+			LinkPreprints:     *linkMode,
+			Statuses:          *statuses,
+			InPress:           *inPress,
+			PeerReviewed:      *peerReviewedOnly,
+			OrgUnit:           *orgUnit,
+			Corresponding:     *correspondingAuthor,
+			MatchROR:          *matchROR,
+			Institution:       *institution,
+			Publishers:        *publishers,
+			ExcludePublishers: *excludePublishers,
+			Countries:         *countries,
+			OpenAccess:        *openAccess,
+			EventData:         *eventData,
+			Scopus:            *scopus,
+			WoS:               *wos,
+			Providers:         *providers,
+			AuditPath:         *auditPath,
+			CacheDir:          network.CacheDir,
+			Jobs:              *jobs,
+			Validate:          *validate,
+			FailOnMissRate:    *failOnMissRate,
+			MaxMetricsYearGap: *maxYearGap,
+			BibOpts:           bibOpts,
+		}
+		return cfg, batch
 	}
 
-	// Extract the Publication from each Record
-	pubs := make([]Publication, 0, len(oaiData.ListRecords.Records))
-	for _, record := range oaiData.ListRecords.Records {
-		pubs = append(pubs, record.Metadata.Publication)
+	// Run the jobs of a job file instead, with the flags as defaults
+	if *jobFile != "" {
+		if flag.NArg() != 0 || *watch {
+			log.Printf("--job-file takes the paper XML and impact factor csv from the jobs' input and metrics settings, not from arguments, and can't be used with --watch")
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		abortOnSignal(abortAtomicFiles)
+		results := runJobFile(*jobFile, configure)
+		writeBatchSummaryText(os.Stderr, results)
+		if code := batchExitCode(results); code != 0 {
+			os.Exit(code)
+		}
+		return
 	}
+	cfg, batch := configure(flag.Args())
 
-	pubs = sortPapersByCitations(pubs, journalDB)
+	// Discard partial output files when interrupted
+	abortOnSignal(abortAtomicFiles)
 
-	// Print DOI and ISSN for each paper
-	for _, pub := range pubs {
-		issn := pub.ISSN
-		metrics, _ := journalDB.LookupISSN(issn)
-		fmt.Println(toBibTeX(pub, metrics))
+	if *watch {
+		watchAndGenerate(cfg, *watchInterval)
+		return
+	}
+	journalDB, err := loadGenerateMetrics(cfg)
+	if err != nil {
+		fatalf(exitCodeFor(err), "%v", err)
+	}
+	if batch {
+		results, err := generateBatch(cfg, journalDB, cfg.XMLFilename, *outputRoot)
+		if err != nil {
+			fatalf(exitCodeFor(err), "%v", err)
+		}
+		writeBatchSummaryText(os.Stderr, results)
+		if code := batchExitCode(results); code != 0 {
+			os.Exit(code)
+		}
+		return
+	}
+	if _, err := generate(cfg, journalDB); err != nil {
+		fatalf(exitCodeFor(err), "%v", err)
 	}
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// A record of one run of the default mode written by --manifest, for
+// reproducing the run and auditing where its output came from
+type RunManifest struct {
+	Tool      ManifestTool    `json:"tool"`
+	Arguments []string        `json:"arguments"` // the command line, without the program name
+	Started   time.Time       `json:"started"`
+	Finished  time.Time       `json:"finished"`
+	Inputs    []ManifestFile  `json:"inputs"`
+	Outputs   []ManifestFile  `json:"outputs"`
+	Metrics   ManifestMetrics `json:"metrics"`
+	Counts    ManifestCounts  `json:"counts"`
+	Misses    []ManifestMiss  `json:"misses"` // the publications without journal metrics
+}
+
+// The build of the tool that made the run
+type ManifestTool struct {
+	Version   string `json:"version"`            // module version, or "(devel)"
+	Revision  string `json:"revision,omitempty"` // VCS revision the binary was built from
+	GoVersion string `json:"go_version"`
+}
+
+// A file read or written by the run. Output written to standard output
+// isn't listed.
+type ManifestFile struct {
+	Role   string `json:"role"` // "papers", "metrics", "citescore", "eigenfactor", "output", "companion", or "audit"
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// The metrics data the run looked journals up in
+type ManifestMetrics struct {
+	Records  int     `json:"records"`  // rows, one per journal and year
+	Journals int     `json:"journals"` // distinct SCImago source IDs
+	Years    []int64 `json:"years"`    // the years the data covers, oldest first
+}
+
+// How many publications went through each stage of the run
+type ManifestCounts struct {
+	Read           int `json:"read"`            // publications read from the paper XML
+	SkippedRecords int `json:"skipped_records"` // malformed records skipped in lenient mode
+	Output         int `json:"output"`          // publications left after filtering
+	WithMetrics    int `json:"with_metrics"`
+	WithoutMetrics int `json:"without_metrics"`
+	Preprints      int `json:"preprints"` // unpublished preprints, which aren't looked up
+	Invalid        int `json:"invalid"`   // entries that failed validation
+	YearGaps       int `json:"year_gaps"` // publications whose metrics are from more than --max-metrics-year-gap years away
+
+	// Publications whose journal each --providers provider found, and
+	// those no provider found, when the chain has more than the CSV
+	Providers      map[string]int `json:"providers,omitempty"`
+	ProviderMisses int            `json:"provider_misses,omitempty"`
+}
+
+// A publication whose journal wasn't found
+type ManifestMiss struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Journal string `json:"journal"`
+	ISSN    string `json:"issn"`
+}
+
+// The tool's build information
+func manifestTool() ManifestTool {
+	tool := ManifestTool{Version: "(devel)", GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Version != "" {
+			tool.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				tool.Revision = setting.Value
+			}
+		}
+	}
+	return tool
+}
+
+// The size and SHA-256 of the file at path
+func hashFile(role, path string) (ManifestFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Role: role, Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// The number of records and journals in the database and the years they
+// cover
+func (db *MetricsDatabase) summary() ManifestMetrics {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	summary := ManifestMetrics{Records: len(db.journals), Journals: len(db.bySourceID)}
+	years := map[int64]bool{}
+	for _, journal := range db.journals {
+		if !years[journal.Year] {
+			years[journal.Year] = true
+			summary.Years = append(summary.Years, journal.Year)
+		}
+	}
+	sort.Slice(summary.Years, func(i, j int) bool { return summary.Years[i] < summary.Years[j] })
+	return summary
+}
+
+// Write the manifest to path as indented JSON, atomically
+func writeManifest(path string, manifest RunManifest) error {
+	file, err := createAtomicFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return file.Commit()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A MARC 21 bibliographic record in MARCXML
+type marcRecord struct {
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []marcDataField `xml:"datafield"`
+}
+
+type marcDataField struct {
+	Tag       string `xml:"tag,attr"`
+	Subfields []struct {
+		Code  string `xml:"code,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"subfield"`
+}
+
+// The first value of a subfield, or ""
+func (f marcDataField) subfield(code string) string {
+	for _, sub := range f.Subfields {
+		if sub.Code == code {
+			return strings.TrimSpace(sub.Value)
+		}
+	}
+	return ""
+}
+
+// Every value of a subfield
+func (f marcDataField) subfields(code string) []string {
+	var values []string
+	for _, sub := range f.Subfields {
+		if sub.Code == code {
+			values = append(values, strings.TrimSpace(sub.Value))
+		}
+	}
+	return values
+}
+
+// Volume and issue in the related parts of a 773 host item entry, like
+// "Vol. 12, no. 3 (2021), p. 1-10"
+var (
+	marcVolumePattern = regexp.MustCompile(`(?i)\bvol(?:ume)?\.?\s*(\d+)`)
+	marcIssuePattern  = regexp.MustCompile(`(?i)\b(?:no|nr|issue|iss)\.?\s*(\d+)`)
+)
+
+// Strip the ISBD punctuation that ends MARC fields ("Title /", "Jensen,
+// Kyle,")
+func trimMARC(s string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(s), " /:;,="))
+}
+
+// Map a MARCXML record onto a Publication. Added entry names (700) are kept
+// when their relator is author or isn't given.
+func (m marcRecord) Publication() Publication {
+	var pub Publication
+	for _, field := range m.ControlFields {
+		value := field.Value
+		switch field.Tag {
+		case "001":
+			pub.ID = strings.TrimSpace(value)
+		case "008":
+			// Date 1 and language code at fixed positions
+			if len(value) >= 11 && pub.Date == "" {
+				if year := strings.TrimSpace(value[7:11]); len(year) == 4 && !strings.ContainsAny(year, "u|") {
+					pub.Date = year
+				}
+			}
+			if len(value) >= 38 {
+				if code := strings.TrimSpace(value[35:38]); code != "" && !strings.ContainsAny(code, "|") {
+					pub.Language = code
+				}
+			}
+		}
+	}
+
+	for _, field := range m.DataFields {
+		switch field.Tag {
+		case "024":
+			if strings.EqualFold(field.subfield("2"), "doi") {
+				pub.DOI = field.subfield("a")
+			}
+			if strings.EqualFold(field.subfield("2"), "arxiv") {
+				pub.ArXiv = field.subfield("a")
+			}
+		case "041":
+			if code := field.subfield("a"); code != "" {
+				pub.Language = code
+			}
+		case "100", "700":
+			if field.Tag == "700" && !marcIsAuthor(field) {
+				continue
+			}
+			family, given, _ := strings.Cut(trimMARC(field.subfield("a")), ",")
+			person := Person{PersonName: PersonName{FamilyNames: strings.TrimSpace(family), FirstNames: trimMARC(given)}}
+			for _, id := range append(field.subfields("0"), field.subfields("1")...) {
+				if strings.Contains(strings.ToLower(id), "orcid") {
+					person.ORCID = id
+				}
+			}
+			author := Author{Person: person}
+			for _, affiliation := range field.subfields("u") {
+				author.Affiliations = append(author.Affiliations, OrgUnit{Name: trimMARC(affiliation)})
+			}
+			pub.Authors.AuthorList = append(pub.Authors.AuthorList, author)
+		case "110", "710":
+			if field.Tag == "710" && !marcIsAuthor(field) {
+				continue
+			}
+			pub.Authors.AuthorList = append(pub.Authors.AuthorList, Author{Person: Person{
+				PersonName:   PersonName{FamilyNames: trimMARC(field.subfield("a"))},
+				Organization: true,
+			}})
+		case "245":
+			pub.Title = trimMARC(field.subfield("a"))
+			pub.Subtitle = trimMARC(field.subfield("b"))
+		case "260", "264":
+			if date := trimMARC(strings.Trim(field.subfield("c"), "[]c©.")); date != "" {
+				pub.Date = date
+			}
+			if publisher := trimMARC(field.subfield("b")); publisher != "" && pub.Publisher == "" {
+				pub.Publisher = publisher
+			}
+		case "520":
+			pub.Abstract = field.subfield("a")
+		case "650", "653":
+			pub.Keywords = append(pub.Keywords, trimMARC(strings.TrimSuffix(field.subfield("a"), ".")))
+		case "773":
+			pub.Published.Publication.Title = trimMARC(strings.TrimSuffix(field.subfield("t"), "."))
+			pub.ISSN = field.subfield("x")
+			related := field.subfield("g")
+			if match := marcVolumePattern.FindStringSubmatch(related); match != nil {
+				pub.Volume = match[1]
+			}
+			if match := marcIssuePattern.FindStringSubmatch(related); match != nil {
+				pub.Issue = match[1]
+			}
+		case "856":
+			if url := field.subfield("u"); url != "" && pub.URL == "" {
+				pub.URL = url
+			}
+		}
+	}
+	return pub
+}
+
+// Whether an added entry is an author: its relator term ($e) or code ($4)
+// says so, or it has neither
+func marcIsAuthor(field marcDataField) bool {
+	roles := append(field.subfields("e"), field.subfields("4")...)
+	return modsIsAuthor(roles)
+}
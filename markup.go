@@ -0,0 +1,57 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Markup tags, such as the JATS elements of Crossref abstracts and the
+// HTML italics of some titles. A "<" followed by a space or digit, as in
+// "p < 0.05", isn't a tag.
+var markupTag = regexp.MustCompile(`</?([A-Za-z][\w.-]*:)?([A-Za-z][\w.-]*)(\s[^<>]*)?/?>`)
+
+// Tags that mark up text within a line, which are dropped without
+// leaving a space, so "H<sub>2</sub>O" becomes "H2O". Other tags, such as
+// paragraphs, separate words. Names are without a namespace prefix, so
+// JATS's jats:italic is italic.
+var inlineTags = map[string]bool{
+	"a": true, "b": true, "bold": true, "em": true, "font": true, "i": true,
+	"inline-formula": true, "italic": true, "monospace": true, "sc": true,
+	"small": true, "span": true, "strong": true, "sub": true, "sup": true,
+	"tt": true, "u": true, "underline": true,
+}
+
+// s without markup tags, with its whitespace collapsed
+func stripMarkup(s string) string {
+	s = markupTag.ReplaceAllStringFunc(s, func(tag string) string {
+		if inlineTags[strings.ToLower(markupTag.FindStringSubmatch(tag)[2])] {
+			return ""
+		}
+		return " "
+	})
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Clean up text that a repository exported with HTML in it: decode HTML
+// entities, also when escaped twice ("&amp;amp;"), unwrap CDATA sections
+// that were escaped rather than used, and strip markup tags
+func cleanText(s string) string {
+	if !strings.ContainsAny(s, "&<") {
+		return s
+	}
+	for i := 0; i < 2 && strings.Contains(s, "&"); i++ {
+		s = html.UnescapeString(s)
+	}
+	s = strings.ReplaceAll(s, "<![CDATA[", "")
+	s = strings.ReplaceAll(s, "]]>", "")
+	return stripMarkup(s)
+}
+
+// Clean up the titles and subtitles of the publications with cleanText
+func cleanTitles(pubs []Publication) {
+	for i := range pubs {
+		pubs[i].Title = cleanText(pubs[i].Title)
+		pubs[i].Subtitle = cleanText(pubs[i].Subtitle)
+	}
+}
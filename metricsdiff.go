@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// The most recent record of each journal, by SCImago source ID, ordered
+// by title
+func (db *MetricsDatabase) Latest() []JournalMetrics {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	journals := make([]JournalMetrics, 0, len(db.bySourceID))
+	for _, index := range db.bySourceID {
+		journals = append(journals, db.journals[index])
+	}
+	sort.Slice(journals, func(i, j int) bool {
+		if journals[i].Title != journals[j].Title {
+			return journals[i].Title < journals[j].Title
+		}
+		return journals[i].SourceID < journals[j].SourceID
+	})
+	return journals
+}
+
+// A journal whose metrics changed between two metrics CSVs
+type JournalChange struct {
+	Old JournalMetrics `json:"old"`
+	New JournalMetrics `json:"new"`
+}
+
+// The journals added to, dropped from and changed between two metrics
+// CSVs, each ordered by title
+type MetricsDiff struct {
+	Added   []JournalMetrics `json:"added"`
+	Dropped []JournalMetrics `json:"dropped"`
+	Changed []JournalChange  `json:"changed"`
+}
+
+// Compare the most recent record of each journal in two metrics databases.
+// Journals are matched by SCImago source ID. A journal has changed when its
+// quartile changed or its SJR changed by at least minSJRChange, or gained
+// or lost a value.
+func diffMetrics(old, new *MetricsDatabase, minSJRChange float64) MetricsDiff {
+	var diff MetricsDiff
+	for _, n := range new.Latest() {
+		o, ok := old.LookupSourceID(n.SourceID)
+		if !ok {
+			diff.Added = append(diff.Added, n)
+			continue
+		}
+		sjrChanged := (o.SJR == nil) != (n.SJR == nil) ||
+			o.SJR != nil && n.SJR != nil && *o.SJR != *n.SJR && math.Abs(*n.SJR-*o.SJR) >= minSJRChange
+		if sjrChanged || o.Quartile != n.Quartile {
+			diff.Changed = append(diff.Changed, JournalChange{Old: o, New: n})
+		}
+	}
+	for _, o := range old.Latest() {
+		if _, ok := new.LookupSourceID(o.SourceID); !ok {
+			diff.Dropped = append(diff.Dropped, o)
+		}
+	}
+	return diff
+}
+
+// Write the differences as aligned text, one section each for added,
+// dropped and changed journals
+func writeMetricsDiffText(w io.Writer, diff MetricsDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	quartile := func(m JournalMetrics) string {
+		if q := formatQuartile(m.Quartile); q != "" {
+			return q
+		}
+		return "-"
+	}
+	sjr := func(m JournalMetrics) string {
+		if m.SJR == nil {
+			return "-"
+		}
+		return formatOptional(m.SJR, 3)
+	}
+
+	for _, section := range []struct {
+		name     string
+		journals []JournalMetrics
+	}{{"Added", diff.Added}, {"Dropped", diff.Dropped}} {
+		fmt.Fprintf(tw, "%s journals: %d\n", section.name, len(section.journals))
+		if len(section.journals) > 0 {
+			fmt.Fprintln(tw, "TITLE\tISSN\tYEAR\tSJR\tQUARTILE")
+			for _, m := range section.journals {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", m.Title, strings.Join(m.ISSNs, ", "), m.Year, sjr(m), quartile(m))
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintf(tw, "Changed journals: %d\n", len(diff.Changed))
+	if len(diff.Changed) > 0 {
+		fmt.Fprintln(tw, "TITLE\tYEARS\tSJR\tCHANGE\tQUARTILE")
+		for _, c := range diff.Changed {
+			change := "-"
+			if c.Old.SJR != nil && c.New.SJR != nil {
+				change = fmt.Sprintf("%+.3f", *c.New.SJR-*c.Old.SJR)
+			}
+			fmt.Fprintf(tw, "%s\t%d → %d\t%s → %s\t%s\t%s → %s\n", c.New.Title, c.Old.Year, c.New.Year,
+				sjr(c.Old), sjr(c.New), change, quartile(c.Old), quartile(c.New))
+		}
+	}
+	return tw.Flush()
+}
+
+// Write the differences as indented JSON
+func writeMetricsDiffJSON(w io.Writer, diff MetricsDiff) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(diff)
+}
+
+// The `metrics` subcommand, which groups commands that work on metrics
+// CSVs
+func runMetrics(args []string) {
+	if len(args) == 0 || args[0] != "diff" {
+		log.Printf("Usage: %s metrics diff [flags] <old csv> <new csv>", os.Args[0])
+		os.Exit(exitUsage)
+	}
+	runMetricsDiff(args[1:])
+}
+
+// The `metrics diff` subcommand: the journals whose metrics changed between
+// two vintages of the metrics CSV
+func runMetricsDiff(args []string) {
+	fs := flag.NewFlagSet("metrics diff", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows instead of aborting")
+	format := fs.String("format", "text", "output format: text or json")
+	minSJRChange := fs.Float64("min-sjr-change", 0, "only list journals whose SJR changed by at least this much, unless their quartile changed")
+	fs.Usage = func() {
+		log.Printf("Usage: %s metrics diff [flags] <old csv> <new csv>", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "metrics", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var write func(io.Writer, MetricsDiff) error
+	switch *format {
+	case "text":
+		write = writeMetricsDiffText
+	case "json":
+		write = writeMetricsDiffJSON
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	oldDB, err := loadMetrics(fs.Arg(0), metricsSources{}, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	newDB, err := loadMetrics(fs.Arg(1), metricsSources{}, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	if err := write(os.Stdout, diffMetrics(oldDB, newDB, *minSJRChange)); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
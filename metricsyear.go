@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// --metrics-year policies besides a fixed year
+const (
+	metricsYearPublication = "publication" // the year the paper was published
+	metricsYearLatest      = "latest"      // the journal's most recent year
+)
+
+// Check a --metrics-year value: publication, latest, or a year
+func checkMetricsYear(policy string) error {
+	if policy == "" || policy == metricsYearPublication || policy == metricsYearLatest {
+		return nil
+	}
+	if year, err := strconv.Atoi(policy); err != nil || year < 1000 || year > 9999 {
+		return fmt.Errorf("--metrics-year must be publication, latest, or a year, not %q", policy)
+	}
+	return nil
+}
+
+// The metrics of a publication's journal for the year picked by a
+// --metrics-year policy. A journal without a row for that year gets its
+// nearest year, the earlier one on a tie. An empty policy, and
+// publications without a year under the publication policy, get the
+// record LookupISSN finds.
+func (db *MetricsDatabase) lookupForYear(pub Publication, policy string) (JournalMetrics, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	metrics, ok := db.lookupISSN(pub.ISSN)
+	if !ok || policy == "" {
+		return metrics, ok
+	}
+	latest := db.journals[db.bySourceID[metrics.SourceID]]
+	var year int64
+	switch policy {
+	case metricsYearLatest:
+		return latest, true
+	case metricsYearPublication:
+		published, ok := publicationYear(pub)
+		if !ok {
+			return metrics, true
+		}
+		year = int64(published)
+	default:
+		year, _ = strconv.ParseInt(policy, 10, 64)
+	}
+	if year >= latest.Year {
+		return latest, true
+	}
+	for d := int64(0); ; d++ {
+		for _, y := range []int64{year - d, year + d} {
+			if index, ok := db.records[sourceYear{metrics.SourceID, y}]; ok {
+				return db.journals[index], true
+			}
+		}
+	}
+}
+
+// How many years the metrics are from after the publication, negative
+// when they're from before it. ok is false for publications without a
+// year and those that aren't out yet.
+func metricsYearGap(pub Publication, metrics JournalMetrics) (gap int, ok bool) {
+	year, ok := publicationYear(pub)
+	if !ok || forthcomingLabel(pub) != "" {
+		return 0, false
+	}
+	return int(metrics.Year) - year, true
+}
+
+// Whether a gap between the publication and metrics years exceeds maxGap.
+// A negative maxGap turns the check off.
+func exceedsYearGap(gap, maxGap int) bool {
+	return maxGap >= 0 && (gap > maxGap || -gap > maxGap)
+}
+
+// Warn about publications whose journal metrics, as picked by the
+// --metrics-year policy, are from more than maxGap years before or after
+// the publication, so the numbers describe the journal at another time.
+// Returns the number of publications warned about.
+func warnMetricsYearGaps(pubs []Publication, db *MetricsDatabase, policy string, maxGap int) int {
+	warned := 0
+	for _, pub := range pubs {
+		metrics, found := db.lookupForYear(pub, policy)
+		if !found {
+			continue
+		}
+		if gap, ok := metricsYearGap(pub, metrics); ok && exceedsYearGap(gap, maxGap) {
+			log.Printf("Warning: publication %s is from %d, but its metrics for %s are from %d", pub.ID, int(metrics.Year)-gap, metrics.Title, metrics.Year)
+			warned++
+		}
+	}
+	return warned
+}
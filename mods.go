@@ -0,0 +1,174 @@
+package main
+
+import "strings"
+
+// A MODS record, as exposed by library-run repositories with the mods
+// metadata prefix
+type modsRecord struct {
+	TitleInfo []struct {
+		Type     string `xml:"type,attr"`
+		NonSort  string `xml:"nonSort"`
+		Title    string `xml:"title"`
+		SubTitle string `xml:"subTitle"`
+	} `xml:"titleInfo"`
+	Names []struct {
+		Type      string `xml:"type,attr"`
+		NameParts []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"namePart"`
+		RoleTerms       []string `xml:"role>roleTerm"`
+		Affiliations    []string `xml:"affiliation"`
+		NameIdentifiers []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"nameIdentifier"`
+	} `xml:"name"`
+	Genre      []string `xml:"genre"`
+	OriginInfo struct {
+		DateIssued []string `xml:"dateIssued"`
+		Publisher  string   `xml:"publisher"`
+	} `xml:"originInfo"`
+	Languages []string `xml:"language>languageTerm"`
+	Abstract  string   `xml:"abstract"`
+	Topics    []string `xml:"subject>topic"`
+	Related   []struct {
+		Type        string `xml:"type,attr"`
+		Title       string `xml:"titleInfo>title"`
+		Identifiers []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"identifier"`
+		Details []struct {
+			Type   string `xml:"type,attr"`
+			Number string `xml:"number"`
+		} `xml:"part>detail"`
+	} `xml:"relatedItem"`
+	Identifiers []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"identifier"`
+	URLs []string `xml:"location>url"`
+	ID   string   `xml:"ID,attr"`
+}
+
+// Map a MODS record onto a Publication. Names are kept when their role is
+// author or isn't given, so editors and other contributors are left out.
+func (m modsRecord) Publication() Publication {
+	pub := Publication{ID: m.ID, Abstract: strings.TrimSpace(m.Abstract), Publisher: strings.TrimSpace(m.OriginInfo.Publisher)}
+
+	for _, title := range m.TitleInfo {
+		if title.Type == "" {
+			pub.Title = strings.TrimSpace(strings.TrimSpace(title.NonSort) + " " + strings.TrimSpace(title.Title))
+			pub.Subtitle = strings.TrimSpace(title.SubTitle)
+			break
+		}
+	}
+
+	for _, name := range m.Names {
+		if !modsIsAuthor(name.RoleTerms) {
+			continue
+		}
+		var person Person
+		var unparsed []string
+		for _, part := range name.NameParts {
+			switch part.Type {
+			case "family":
+				person.PersonName.FamilyNames = strings.TrimSpace(part.Value)
+			case "given":
+				person.PersonName.FirstNames = strings.TrimSpace(strings.TrimSpace(person.PersonName.FirstNames) + " " + strings.TrimSpace(part.Value))
+			case "":
+				unparsed = append(unparsed, strings.TrimSpace(part.Value))
+			}
+		}
+		if person.PersonName.FamilyNames == "" {
+			full := strings.Join(unparsed, " ")
+			if name.Type == "corporate" {
+				person = Person{PersonName: PersonName{FamilyNames: full}, Organization: true}
+			} else {
+				family, given, _ := strings.Cut(full, ",")
+				person.PersonName = PersonName{FamilyNames: strings.TrimSpace(family), FirstNames: strings.TrimSpace(given)}
+			}
+		}
+		for _, id := range name.NameIdentifiers {
+			if strings.EqualFold(id.Type, "orcid") {
+				person.ORCID = strings.TrimSpace(id.Value)
+			}
+		}
+		author := Author{Person: person}
+		for _, affiliation := range name.Affiliations {
+			author.Affiliations = append(author.Affiliations, OrgUnit{Name: strings.TrimSpace(affiliation)})
+		}
+		pub.Authors.AuthorList = append(pub.Authors.AuthorList, author)
+	}
+
+	for _, date := range m.OriginInfo.DateIssued {
+		if date = strings.TrimSpace(date); date != "" {
+			pub.Date = date
+			break
+		}
+	}
+	if len(m.Languages) > 0 {
+		pub.Language = strings.TrimSpace(m.Languages[0])
+	}
+	pub.Keywords = m.Topics
+	if len(m.Genre) > 0 {
+		pub.Type = strings.TrimSpace(m.Genre[0])
+	}
+
+	// The journal is the host item
+	for _, related := range m.Related {
+		if related.Type != "host" {
+			continue
+		}
+		pub.Published.Publication.Title = strings.TrimSpace(related.Title)
+		for _, id := range related.Identifiers {
+			if strings.EqualFold(id.Type, "issn") && pub.ISSN == "" {
+				pub.ISSN = strings.TrimSpace(id.Value)
+			}
+		}
+		for _, detail := range related.Details {
+			switch detail.Type {
+			case "volume":
+				pub.Volume = strings.TrimSpace(detail.Number)
+			case "issue", "number":
+				pub.Issue = strings.TrimSpace(detail.Number)
+			}
+		}
+	}
+
+	for _, id := range m.Identifiers {
+		switch strings.ToLower(id.Type) {
+		case "doi":
+			pub.DOI = strings.TrimSpace(id.Value)
+		case "uri":
+			if pub.URL == "" {
+				pub.URL = strings.TrimSpace(id.Value)
+			}
+		case "arxiv":
+			pub.ArXiv = strings.TrimSpace(id.Value)
+		}
+	}
+	if len(m.URLs) > 0 {
+		pub.URL = strings.TrimSpace(m.URLs[0])
+	}
+	if pub.ID == "" {
+		pub.ID = pub.DOI
+	}
+	return pub
+}
+
+// Whether a MODS name with these role terms is an author: as text
+// ("author") or as a MARC relator code ("aut"), or with no role at all
+func modsIsAuthor(roleTerms []string) bool {
+	if len(roleTerms) == 0 {
+		return true
+	}
+	for _, role := range roleTerms {
+		switch strings.ToLower(strings.Trim(role, " .,")) {
+		case "author", "aut", "creator", "cre":
+			return true
+		}
+	}
+	return false
+}
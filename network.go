@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The clients of every remote service, which networkOptions configure, by
+// the provider name used with --retry
+var httpClients = map[string]*http.Client{
+	"crossref":      crossrefClient,
+	"harvest":       harvestClient,
+	"webhook":       notifyClient,
+	"opencitations": openCitationsClient,
+	"ror":           rorClient,
+	"scopus":        scopusClient,
+	"wos":           wosClient,
+	"openalex":      openAlexClient,
+}
+
+// How requests reach the network, for networks that route traffic through
+// a proxy, possibly one that intercepts TLS with its own certificate
+type networkOptions struct {
+	Proxy    string // proxy URL, overriding HTTPS_PROXY and HTTP_PROXY
+	CABundle string // PEM file of certificates to trust besides the system's
+	Insecure bool   // skip certificate verification
+	Retry    string // retry and circuit breaker settings, as for parseRetryPolicies
+	CacheDir string // directory to keep responses in, with a subdirectory per provider, or ""
+	Offline  bool   // make no requests, answering only from CacheDir
+}
+
+// Define the network flags of a command that makes requests
+func networkFlags(fs *flag.FlagSet) *networkOptions {
+	var o networkOptions
+	fs.StringVar(&o.Proxy, "proxy", "", "proxy to send requests through, e.g. http://proxy.example.edu:3128, instead of the one in HTTPS_PROXY")
+	fs.StringVar(&o.CABundle, "ca-bundle", "", "PEM file of CA certificates to trust besides the system's, e.g. that of a TLS-intercepting proxy")
+	fs.BoolVar(&o.Insecure, "insecure", false, "don't verify the TLS certificates of remote services (unsafe; prefer --ca-bundle)")
+	fs.StringVar(&o.Retry, "retry", "", "retry settings of remote providers (crossref, openalex, opencitations, ror, scopus, wos, harvest, webhook, or all) as comma-separated provider.setting=value items, e.g. crossref.attempts=5,all.breaker=10; settings are attempts, backoff, max-backoff, breaker and cooldown")
+	fs.StringVar(&o.CacheDir, "cache-dir", "", "keep the responses of remote services in this directory and reuse them in later runs")
+	fs.BoolVar(&o.Offline, "offline", false, "make no network requests, using only the responses in --cache-dir")
+	return &o
+}
+
+// Configure the clients of every remote service with the options, and
+// have them retry failed requests and use the cache when one is given.
+// Like the default transport, requests use the proxy in HTTPS_PROXY or
+// HTTP_PROXY unless --proxy is given.
+func (o networkOptions) apply() error {
+	policies, err := parseRetryPolicies(o.Retry)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		proxy := o.Proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid --proxy %q", o.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if o.CABundle != "" || o.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: o.Insecure}
+	}
+	if o.CABundle != "" {
+		pem, err := os.ReadFile(o.CABundle)
+		if err != nil {
+			return fmt.Errorf("error reading CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in CA bundle %s", o.CABundle)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if o.Insecure {
+		log.Printf("Warning: not verifying the TLS certificates of remote services")
+	}
+	for provider, client := range httpClients {
+		client.Transport = &retryTransport{provider: provider, base: transport, policy: policies[provider]}
+		if o.CacheDir != "" || o.Offline {
+			dir := ""
+			if o.CacheDir != "" {
+				dir = filepath.Join(o.CacheDir, provider)
+			}
+			client.Transport = &cacheTransport{dir: dir, offline: o.Offline, base: client.Transport}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Where `serve --refresh` sends the report of what changed in a harvest
+type notifyConfig struct {
+	Webhook string   // URL to POST a Slack/Teams-compatible message to, or ""
+	EmailTo []string // addresses to email the report to
+	SMTP    string   // host:port of the mail server
+	From    string
+	User    string // SMTP username, or "" for no authentication
+	Pass    string
+}
+
+// Client for webhook requests
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// Whether any notification is configured
+func (c notifyConfig) enabled() bool {
+	return c.Webhook != "" || len(c.EmailTo) > 0
+}
+
+// Send the report to the webhook and by email, as configured. Both are
+// tried even if one fails.
+func notify(cfg notifyConfig, subject, report string) error {
+	var errs []error
+	if cfg.Webhook != "" {
+		if err := postWebhook(cfg.Webhook, subject, report); err != nil {
+			errs = append(errs, fmt.Errorf("error posting to webhook: %v", err))
+		}
+	}
+	if len(cfg.EmailTo) > 0 {
+		if err := sendEmail(cfg, subject, report); err != nil {
+			errs = append(errs, fmt.Errorf("error sending email: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// POST the report as a message with a "text" field, which both Slack and
+// Microsoft Teams incoming webhooks accept
+func postWebhook(webhook, subject, report string) error {
+	body, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n```\n" + report + "```"})
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Email the report as plain text
+func sendEmail(cfg notifyConfig, subject, report string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.EmailTo, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(report, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if cfg.User != "" {
+		host, _, err := net.SplitHostPort(cfg.SMTP)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.User, cfg.Pass, host)
+	}
+	return smtp.SendMail(cfg.SMTP, auth, cfg.From, cfg.EmailTo, []byte(msg.String()))
+}
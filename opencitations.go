@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Base URL of the OpenCitations Index API
+var openCitationsURL = "https://api.opencitations.net/index/v2/"
+
+// Client for OpenCitations API requests
+var openCitationsClient = &http.Client{Timeout: 60 * time.Second}
+
+// A citation from one publication to another, by DOI name
+type citationEdge struct {
+	Citing string
+	Cited  string
+}
+
+// Fetch the citations OpenCitations knows of for a DOI: the works it cites
+// with kind "references", or the works citing it with kind "citations".
+// Returns the citing and cited DOI names of each; citations of works
+// without a DOI are left out.
+func fetchOpenCitations(kind, doi string) ([]citationEdge, error) {
+	req, err := http.NewRequest("GET", openCitationsURL+kind+"/doi:"+doiName(doi), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "impact-factor-lookup (https://github.com/kljensen/impact-factor-lookup)")
+	resp, err := openCitationsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var citations []struct {
+		Citing string `json:"citing"`
+		Cited  string `json:"cited"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&citations); err != nil {
+		return nil, fmt.Errorf("error parsing OpenCitations response: %v", err)
+	}
+	// Each end is a space-separated list of identifiers, like
+	// "omid:br/0612058700 doi:10.1038/nature12373 pmid:23903748"
+	doiOf := func(ids string) string {
+		for _, id := range strings.Fields(ids) {
+			if strings.HasPrefix(id, "doi:") {
+				return doiName(strings.TrimPrefix(id, "doi:"))
+			}
+		}
+		return ""
+	}
+	var edges []citationEdge
+	for _, citation := range citations {
+		edge := citationEdge{Citing: doiOf(citation.Citing), Cited: doiOf(citation.Cited)}
+		if edge.Citing != "" && edge.Cited != "" {
+			edges = append(edges, edge)
+		}
+	}
+	return edges, nil
+}
+
+// Look up the outgoing and incoming citations of the publications on
+// OpenCitations and return those between two of the publications, sorted.
+// Lookup failures are logged and leave out that publication's citations
+// in that direction.
+func buildCitationGraph(pubs []Publication) []citationEdge {
+	inSet := map[string]bool{}
+	for _, pub := range pubs {
+		if pub.DOI != "" {
+			inSet[doiName(pub.DOI)] = true
+		}
+	}
+
+	seen := map[citationEdge]bool{}
+	var edges []citationEdge
+	for doi := range inSet {
+		for _, kind := range []string{"references", "citations"} {
+			found, err := fetchOpenCitations(kind, doi)
+			if err != nil {
+				log.Printf("Warning: looking up the %s of %s: %v", kind, doi, err)
+				continue
+			}
+			for _, edge := range found {
+				if edge.Citing != edge.Cited && inSet[edge.Citing] && inSet[edge.Cited] && !seen[edge] {
+					seen[edge] = true
+					edges = append(edges, edge)
+				}
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Citing != edges[j].Citing {
+			return edges[i].Citing < edges[j].Citing
+		}
+		return edges[i].Cited < edges[j].Cited
+	})
+	return edges
+}
+
+// The publications with a DOI, once per DOI, which are the nodes of the
+// citation graph
+func citationGraphNodes(pubs []Publication) []Publication {
+	seen := map[string]bool{}
+	var nodes []Publication
+	for _, pub := range pubs {
+		if doi := doiName(pub.DOI); pub.DOI != "" && !seen[doi] {
+			seen[doi] = true
+			nodes = append(nodes, pub)
+		}
+	}
+	return nodes
+}
+
+// Write the citation graph in Graphviz DOT format. Nodes are DOIs,
+// labelled with the citation key; an edge points from the citing
+// publication to the cited one.
+func writeCitationGraphDOT(w io.Writer, pubs []Publication, edges []citationEdge) error {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	fmt.Fprintln(w, "digraph citations {")
+	for _, pub := range citationGraphNodes(pubs) {
+		fmt.Fprintf(w, "  %s [label=%s, tooltip=%s];\n", quote(doiName(pub.DOI)), quote(createCitationKey(pub)), quote(pub.Title))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(w, "  %s -> %s;\n", quote(edge.Citing), quote(edge.Cited))
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// Write the citation graph as GraphML, with the citation key, title, year
+// and DOI of each publication as node data
+func writeCitationGraphGraphML(w io.Writer, pubs []Publication, edges []citationEdge) error {
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	for _, key := range []string{"label", "title", "year", "doi"} {
+		fmt.Fprintf(w, "  <key id=\"%s\" for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n", key, key)
+	}
+	fmt.Fprintln(w, `  <graph id="citations" edgedefault="directed">`)
+	for _, pub := range citationGraphNodes(pubs) {
+		year := ""
+		if len(pub.Date) >= 4 {
+			year = pub.Date[:4]
+		}
+		fmt.Fprintf(w, "    <node id=\"%s\">\n", xmlEscape(doiName(pub.DOI)))
+		for _, data := range [][2]string{{"label", createCitationKey(pub)}, {"title", pub.Title}, {"year", year}, {"doi", pub.DOI}} {
+			if data[1] != "" {
+				fmt.Fprintf(w, "      <data key=\"%s\">%s</data>\n", data[0], xmlEscape(data[1]))
+			}
+		}
+		fmt.Fprintln(w, "    </node>")
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\"/>\n", xmlEscape(edge.Citing), xmlEscape(edge.Cited))
+	}
+	fmt.Fprintln(w, "  </graph>")
+	_, err := fmt.Fprintln(w, "</graphml>")
+	return err
+}
+
+// The `graph` subcommand: the citations between the publications in an
+// XML file, from OpenCitations, as a graph
+func runGraph(args []string) {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed XML records instead of aborting")
+	format := fs.String("format", "dot", "output format: dot or graphml")
+	repoProfile := fs.String("repo-profile", "", "repository platform whose metadata quirks to handle: dspace, eprints, or pure")
+	metadataFormat := fs.String("metadata-format", "auto", "metadata format of the paper records: auto, cerif, datacite, mods, marcxml, csv, or doi")
+	crossrefFlags(fs)
+	network := networkFlags(fs)
+	fs.Usage = func() {
+		log.Printf("Usage: %s graph [flags] <paper xml filename>", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "graph", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+
+	var write func(io.Writer, []Publication, []citationEdge) error
+	switch *format {
+	case "dot":
+		write = writeCitationGraphDOT
+	case "graphml":
+		write = writeCitationGraphGraphML
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if err := applyRepoProfileFlags(fs, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if !metadataFormats[*metadataFormat] {
+		log.Printf("Unknown metadata format %q", *metadataFormat)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	xmlFile, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatalf(exitError, "Error reading file: %v", err)
+	}
+	defer xmlFile.Close()
+	pubs, skipped, err := ReadPublications(xmlFile, *metadataFormat, *lenient)
+	if err != nil {
+		fatalf(exitParse, "Error parsing XML: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed XML records", skipped)
+	}
+	if profile, ok := repoProfiles[*repoProfile]; ok {
+		applyRepoProfile(pubs, profile)
+	}
+
+	assignCitationKeys(pubs)
+	if err := write(os.Stdout, pubs, buildCitationGraph(pubs)); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
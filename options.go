@@ -0,0 +1,75 @@
+package main
+
+import "strings"
+
+// Options for ReadMetricsCSV and the readers of the other metrics sources,
+// set with MetricsOptions. The zero value reads strictly, finds the CSV's
+// columns by their usual headings and keeps the most recent record for
+// each ISSN.
+type MetricsReadOptions struct {
+	Lenient bool // skip malformed rows with a warning instead of failing
+	Dedup   DedupStrategy
+	Year    int64 // for DedupPreferYear
+
+	// Headings of the CSV mapped to the ones ReadMetricsCSV looks for,
+	// by lowercase heading
+	ColumnMap map[string]string
+}
+
+// An option for reading metrics, e.g. WithLenient()
+type MetricsOption func(*MetricsReadOptions)
+
+// Skip malformed rows with a warning instead of failing
+func WithLenient() MetricsOption {
+	return func(o *MetricsReadOptions) {
+		o.Lenient = true
+	}
+}
+
+// Have ISSNs find the journals' records for the given year, where they
+// have one
+func WithYear(year int64) MetricsOption {
+	return func(o *MetricsReadOptions) {
+		o.Dedup = DedupPreferYear
+		o.Year = year
+	}
+}
+
+// Pick the record each ISSN finds with the given strategy. For
+// DedupPreferYear, use WithYear instead.
+func WithDedup(strategy DedupStrategy) MetricsOption {
+	return func(o *MetricsReadOptions) {
+		o.Dedup = strategy
+	}
+}
+
+// Read a CSV whose headings differ from the usual ones, mapping each of
+// its headings to the one ReadMetricsCSV looks for, e.g. {"Journal":
+// "Title", "Rank SJR": "SJR"}. Headings are matched ignoring case.
+func WithColumnMap(columns map[string]string) MetricsOption {
+	return func(o *MetricsReadOptions) {
+		if o.ColumnMap == nil {
+			o.ColumnMap = map[string]string{}
+		}
+		for heading, name := range columns {
+			o.ColumnMap[strings.ToLower(strings.TrimSpace(heading))] = name
+		}
+	}
+}
+
+// Apply options to the defaults
+func newMetricsReadOptions(options []MetricsOption) MetricsReadOptions {
+	var o MetricsReadOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+// The options for a subcommand's --lenient flag
+func lenientOptions(lenient bool) []MetricsOption {
+	if lenient {
+		return []MetricsOption{WithLenient()}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The path of the Markdown file written next to the -o file by --format
+// pandoc: the same name with a .md extension
+func pandocCitationsPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".md"
+}
+
+// The listed authors of a publication as in its BibTeX entry, separated by
+// semicolons, with the authors self matches in bold
+func pandocAuthors(pub Publication, self selfMatcher, opts bibtexOptions) string {
+	listed, cut := listedAuthors(pub.Authors.AuthorList, opts)
+	var names []string
+	for _, author := range listed {
+		name := formatAuthorName(author, opts)
+		if self.matches(author) {
+			name = "**" + name + "**"
+		}
+		names = append(names, name)
+	}
+	if cut {
+		names = append(names, "et al.")
+	}
+	return strings.Join(names, "; ")
+}
+
+// Write the citation keys of pubs as a Markdown list of pandoc citations,
+// one section per publication year, newest first. Within a year the
+// publications keep their order in pubs. Publications that aren't out yet
+// come first, under their status, such as "In press", and publications
+// without a date come last, under "Undated". With opts.Self, each
+// citation is followed by its authors, with self in bold, as in
+// "@Jensen2021 (**Jensen, Kyle**; Smith, Jane)".
+func writePandocCitations(w io.Writer, pubs []Publication, opts bibtexOptions) error {
+	var self selfMatcher
+	if opts.Self != "" {
+		self = parseSelf(opts.Self)
+	}
+	var years []string
+	items := map[string][]string{}
+	for _, pub := range pubs {
+		year := forthcomingLabel(pub)
+		switch {
+		case year != "":
+		case len(pub.Date) >= 4:
+			year = pub.Date[:4]
+		default:
+			year = "Undated"
+		}
+		if _, ok := items[year]; !ok {
+			years = append(years, year)
+		}
+		item := "@" + createCitationKey(pub)
+		if opts.Self != "" && len(namedAuthors(pub.Authors.AuthorList)) > 0 {
+			item += " (" + pandocAuthors(pub, self, opts) + ")"
+		}
+		items[year] = append(items[year], item)
+	}
+	sort.Slice(years, func(i, j int) bool {
+		if (years[i] == "Undated") != (years[j] == "Undated") {
+			return years[j] == "Undated"
+		}
+		// Status labels start with a letter, so sort above the years
+		return years[i] > years[j]
+	})
+
+	for i, year := range years {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "## %s\n\n", year)
+		for _, item := range items[year] {
+			if _, err := fmt.Fprintf(w, "- %s\n", item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
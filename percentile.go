@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+)
+
+// Identifies the journals of one ASJC field in one year
+type fieldYear struct {
+	field int64
+	year  int64
+}
+
+// The SJR, h-index and average citation values of all journals in one
+// field and year
+type fieldDistribution struct {
+	sjr          []float64
+	hIndex       []float64
+	avgCitations []float64
+	sorted       bool
+}
+
+// Record a CSV row's values in the distribution for each of its fields in
+// its year
+func (db *MetricsDatabase) addToFieldDistribution(metrics JournalMetrics) {
+	for _, field := range metrics.Fields {
+		key := fieldYear{field.Code, metrics.Year}
+		dist, ok := db.fields[key]
+		if !ok {
+			dist = &fieldDistribution{}
+			db.fields[key] = dist
+		}
+		if metrics.SJR != nil {
+			dist.sjr = append(dist.sjr, *metrics.SJR)
+		}
+		dist.hIndex = append(dist.hIndex, float64(metrics.HIndex))
+		if metrics.AvgCitations != nil {
+			dist.avgCitations = append(dist.avgCitations, *metrics.AvgCitations)
+		}
+		dist.sorted = false
+	}
+}
+
+// The percentage of values in the sorted slice that are less than or equal
+// to v, so the top journal in a field is at the 100th percentile
+func percentileRank(sorted []float64, v float64) float64 {
+	if len(sorted) == 0 {
+		return -1
+	}
+	// Index of the first value greater than v
+	n := sort.Search(len(sorted), func(i int) bool { return sorted[i] > v })
+	return 100 * float64(n) / float64(len(sorted))
+}
+
+// The quartile for an SJR percentile: 1 for the top 25%, down to 4
+func quartile(percentile float64) int {
+	switch {
+	case percentile < 0:
+		return 0
+	case percentile > 75:
+		return 1
+	case percentile > 50:
+		return 2
+	case percentile > 25:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Whether optional value a is greater than b, where an unknown (nil) value
+// is less than any known one
+func greater(a, b *float64) bool {
+	return a != nil && (b == nil || *a > *b)
+}
+
+// The median of a sorted slice, or -1 when it is empty
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	switch {
+	case n == 0:
+		return -1
+	case n%2 == 1:
+		return sorted[n/2]
+	default:
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+}
+
+// Fill in the quartile, SJR and h-index percentiles of every journal in
+// each of its fields, ranked against the other journals in the same field
+// and year, and its average citations relative to the field median. The
+// journal-level values are taken from its best field. ReadMetricsCSV calls
+// this once all rows are loaded; call it again after adding records with
+// Add.
+func (db *MetricsDatabase) RankWithinFields() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, dist := range db.fields {
+		if !dist.sorted {
+			sort.Float64s(dist.sjr)
+			sort.Float64s(dist.hIndex)
+			sort.Float64s(dist.avgCitations)
+			dist.sorted = true
+		}
+	}
+
+	rank := func(m JournalMetrics) JournalMetrics {
+		fields := make([]SubjectField, 0, len(m.Fields))
+		best := -1
+		for _, f := range m.Fields {
+			f = newSubjectField(f.Code)
+			if dist := db.fields[fieldYear{f.Code, m.Year}]; dist != nil {
+				if m.SJR != nil {
+					pct := percentileRank(dist.sjr, *m.SJR)
+					f.SJRPercentile = &pct
+					f.Quartile = quartile(pct)
+				}
+				pct := percentileRank(dist.hIndex, float64(m.HIndex))
+				f.HIndexPercentile = &pct
+				if med := median(dist.avgCitations); m.AvgCitations != nil && med > 0 {
+					f.FieldNormalizedCitations = optionalFloat(*m.AvgCitations / med)
+				}
+			}
+			if best < 0 || greater(f.SJRPercentile, fields[best].SJRPercentile) {
+				best = len(fields)
+			}
+			fields = append(fields, f)
+		}
+		m.Fields = fields
+		if best >= 0 {
+			m.Quartile = fields[best].Quartile
+			m.SJRPercentile = fields[best].SJRPercentile
+			m.HIndexPercentile = fields[best].HIndexPercentile
+			m.FieldNormalizedCitations = fields[best].FieldNormalizedCitations
+		}
+		return m
+	}
+	for i, m := range db.journals {
+		db.journals[i] = rank(m)
+	}
+}
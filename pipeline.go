@@ -0,0 +1,76 @@
+package main
+
+import "sync"
+
+// A publication rendered by renderEntries
+type renderedEntry struct {
+	Pub   Publication
+	Entry string
+	Err   error // the error rendering the entry, if any
+	Found bool  // whether the publication's journal has metrics
+
+	// Whether the publication is an unpublished preprint, whose metrics
+	// weren't looked up
+	Preprint bool
+}
+
+// Look up the journal metrics of each publication, for the year picked by
+// opts.MetricsYear, and render it with
+// render, spreading the work over the given number of goroutines. emit is
+// called from the calling goroutine once per publication, in the order of
+// pubs, so output stays identical to a sequential run. At most a few
+// entries per worker are buffered ahead of emit.
+func renderEntries(pubs []Publication, db *MetricsDatabase, render func(Publication, *JournalMetrics, bibtexOptions) (string, error), opts bibtexOptions, workers int, emit func(renderedEntry)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each job carries the channel its result is delivered on. The same
+	// channels are queued in publication order for the assembler below,
+	// which is what keeps the output ordered.
+	type job struct {
+		pub    Publication
+		result chan renderedEntry
+	}
+	jobs := make(chan job, workers)
+	ordered := make(chan chan renderedEntry, 4*workers)
+
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		for _, pub := range pubs {
+			result := make(chan renderedEntry, 1)
+			ordered <- result
+			jobs <- job{pub: pub, result: result}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rendered := renderedEntry{Pub: j.pub}
+				if isUnpublishedPreprint(j.pub) {
+					rendered.Entry, rendered.Err = render(j.pub, nil, opts)
+					rendered.Preprint = true
+				} else if metrics, ok := db.lookupForYear(j.pub, opts.MetricsYear); ok {
+					match := db.describeMatch(j.pub, metrics, opts.MetricsYear)
+					rendered.Pub.Match = &match
+					rendered.Pub.Trend = db.SJRTrend(metrics.SourceID)
+					rendered.Entry, rendered.Err = render(rendered.Pub, &metrics, opts)
+					rendered.Found = true
+				} else {
+					rendered.Entry, rendered.Err = render(j.pub, nil, opts)
+				}
+				j.result <- rendered
+			}
+		}()
+	}
+
+	for result := range ordered {
+		emit(<-result)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,69 @@
+package main
+
+import "log"
+
+// How --link-preprints treats preprints with a published journal version
+var linkPreprintModes = map[string]bool{
+	"off":      true, // don't look for published versions
+	"annotate": true, // keep the preprint, with a note linking the published version
+	"replace":  true, // drop the preprint when its published version is also listed
+}
+
+// Look up the DOI of the published version of a preprint in the relation
+// metadata Crossref holds for the preprint's DOI. Returns "" if Crossref
+// knows of none.
+func fetchPublishedVersion(doi string) (string, error) {
+	work, err := fetchCrossrefWork(doi)
+	if err != nil || work == nil {
+		return "", err
+	}
+	for _, related := range work.Relation["is-preprint-of"] {
+		if related.IDType == "doi" && related.ID != "" {
+			return related.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// Find the published versions of the unpublished preprints in pubs, from
+// their metadata or else from Crossref, and record them in
+// PublishedVersion. In replace mode, preprints whose published version is
+// also in pubs are then dropped, so each work is listed once. Lookup
+// failures are logged and leave the preprint as it is.
+func linkPreprints(pubs []Publication, mode string) []Publication {
+	for i := range pubs {
+		pub := &pubs[i]
+		if !isUnpublishedPreprint(*pub) || pub.PublishedVersion != "" || pub.DOI == "" {
+			continue
+		}
+		published, err := fetchPublishedVersion(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up the published version of %s: %v", pub.DOI, err)
+			continue
+		}
+		pub.PublishedVersion = published
+	}
+	if mode != "replace" {
+		return pubs
+	}
+
+	listed := map[string]bool{}
+	for _, pub := range pubs {
+		if pub.DOI != "" {
+			listed[doiName(pub.DOI)] = true
+		}
+	}
+	kept := pubs[:0]
+	replaced := 0
+	for _, pub := range pubs {
+		if pub.PublishedVersion != "" && listed[doiName(pub.PublishedVersion)] {
+			replaced++
+			continue
+		}
+		kept = append(kept, pub)
+	}
+	if replaced > 0 {
+		log.Printf("Dropped %d preprints whose published version is also listed", replaced)
+	}
+	return kept
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Settings and quirks of a repository platform's OAI-PMH output
+type repoProfile struct {
+	MetadataPrefix string // metadata format to harvest
+	Set            string // set holding the publications, where the platform has a standard one
+	MetadataFormat string // one of metadataFormats
+
+	// Layouts the platform writes dates in besides the ISO 8601 ones,
+	// which are converted to 2006-01-02
+	DateLayouts []string
+
+	// Whether DOIs often only appear as doi.org links in the URL fields
+	DOIFromURL bool
+}
+
+// Presets for --repo-profile, by platform
+var repoProfiles = map[string]repoProfile{
+	// Elsevier Pure exposes the OpenAIRE CERIF profile
+	"pure": {
+		MetadataPrefix: "oai_cerif_openaire",
+		Set:            "openaire_cris_publications",
+		MetadataFormat: "cerif",
+	},
+	// DSpace (XOAI) serves MODS with full timestamps as issue dates and
+	// DOIs as identifier URIs
+	"dspace": {
+		MetadataPrefix: "mods",
+		MetadataFormat: "mods",
+		DateLayouts:    []string{time.RFC3339, "2006-01-02T15:04:05Z"},
+		DOIFromURL:     true,
+	},
+	// EPrints wraps MODS in METS, with dates as written in the record
+	// and the publisher's DOI link as the official URL
+	"eprints": {
+		MetadataPrefix: "mets",
+		MetadataFormat: "mods",
+		DateLayouts:    []string{"2006-01-02 15:04:05", "2 January 2006", "January 2006"},
+		DOIFromURL:     true,
+	},
+}
+
+// Fill in the flags a repository profile presets (metadata-format,
+// metadata-prefix and harvest-set, where fs has them) unless they were
+// given on the command line, in the environment or in the config file.
+// name may be empty for no profile.
+func applyRepoProfileFlags(fs *flag.FlagSet, name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := repoProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown repository profile %q", name)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for flagName, value := range map[string]string{
+		"metadata-format": profile.MetadataFormat,
+		"metadata-prefix": profile.MetadataPrefix,
+		"harvest-set":     profile.Set,
+	} {
+		if fs.Lookup(flagName) != nil && !set[flagName] && value != "" {
+			if err := fs.Set(flagName, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Smooth over the platform's quirks in the publications read from its
+// records: convert dates to ISO 8601, and take missing DOIs from doi.org
+// links
+func applyRepoProfile(pubs []Publication, profile repoProfile) {
+	for i := range pubs {
+		pub := &pubs[i]
+		pub.Date = normalizeDate(pub.Date, profile.DateLayouts)
+		if profile.DOIFromURL && pub.DOI == "" {
+			pub.DOI = doiFromURL(pub.URL)
+		}
+	}
+}
+
+// Convert a date in one of the layouts to 2006-01-02, or 2006-01 for
+// layouts without a day. Dates already in ISO 8601 or in no known layout
+// are returned unchanged.
+func normalizeDate(date string, layouts []string) string {
+	date = strings.TrimSpace(date)
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, date)
+		if err != nil {
+			continue
+		}
+		if hasDay := strings.Contains(strings.ReplaceAll(layout, "2006", ""), "2"); hasDay {
+			return t.Format("2006-01-02")
+		}
+		return t.Format("2006-01")
+	}
+	return date
+}
+
+// The DOI in a doi.org link, or ""
+func doiFromURL(link string) string {
+	link = strings.TrimSpace(link)
+	for _, prefix := range doiPrefixes {
+		if len(link) > len(prefix) && strings.EqualFold(link[:len(prefix)], prefix) {
+			return link[len(prefix):]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds, in seconds, of the request latency histogram buckets. These
+// are the Prometheus client defaults.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Labels identifying a counted HTTP request
+type requestKey struct {
+	handler string
+	method  string
+	code    int
+}
+
+// A cumulative latency histogram in the Prometheus sense
+type histogram struct {
+	counts []uint64 // one per bucket, plus +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// Counters and histograms exported on /metrics in the Prometheus text format
+type serverStats struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[string]*histogram
+
+	lookupHits   atomic.Uint64
+	lookupMisses atomic.Uint64
+	loadedAt     atomic.Int64 // unix seconds of the last database load
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[string]*histogram),
+	}
+}
+
+// Record a finished request
+func (s *serverStats) observeRequest(handler, method string, code int, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[requestKey{handler, method, code}]++
+	h, ok := s.latencies[handler]
+	if !ok {
+		h = &histogram{}
+		s.latencies[handler] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// Count the hits and misses in a batch of lookup results
+func (s *serverStats) observeLookups(results []LookupResult) {
+	for _, result := range results {
+		if result.Found {
+			s.lookupHits.Add(1)
+		} else {
+			s.lookupMisses.Add(1)
+		}
+	}
+}
+
+// Write all metrics in the Prometheus text exposition format
+func (s *serverStats) writeTo(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP impact_factor_lookup_http_requests_total HTTP requests by handler, method and status code.")
+	fmt.Fprintln(w, "# TYPE impact_factor_lookup_http_requests_total counter")
+	keys := make([]requestKey, 0, len(s.requests))
+	for key := range s.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "impact_factor_lookup_http_requests_total{handler=%q,method=%q,code=\"%d\"} %d\n",
+			key.handler, key.method, key.code, s.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP impact_factor_lookup_http_request_duration_seconds HTTP request latency by handler.")
+	fmt.Fprintln(w, "# TYPE impact_factor_lookup_http_request_duration_seconds histogram")
+	handlers := make([]string, 0, len(s.latencies))
+	for handler := range s.latencies {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	for _, handler := range handlers {
+		h := s.latencies[handler]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "impact_factor_lookup_http_request_duration_seconds_bucket{handler=%q,le=%q} %d\n",
+				handler, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "impact_factor_lookup_http_request_duration_seconds_bucket{handler=%q,le=\"+Inf\"} %d\n", handler, h.count)
+		fmt.Fprintf(w, "impact_factor_lookup_http_request_duration_seconds_sum{handler=%q} %g\n", handler, h.sum)
+		fmt.Fprintf(w, "impact_factor_lookup_http_request_duration_seconds_count{handler=%q} %d\n", handler, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP impact_factor_lookup_lookups_total Journal lookups by result.")
+	fmt.Fprintln(w, "# TYPE impact_factor_lookup_lookups_total counter")
+	fmt.Fprintf(w, "impact_factor_lookup_lookups_total{result=\"hit\"} %d\n", s.lookupHits.Load())
+	fmt.Fprintf(w, "impact_factor_lookup_lookups_total{result=\"miss\"} %d\n", s.lookupMisses.Load())
+
+	fmt.Fprintln(w, "# HELP impact_factor_lookup_database_loaded_timestamp_seconds Unix time the metrics database was last loaded.")
+	fmt.Fprintln(w, "# TYPE impact_factor_lookup_database_loaded_timestamp_seconds gauge")
+	fmt.Fprintf(w, "impact_factor_lookup_database_loaded_timestamp_seconds %d\n", s.loadedAt.Load())
+}
+
+// Records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Wrap a handler so its requests are counted and timed under the given name
+func (s *serverStats) instrument(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(recorder, r)
+		s.observeRequest(name, r.Method, recorder.code, time.Since(start))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"strconv"
+)
+
+// How a publication's journal was matched to its metrics record
+const (
+	matchISSN       = "issn"        // the publication's ISSN is listed by the record
+	matchISSNL      = "issn-l"      // another ISSN with the same ISSN-L is, see --issnl-file
+	matchFormerISSN = "former-issn" // the ISSN is one the journal had before it was renamed
+)
+
+// How a publication's journal metrics were found and where they came
+// from, as recorded in --format json output and the --audit report
+type MetricsMatch struct {
+	Method   string `json:"method"` // matchISSN, matchISSNL or matchFormerISSN
+	ISSN     string `json:"issn"`   // the publication's ISSN the journal was found by
+	Journal  string `json:"journal"`
+	Source   string `json:"source"` // "csv" for the impact factor csv, or the provider that found the journal
+	SourceID int64  `json:"source_id"`
+	Year     int64  `json:"year"` // the year of the metrics
+
+	// The year --metrics-year asked for, when it differs from Year
+	// because the journal has no metrics for it
+	RequestedYear int64 `json:"requested_year,omitempty"`
+}
+
+// Note that a journal was found by a provider other than the impact
+// factor csv, for MetricsMatch
+func (db *MetricsDatabase) setOrigin(sourceID int64, provider string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.origins[sourceID] = provider
+}
+
+// Describe how lookupForYear found metrics, the record it returned for
+// pub under a --metrics-year policy
+func (db *MetricsDatabase) describeMatch(pub Publication, metrics JournalMetrics, policy string) MetricsMatch {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	match := MetricsMatch{
+		Method:   matchISSN,
+		ISSN:     formatISSN(normalizeISSN(pub.ISSN)),
+		Journal:  metrics.Title,
+		Source:   "csv",
+		SourceID: metrics.SourceID,
+		Year:     metrics.Year,
+	}
+	if origin, ok := db.origins[metrics.SourceID]; ok {
+		match.Source = origin
+	}
+	key, _ := makeISSNKey(pub.ISSN)
+	if index, ok := db.byISSN[key]; !ok {
+		match.Method = matchISSNL
+	} else if _, rename := db.current(index); rename != nil {
+		match.Method = matchFormerISSN
+	}
+
+	var requested int64
+	switch policy {
+	case "", metricsYearLatest:
+	case metricsYearPublication:
+		if year, ok := publicationYear(pub); ok {
+			requested = int64(year)
+		}
+	default:
+		requested, _ = strconv.ParseInt(policy, 10, 64)
+	}
+	if requested != 0 && requested != metrics.Year {
+		match.RequestedYear = requested
+	}
+	return match
+}
+
+// The header of the --audit report
+var auditHeader = []string{"id", "title", "journal", "issn", "found", "method", "matched_journal", "source", "source_id", "metrics_year", "requested_year"}
+
+// Write the --audit row of a rendered publication
+func writeAuditRow(w *csv.Writer, r renderedEntry) error {
+	pub := r.Pub
+	row := []string{pub.ID, pub.Title, pub.Published.Publication.Title, pub.ISSN, strconv.FormatBool(r.Found), "", "", "", "", "", ""}
+	if r.Preprint {
+		row[5] = "preprint"
+	}
+	if m := pub.Match; m != nil {
+		row[5] = m.Method
+		row[6] = m.Journal
+		row[7] = m.Source
+		row[8] = strconv.FormatInt(m.SourceID, 10)
+		row[9] = strconv.FormatInt(m.Year, 10)
+		if m.RequestedYear != 0 {
+			row[10] = strconv.FormatInt(m.RequestedYear, 10)
+		}
+	}
+	return w.Write(row)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Whether the input is a CSV or TSV file rather than XML or JSON, going by
+// its first character after any byte order mark. Expects leading
+// whitespace to have been skipped, as isJSON does.
+func isDelimited(r *bufio.Reader) bool {
+	b, _ := r.Peek(4)
+	b = bytes.TrimPrefix(b, []byte("\ufeff"))
+	return len(b) > 0 && b[0] != '<' && b[0] != '{' && b[0] != '['
+}
+
+// The delimiter of a CSV header line: tab or semicolon when the line has
+// those, as spreadsheets export them, and comma otherwise
+func sniffDelimiter(header string) rune {
+	switch {
+	case strings.Contains(header, "\t"):
+		return '\t'
+	case strings.Count(header, ";") > strings.Count(header, ","):
+		return ';'
+	}
+	return ','
+}
+
+// The Publication fields that publication list columns fill in, by their
+// lowercased headings
+var publicationColumns = map[string]func(pub *Publication, value string){
+	"id":       func(pub *Publication, v string) { pub.ID = v },
+	"title":    func(pub *Publication, v string) { pub.Title = v },
+	"subtitle": func(pub *Publication, v string) { pub.Subtitle = v },
+	"authors":  func(pub *Publication, v string) { pub.Authors.AuthorList = parseAuthorList(v) },
+	"author":   func(pub *Publication, v string) { pub.Authors.AuthorList = parseAuthorList(v) },
+	"year":     func(pub *Publication, v string) { pub.Date = v },
+	"date":     func(pub *Publication, v string) { pub.Date = v },
+	"doi":      func(pub *Publication, v string) { pub.DOI = strings.TrimPrefix(doiURL(v), "https://doi.org/") },
+	"issn":     func(pub *Publication, v string) { pub.ISSN = v },
+	"journal":  func(pub *Publication, v string) { pub.Published.Publication.Title = v },
+	"source":   func(pub *Publication, v string) { pub.Published.Publication.Title = v },
+	"volume":   func(pub *Publication, v string) { pub.Volume = v },
+	"issue":    func(pub *Publication, v string) { pub.Issue = v },
+	"url":      func(pub *Publication, v string) { pub.URL = v },
+	"type":     func(pub *Publication, v string) { pub.Type = v },
+	"language": func(pub *Publication, v string) { pub.Language = v },
+	"abstract": func(pub *Publication, v string) { pub.Abstract = v },
+	"keywords": func(pub *Publication, v string) { pub.Keywords = parseKeywordList(v) },
+}
+
+// Parse a list of keywords separated by semicolons, dropping blanks
+func parseKeywordList(list string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(list, ";") {
+		keyword = strings.Join(strings.Fields(keyword), " ")
+		if keyword == "" {
+			continue
+		}
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+// Parse a list of authors separated by semicolons, or by " and " as in
+// BibTeX. Names are "Family, Given" or "Given Family".
+func parseAuthorList(list string) []Author {
+	separator := ";"
+	if !strings.Contains(list, ";") {
+		separator = " and "
+	}
+	var authors []Author
+	for _, name := range strings.Split(list, separator) {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" {
+			continue
+		}
+		authors = append(authors, Author{Person: Person{PersonName: parsePersonName(name)}})
+	}
+	return authors
+}
+
+// Split a name given as "Family, Given" or "Given Family" into its parts
+func parsePersonName(name string) PersonName {
+	family, given, found := strings.Cut(name, ",")
+	if !found {
+		if i := strings.LastIndex(name, " "); i >= 0 {
+			given, family = name[:i], name[i+1:]
+		}
+	}
+	return PersonName{FamilyNames: strings.TrimSpace(family), FirstNames: strings.TrimSpace(given)}
+}
+
+// Read publications from a CSV or TSV publication list, as exported from
+// a spreadsheet: a header row naming the columns, such as title, authors,
+// year, journal, doi and issn, then one publication per row. Unknown
+// columns are ignored. Rows without an id column value get their row
+// number. When lenient is true, malformed rows are skipped with a warning
+// and counted, as are malformed XML records.
+func readPublicationsCSV(r *bufio.Reader, lenient bool) ([]Publication, int, error) {
+	header, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	header = strings.TrimPrefix(header, "\ufeff")
+	reader := csv.NewReader(io.MultiReader(strings.NewReader(header), r))
+	reader.Comma = sniffDelimiter(header)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = lenient
+
+	headings, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading publication list header: %v", err)
+	}
+	columns := make([]func(*Publication, string), len(headings))
+	known := false
+	for i, heading := range headings {
+		columns[i] = publicationColumns[strings.ToLower(strings.TrimSpace(heading))]
+		known = known || columns[i] != nil
+	}
+	if !known {
+		return nil, 0, fmt.Errorf("publication list has none of the columns title, authors, year, journal, doi or issn")
+	}
+
+	var pubs []Publication
+	skipped := 0
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !lenient {
+				return nil, skipped, fmt.Errorf("error parsing publication list row %d: %v", row, err)
+			}
+			log.Printf("Warning: skipping publication list row %d: %v", row, err)
+			skipped++
+			continue
+		}
+		pub := Publication{ID: fmt.Sprintf("row%d", row)}
+		empty := true
+		for i, value := range record {
+			if value = strings.TrimSpace(value); i < len(columns) && columns[i] != nil && value != "" {
+				columns[i](&pub, value)
+				empty = false
+			}
+		}
+		if !empty {
+			pubs = append(pubs, pub)
+		}
+	}
+	return pubs, skipped, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Whether a publisher or country name matches one of the comma-separated
+// names in list, as whole words ignoring case and punctuation, so
+// "Elsevier" matches both "Elsevier B.V." and "Elsevier Ltd"
+func matchesNameList(name, list string) bool {
+	name = " " + normalizeTitle(name) + " "
+	for _, want := range strings.Split(list, ",") {
+		if want = normalizeTitle(want); want != "" && strings.Contains(name, " "+want+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Keep the publications whose journal is from one of the publishers and
+// countries, when those are given, and not from one of the excluded
+// publishers. Publications whose journal has no publisher or country in
+// the metrics only pass the exclusion.
+func filterPublishers(pubs []Publication, db *MetricsDatabase, publishers, excludePublishers, countries string) []Publication {
+	var out []Publication
+	for _, pub := range pubs {
+		metrics, _ := db.LookupISSN(pub.ISSN)
+		if publishers != "" && !matchesNameList(metrics.Publisher, publishers) {
+			continue
+		}
+		if excludePublishers != "" && metrics.Publisher != "" && matchesNameList(metrics.Publisher, excludePublishers) {
+			continue
+		}
+		if countries != "" && !matchesNameList(metrics.Country, countries) {
+			continue
+		}
+		out = append(out, pub)
+	}
+	return out
+}
+
+// Keep the publications in journals whose open access status is one of
+// the comma-separated statuses ("yes", "diamond", "no"). Diamond journals
+// count as open access, so "yes" keeps them too.
+func filterOpenAccess(pubs []Publication, db *MetricsDatabase, statuses string) []Publication {
+	want := map[string]bool{}
+	for _, status := range strings.Split(statuses, ",") {
+		want[strings.ToLower(strings.TrimSpace(status))] = true
+	}
+	if want["yes"] {
+		want["diamond"] = true
+	}
+	var out []Publication
+	for _, pub := range pubs {
+		if metrics, ok := db.LookupISSN(pub.ISSN); ok && want[metrics.OpenAccessStatus()] {
+			out = append(out, pub)
+		}
+	}
+	return out
+}
+
+// How many of a set of publications appeared in the journals of one
+// publisher
+type PublisherCount struct {
+	Publisher    string `json:"publisher"`
+	Publications int    `json:"publications"`
+}
+
+// Count the publications of each journal publisher, most frequent first,
+// with the Gini coefficient of the counts. Publications whose journal has
+// no publisher in the metrics aren't counted.
+func countPublishers(pubs []Publication, db *MetricsDatabase) ([]PublisherCount, float64) {
+	counts := map[string]int{}
+	for _, pub := range pubs {
+		if metrics, ok := db.LookupISSN(pub.ISSN); ok && metrics.Publisher != "" {
+			counts[metrics.Publisher]++
+		}
+	}
+	publishers := make([]PublisherCount, 0, len(counts))
+	frequencies := make([]int, 0, len(counts))
+	for publisher, n := range counts {
+		publishers = append(publishers, PublisherCount{Publisher: publisher, Publications: n})
+		frequencies = append(frequencies, n)
+	}
+	sort.Slice(publishers, func(i, j int) bool {
+		if publishers[i].Publications != publishers[j].Publications {
+			return publishers[i].Publications > publishers[j].Publications
+		}
+		return publishers[i].Publisher < publishers[j].Publisher
+	})
+	return publishers, gini(frequencies)
+}
+
+// Write the publisher frequencies as aligned text
+func writePublisherCountsText(w io.Writer, publishers []PublisherCount, giniCoefficient float64) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Unique publishers:\t%d\n", len(publishers))
+	fmt.Fprintf(tw, "Publisher Gini coefficient:\t%.3f\n", giniCoefficient)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Publisher\tPublications")
+	for _, publisher := range publishers {
+		fmt.Fprintf(tw, "%s\t%d\n", publisher.Publisher, publisher.Publications)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "slices"
+
+// The title and ISSNs a journal had before it was renamed or merged into
+// another, as listed under the same SCImago source ID in earlier years
+type JournalRename struct {
+	Title    string   `json:"title"`
+	ISSNs    []string `json:"issns"`
+	LastYear int64    `json:"last_year"` // the last year listed under this title and these ISSNs
+}
+
+// The journal's most recent record, for a record that may be from an
+// earlier year, when the journal had another title or ISSNs then; these
+// are returned too. Records under the current title and ISSNs are kept,
+// as the DedupStrategy may have picked them.
+func (db *MetricsDatabase) current(index int32) (int32, *JournalRename) {
+	old := db.journals[index]
+	latest, ok := db.bySourceID[old.SourceID]
+	if !ok || latest == index {
+		return index, nil
+	}
+	m := db.journals[latest]
+	renamed := normalizeTitle(old.Title) != normalizeTitle(m.Title)
+	for _, issn := range old.ISSNs {
+		if !slices.Contains(m.ISSNs, issn) {
+			renamed = true
+		}
+	}
+	if !renamed {
+		return index, nil
+	}
+	return latest, &JournalRename{Title: old.Title, ISSNs: old.ISSNs, LastYear: old.Year}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Where an author appears in a publication's author list
+type authorPosition string
+
+const (
+	positionSole   authorPosition = "sole"
+	positionFirst  authorPosition = "first"
+	positionMiddle authorPosition = "middle"
+	positionLast   authorPosition = "last"
+)
+
+// Identifies "self" in a set of publications, by ORCID iD or by family name
+// and the initials of the given names
+type selfMatcher struct {
+	orcid    string
+	family   string
+	initials string
+}
+
+// Parse a --self value: an ORCID iD, or a name like "Jensen, K" or
+// "Jensen, Kyle L."
+func parseSelf(self string) selfMatcher {
+	if orcid := normalizeORCID(self); orcid != "" {
+		return selfMatcher{orcid: orcid}
+	}
+	family, given, _ := strings.Cut(self, ",")
+	family, given = normalizePersonName(family, given)
+	return selfMatcher{
+		family:   strings.ToLower(family),
+		initials: strings.ToLower(initials(given)),
+	}
+}
+
+// Whether the author is self. Names match when the family names are equal
+// and the author's initials start with those given in --self, so "Jensen, K"
+// matches both "Jensen, Kyle" and "Jensen, K. L.".
+func (m selfMatcher) matches(author Author) bool {
+	if m.orcid != "" {
+		return normalizeORCID(author.Person.ORCID) == m.orcid
+	}
+	family, given := normalizePersonName(author.Person.PersonName.FamilyNames, author.Person.PersonName.FirstNames)
+	return strings.ToLower(family) == m.family &&
+		strings.HasPrefix(strings.ToLower(initials(given)), m.initials)
+}
+
+// The position of self in the publication's author list, or "" if self is
+// not an author
+func (m selfMatcher) position(pub Publication) authorPosition {
+	authors := pub.Authors.AuthorList
+	for i, author := range authors {
+		if !m.matches(author) {
+			continue
+		}
+		switch {
+		case len(authors) == 1:
+			return positionSole
+		case i == 0:
+			return positionFirst
+		case i == len(authors)-1:
+			return positionLast
+		default:
+			return positionMiddle
+		}
+	}
+	return ""
+}
+
+// Whether self is among the publication's corresponding authors
+func (m selfMatcher) corresponding(pub Publication) bool {
+	for _, author := range pub.Authors.AuthorList {
+		if corresponding(author) && m.matches(author) {
+			return true
+		}
+	}
+	return false
+}
+
+// How many publications have journal metrics, and how good they are
+type MetricsSummary struct {
+	Publications int            `json:"publications"`
+	WithMetrics  int            `json:"with_metrics"`
+	Quartiles    map[string]int `json:"quartiles"` // "Q1".."Q4", and "unknown"
+	MeanSJR      float64        `json:"mean_sjr"`
+}
+
+// Summary statistics for a set of publications
+type Report struct {
+	MetricsSummary
+
+	// Authorship positions of --self, when given
+	Self          string                 `json:"self,omitempty"`
+	SelfPositions map[authorPosition]int `json:"self_positions,omitempty"`
+	SelfMissing   int                    `json:"self_missing,omitempty"` // publications self isn't an author of
+
+	// Publications self is marked as a corresponding author of, which
+	// only CERIF metadata records
+	SelfCorresponding int `json:"self_corresponding,omitempty"`
+
+	// Citation indicators, when citation counts were looked up. With
+	// --self, only the publications self is an author of count.
+	Citations *CitationSummary `json:"citations,omitempty"`
+
+	// The publications whose journal each provider found, with a
+	// --providers chain beyond the metrics CSV
+	Providers *ProviderStats `json:"providers,omitempty"`
+
+	// Web of Science coverage, with --wos
+	WoS *WoSSummary `json:"wos,omitempty"`
+
+	// Mentions in social media, news and Wikipedia, with --event-data
+	Events *EventSummary `json:"events,omitempty"`
+
+	// The journals the publications appeared in, with --venues
+	Venues *VenueReport `json:"venues,omitempty"`
+
+	// The publications of each grant, with --by-grant
+	Grants []GrantReport `json:"grants,omitempty"`
+
+	// The publications of each department, with --by-department
+	Departments []DepartmentReport `json:"departments,omitempty"`
+
+	// The publications of each author, with --by-author
+	Authors []AuthorReport `json:"authors,omitempty"`
+
+	// The publications of each subject field, with --field-attribution
+	FieldAttribution fieldAttribution `json:"field_attribution,omitempty"`
+	Fields           []FieldReport    `json:"fields,omitempty"`
+
+	// Publications whose metrics are from more than MaxMetricsYearGap
+	// years before or after them. The check is off when that is negative.
+	MaxMetricsYearGap int `json:"max_metrics_year_gap"`
+	MetricsYearGaps   int `json:"metrics_year_gaps"`
+}
+
+// Summarize the journal metrics of the publications
+func summarize(pubs []Publication, db *MetricsDatabase) MetricsSummary {
+	summary := MetricsSummary{
+		Publications: len(pubs),
+		Quartiles:    map[string]int{},
+	}
+	var sjrSum float64
+	var sjrCount int
+	for _, pub := range pubs {
+		metrics, ok := db.LookupISSN(pub.ISSN)
+		if ok {
+			summary.WithMetrics++
+			if metrics.SJR != nil {
+				sjrSum += *metrics.SJR
+				sjrCount++
+			}
+		}
+		if q := formatQuartile(metrics.Quartile); q != "" {
+			summary.Quartiles[q]++
+		} else {
+			summary.Quartiles["unknown"]++
+		}
+	}
+	if sjrCount > 0 {
+		summary.MeanSJR = sjrSum / float64(sjrCount)
+	}
+	return summary
+}
+
+// Compute the summary statistics for the publications, with the
+// authorship positions of self unless it is empty, per-grant summaries
+// when byGrant is true, per-department summaries of the departments given
+// by departments unless it is nil, journal frequencies when venues is
+// true, per-field summaries unless attribution is empty, and the number of
+// publications with metrics more than maxYearGap years away from them
+func buildReport(pubs []Publication, db *MetricsDatabase, self string, byGrant bool, departments func(Publication) []string, venues bool, attribution fieldAttribution, maxYearGap int) Report {
+	report := Report{MetricsSummary: summarize(pubs, db), MaxMetricsYearGap: maxYearGap}
+	for _, pub := range pubs {
+		if metrics, ok := db.LookupISSN(pub.ISSN); ok {
+			if gap, ok := metricsYearGap(pub, metrics); ok && exceedsYearGap(gap, maxYearGap) {
+				report.MetricsYearGaps++
+			}
+		}
+	}
+	if venues {
+		report.Venues = buildVenueReport(pubs, db)
+	}
+	if byGrant {
+		report.Grants = buildGrantReports(pubs, db)
+	}
+	if departments != nil {
+		report.Departments = buildDepartmentReports(pubs, db, departments)
+	}
+	if attribution != "" {
+		report.FieldAttribution = attribution
+		report.Fields = buildFieldReports(pubs, db, attribution)
+	}
+
+	authored := pubs
+	if self != "" {
+		matcher := parseSelf(self)
+		report.Self = self
+		report.SelfPositions = map[authorPosition]int{}
+		authored = nil
+		for _, pub := range pubs {
+			if position := matcher.position(pub); position != "" {
+				report.SelfPositions[position]++
+				authored = append(authored, pub)
+				if matcher.corresponding(pub) {
+					report.SelfCorresponding++
+				}
+			} else {
+				report.SelfMissing++
+			}
+		}
+	}
+	report.Citations = summarizeCitations(authored, time.Now().Year())
+	report.WoS = summarizeWoS(authored)
+	report.Events = summarizeEvents(authored)
+	return report
+}
+
+// Write the report as aligned text
+func writeReportText(w io.Writer, report Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Publications:\t%d\n", report.Publications)
+	fmt.Fprintf(tw, "With journal metrics:\t%d\n", report.WithMetrics)
+	fmt.Fprintf(tw, "Mean SJR:\t%.3f\n", report.MeanSJR)
+	for _, q := range []string{"Q1", "Q2", "Q3", "Q4", "unknown"} {
+		fmt.Fprintf(tw, "Quartile %s:\t%d\n", q, report.Quartiles[q])
+	}
+	if p := report.Providers; p != nil {
+		for _, provider := range p.Providers {
+			fmt.Fprintf(tw, "Journal found by %s:\t%d\n", provider, p.Hits[provider])
+		}
+		fmt.Fprintf(tw, "Journal not found:\t%d\n", p.Misses)
+	}
+	if report.MaxMetricsYearGap >= 0 {
+		fmt.Fprintf(tw, "Metrics year off by more than %d:\t%d\n", report.MaxMetricsYearGap, report.MetricsYearGaps)
+	}
+	if report.Self != "" {
+		fmt.Fprintf(tw, "\nAuthorship of %s\n", report.Self)
+		for _, position := range []authorPosition{positionFirst, positionLast, positionMiddle, positionSole} {
+			fmt.Fprintf(tw, "%s author:\t%d\n", strings.ToUpper(string(position[:1]))+string(position[1:]), report.SelfPositions[position])
+		}
+		fmt.Fprintf(tw, "Corresponding author:\t%d\n", report.SelfCorresponding)
+		fmt.Fprintf(tw, "Not an author:\t%d\n", report.SelfMissing)
+	}
+	if c := report.Citations; c != nil {
+		fmt.Fprintf(tw, "\nCitations of %d publications:\t%d\n", c.Publications, c.Citations)
+		fmt.Fprintf(tw, "h-index:\t%d\n", c.HIndex)
+		fmt.Fprintf(tw, "h5-index:\t%d\n", c.H5Index)
+	}
+	if s := report.WoS; s != nil {
+		fmt.Fprintf(tw, "\nWeb of Science records:\t%d\n", s.Records)
+		fmt.Fprintf(tw, "In journals it indexes:\t%d of %d\n", s.Indexed, s.Publications)
+	}
+	if e := report.Events; e != nil {
+		fmt.Fprintf(tw, "\nEvent Data events of %d publications:\t%d\n", e.Publications, e.Events)
+		for _, source := range e.sources() {
+			fmt.Fprintf(tw, "%s:\t%d\n", source, e.BySource[source])
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if report.Venues != nil {
+		fmt.Fprintln(w)
+		if err := writeVenueReportText(w, report.Venues); err != nil {
+			return err
+		}
+	}
+	if report.Grants != nil {
+		fmt.Fprintln(w)
+		if err := writeGrantReportsText(w, report.Grants); err != nil {
+			return err
+		}
+	}
+	if report.Departments != nil {
+		fmt.Fprintln(w)
+		if err := writeDepartmentReportsText(w, report.Departments); err != nil {
+			return err
+		}
+	}
+	if report.Authors != nil {
+		fmt.Fprintln(w)
+		if err := writeAuthorReportsText(w, report.Authors); err != nil {
+			return err
+		}
+	}
+	if report.Fields != nil {
+		fmt.Fprintln(w)
+		return writeFieldReportsText(w, report.Fields, report.FieldAttribution)
+	}
+	return nil
+}
+
+// Write the report as indented JSON
+func writeReportJSON(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// Write the per-department, per-author or per-field summaries of the
+// report as CSV
+func writeReportCSV(w io.Writer, report Report) error {
+	switch {
+	case report.Departments != nil:
+		return writeDepartmentReportsCSV(w, report.Departments)
+	case report.Authors != nil:
+		return writeAuthorReportsCSV(w, report.Authors)
+	}
+	return writeFieldReportsCSV(w, report.Fields)
+}
+
+// The `report` subcommand: summary statistics for the publications in an
+// XML file, optionally with the authorship positions of one person
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows and XML records instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv, instead of passing it as an argument")
+	format := fs.String("format", "text", "output format: text, json, or csv (the per-department, per-author or per-field summaries only)")
+	repoProfile := fs.String("repo-profile", "", "repository platform whose metadata quirks to handle: dspace, eprints, or pure")
+	metadataFormat := fs.String("metadata-format", "auto", "metadata format of the paper records: auto, cerif, datacite, mods, marcxml, csv, or doi")
+	crossrefFlags(fs)
+	network := networkFlags(fs)
+	bundlePath := fs.String("bundle", "", "run offline against this bundle made with the bundle command, using its data files and cached responses")
+	venues := fs.Bool("venues", false, "also count the publications in each journal, with the number of unique venues and their Gini coefficient")
+	byGrant := fs.Bool("by-grant", false, "also summarize the publications of each grant they acknowledge")
+	crossrefFunders := fs.Bool("crossref-funders", false, "look up the funders and grant numbers of publications without funding metadata on Crossref")
+	byDepartment := fs.Bool("by-department", false, "also summarize the publications of each department their authors are affiliated with")
+	departmentsPath := fs.String("departments", "", "CSV file mapping authors to departments for --by-department, instead of the affiliations in the metadata")
+	byAuthor := fs.Bool("by-author", false, "also summarize the publications of each author, counting the spellings of a name that are likely the same person as one author")
+	confirmORCID := fs.Bool("confirm-orcid", false, "with --by-author, only merge spellings of a name into authors with an ORCID iD")
+	crossrefCitations := fs.Bool("crossref-citations", false, "look up the citation counts of the publications on Crossref, for the h-index and h5-index")
+	scopus := fs.Bool("scopus", false, "look up the publications by DOI and their journals by ISSN on Scopus, filling in missing article metadata and citation counts and adding journals the metrics lack; needs an Elsevier API key")
+	scopusFlags(fs)
+	wos := fs.Bool("wos", false, "look up the publications by DOI and their journals by ISSN on the Web of Science Starter API, filling in missing article metadata and citation counts and counting the publications in journals it indexes; needs a Clarivate API key")
+	wosFlags(fs)
+	providers := fs.String("providers", "csv", "comma-separated journal providers to try in order until one finds a publication's journal: csv (the impact factor csv), cache (journals found before, kept in --cache-dir), openalex, crossref, and scopus; the report counts the journals each found")
+	eventData := fs.Bool("event-data", false, "look up mentions of the publications in social media, news and Wikipedia in Crossref Event Data")
+	linkVersions := fs.Bool("link-preprints", false, "look up the published versions of preprints on Crossref and count each work once")
+	attribution := fs.String("field-attribution", "", "also summarize the publications of each subject field, counting those in journals of several fields towards: primary (the best field), fractional (an equal share of each), or all")
+	maxYearGap := fs.Int("max-metrics-year-gap", 2, "count the publications whose journal metrics are from more than this many years before or after them; -1 to not")
+	self := fs.String("self", "", "count the authorship positions and corresponding authorships of this person, given as \"Family, Initials\" or an ORCID iD")
+	fs.Usage = func() {
+		log.Printf("Usage: %s report [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "report", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if *bundlePath != "" {
+		if err := applyBundle(fs, *bundlePath); err != nil {
+			fatalf(inputExitCode(err), "%v", err)
+		}
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+
+	var write func(io.Writer, Report) error
+	switch *format {
+	case "text":
+		write = writeReportText
+	case "json":
+		write = writeReportJSON
+	case "csv":
+		write = writeReportCSV
+	default:
+		log.Printf("Unknown output format %q", *format)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *departmentsPath != "" {
+		*byDepartment = true
+	}
+	if *attribution != "" && !fieldAttributions[fieldAttribution(*attribution)] {
+		log.Printf("Unknown field attribution %q", *attribution)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	summaries := 0
+	for _, on := range []bool{*byDepartment, *byAuthor, *attribution != ""} {
+		if on {
+			summaries++
+		}
+	}
+	if *format == "csv" && summaries != 1 {
+		log.Printf("--format csv needs one of --by-department, --by-author and --field-attribution")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if err := applyRepoProfileFlags(fs, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if !metadataFormats[*metadataFormat] {
+		log.Printf("Unknown metadata format %q", *metadataFormat)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	reportArgs := fs.Args()
+	if len(reportArgs) == 1 && *metricsPath != "" {
+		reportArgs = append(reportArgs, *metricsPath)
+	}
+	if len(reportArgs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var departments func(Publication) []string
+	if *byDepartment {
+		var mapping []departmentAssignment
+		if *departmentsPath != "" {
+			var err error
+			if mapping, err = loadDepartmentMapping(*departmentsPath); err != nil {
+				fatalf(exitError, "%v", err)
+			}
+		}
+		departments = departmentsOf(mapping)
+	}
+
+	journalDB, err := loadMetrics(reportArgs[1], metricsSources{}, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	xmlFile, err := os.Open(reportArgs[0])
+	if err != nil {
+		fatalf(exitError, "Error reading file: %v", err)
+	}
+	defer xmlFile.Close()
+	pubs, skipped, err := ReadPublications(xmlFile, *metadataFormat, *lenient)
+	if err != nil {
+		fatalf(exitParse, "Error parsing XML: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed XML records", skipped)
+	}
+
+	if profile, ok := repoProfiles[*repoProfile]; ok {
+		applyRepoProfile(pubs, profile)
+	}
+	if *scopus {
+		if _, err := scopusAPIKey(); err != nil {
+			fatalf(exitUsage, "%v", err)
+		}
+		enrichFromScopus(pubs, journalDB)
+	}
+	if *wos {
+		if _, err := wosAPIKey(); err != nil {
+			fatalf(exitUsage, "%v", err)
+		}
+		enrichFromWoS(pubs)
+	}
+	chain, err := parseProviderChain(*providers, network.CacheDir)
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if chain.remote() {
+		chain.resolve(pubs, journalDB)
+	}
+	if *crossrefFunders {
+		enrichFundingFromCrossref(pubs)
+	}
+	if *linkVersions {
+		pubs = linkPreprints(pubs, "replace")
+	}
+	if *crossrefCitations {
+		enrichCitationsFromCrossref(pubs)
+	}
+	if *eventData {
+		enrichEventsFromCrossref(pubs)
+	}
+
+	report := buildReport(pubs, journalDB, *self, *byGrant, departments, *venues, fieldAttribution(*attribution), *maxYearGap)
+	if chain.remote() {
+		report.Providers = chain.stats(pubs)
+	}
+	if *byAuthor {
+		report.Authors = buildAuthorReports(pubs, journalDB, *confirmORCID)
+	}
+	if err := write(os.Stdout, report); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
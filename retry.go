@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How requests to one remote provider are retried, and when the provider
+// is given a rest after failing repeatedly
+type retryPolicy struct {
+	Attempts   int           // tries per request, including the first
+	Backoff    time.Duration // wait before the first retry, doubling for each further one
+	MaxBackoff time.Duration // longest wait between tries, also for Retry-After
+	Breaker    int           // requests failing in a row that open the circuit, or 0 for never
+	Cooldown   time.Duration // how long an open circuit fails requests before trying again
+}
+
+var defaultRetryPolicy = retryPolicy{
+	Attempts:   3,
+	Backoff:    time.Second,
+	MaxBackoff: 30 * time.Second,
+	Breaker:    5,
+	Cooldown:   time.Minute,
+}
+
+// Parse a --retry value: comma-separated provider.setting=value items,
+// such as "crossref.attempts=5,ror.breaker=0". The provider "all" applies
+// to every provider, before the settings of each. Durations are given as
+// for time.ParseDuration, e.g. 500ms or 2s.
+func parseRetryPolicies(spec string) (map[string]retryPolicy, error) {
+	policies := map[string]retryPolicy{}
+	for provider := range httpClients {
+		policies[provider] = defaultRetryPolicy
+	}
+	items := strings.Split(spec, ",")
+	sort.SliceStable(items, func(i, j int) bool {
+		return strings.HasPrefix(strings.TrimSpace(items[i]), "all.") && !strings.HasPrefix(strings.TrimSpace(items[j]), "all.")
+	})
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		provider, setting, ok2 := strings.Cut(key, ".")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid --retry item %q, expected provider.setting=value", item)
+		}
+		var providers []string
+		if provider == "all" {
+			for name := range policies {
+				providers = append(providers, name)
+			}
+		} else if _, ok := policies[provider]; ok {
+			providers = []string{provider}
+		} else {
+			return nil, fmt.Errorf("unknown provider %q in --retry", provider)
+		}
+		for _, name := range providers {
+			policy := policies[name]
+			if err := policy.set(setting, value); err != nil {
+				return nil, fmt.Errorf("invalid --retry item %q: %v", item, err)
+			}
+			policies[name] = policy
+		}
+	}
+	return policies, nil
+}
+
+// Set one setting of the policy from its --retry value
+func (p *retryPolicy) set(setting, value string) error {
+	var err error
+	switch setting {
+	case "attempts", "breaker":
+		var n int
+		if n, err = strconv.Atoi(value); err == nil && n < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if setting == "attempts" {
+			p.Attempts = max(n, 1)
+		} else {
+			p.Breaker = n
+		}
+	case "backoff":
+		p.Backoff, err = time.ParseDuration(value)
+	case "max-backoff":
+		p.MaxBackoff, err = time.ParseDuration(value)
+	case "cooldown":
+		p.Cooldown, err = time.ParseDuration(value)
+	default:
+		err = fmt.Errorf("unknown setting %q", setting)
+	}
+	return err
+}
+
+// A transport that retries failed requests to one provider with
+// exponential backoff, and stops sending it requests for a while once
+// several have failed in a row, so a provider that is down doesn't hold up
+// a long run with timeouts and retries for every publication
+type retryTransport struct {
+	provider string
+	base     http.RoundTripper
+	policy   retryPolicy
+
+	mu        sync.Mutex
+	failures  int // requests failed in a row
+	openUntil time.Time
+}
+
+// Whether a response status is worth retrying: rate limiting and server
+// errors that are typically temporary
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	if wait := time.Until(t.openUntil); wait > 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%s is failing, not trying again for %v", t.provider, wait.Round(time.Second))
+	}
+	t.mu.Unlock()
+
+	// A body can only be sent again when it can be recreated
+	attempts := t.policy.Attempts
+	if req.Body != nil && req.GetBody == nil {
+		attempts = 1
+	}
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		try := req
+		if attempt > 1 && req.Body != nil {
+			try = req.Clone(req.Context())
+			if try.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err = t.base.RoundTrip(try)
+		failed := err != nil || retryableStatus(resp.StatusCode)
+		if !failed || attempt >= attempts || req.Context().Err() != nil {
+			t.record(failed)
+			return resp, err
+		}
+
+		// Wait before the next try, as long as the provider asks for
+		// rate limiting, and otherwise doubling with some jitter
+		delay := min(t.policy.Backoff<<(attempt-1), t.policy.MaxBackoff)
+		delay = delay/2 + time.Duration(rand.Int64N(int64(delay/2)+1))
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = min(time.Duration(seconds)*time.Second, t.policy.MaxBackoff)
+			}
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			t.record(true)
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Count a request's outcome towards the circuit breaker, opening it when
+// the provider has failed too often in a row
+func (t *retryTransport) record(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if t.policy.Breaker > 0 && t.failures >= t.policy.Breaker {
+		t.openUntil = time.Now().Add(t.policy.Cooldown)
+		log.Printf("Warning: %d requests to %s failed in a row; pausing requests to it for %v", t.failures, t.provider, t.policy.Cooldown)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches a ROR identifier, bare or as a https://ror.org/ link, capturing
+// the ID
+var rorPattern = regexp.MustCompile(`(?i)^(?:(?:https?://)?ror\.org/)?(0[a-z0-9]{6}\d{2})/?$`)
+
+// Base URL of the ROR organizations API
+var rorAPIURL = "https://api.ror.org/v2/organizations"
+
+// Client for ROR API requests
+var rorClient = &http.Client{Timeout: 30 * time.Second}
+
+// The https://ror.org/ link for a ROR identifier given as a link or bare,
+// or "" if it isn't one
+func normalizeROR(id string) string {
+	if match := rorPattern.FindStringSubmatch(strings.TrimSpace(id)); match != nil {
+		return "https://ror.org/" + strings.ToLower(match[1])
+	}
+	return ""
+}
+
+// The organisational unit's ROR identifier, as a https://ror.org/ link,
+// or "" if it has none
+func (u OrgUnit) rorID() string {
+	if u.ROR != "" {
+		return normalizeROR(u.ROR)
+	}
+	for _, id := range u.Identifiers {
+		if strings.EqualFold(id.Type, "ROR") || strings.Contains(strings.ToLower(id.Value), "ror.org/") {
+			if ror := normalizeROR(id.Value); ror != "" {
+				return ror
+			}
+		}
+	}
+	return ""
+}
+
+// Ask the ROR affiliation matcher for the organization an affiliation
+// string names. Returns "" when ROR has no confident match.
+func matchROR(affiliation string) (string, error) {
+	resp, err := rorClient.Get(rorAPIURL + "?" + url.Values{"affiliation": {affiliation}}.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Items []struct {
+			Chosen       bool `json:"chosen"`
+			Organization struct {
+				ID string `json:"id"`
+			} `json:"organization"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing ROR response: %v", err)
+	}
+	for _, item := range result.Items {
+		if item.Chosen {
+			return normalizeROR(item.Organization.ID), nil
+		}
+	}
+	return "", nil
+}
+
+// Match the author affiliations without a ROR identifier, and the units
+// they are part of, to ROR, asking about each affiliation name once.
+// Lookup failures are logged and leave the affiliation unmatched.
+func matchAffiliationsToROR(pubs []Publication) {
+	matches := map[string]string{}
+	var match func(unit *OrgUnit)
+	match = func(unit *OrgUnit) {
+		if unit.PartOf != nil {
+			match(unit.PartOf)
+		}
+		name := strings.Join(strings.Fields(unit.Name), " ")
+		if unit.rorID() != "" || name == "" {
+			return
+		}
+		ror, ok := matches[name]
+		if !ok {
+			var err error
+			if ror, err = matchROR(name); err != nil {
+				log.Printf("Warning: matching %q to ROR: %v", name, err)
+			}
+			matches[name] = ror
+		}
+		unit.ROR = ror
+	}
+	for i := range pubs {
+		for j := range pubs[i].Authors.AuthorList {
+			affiliations := pubs[i].Authors.AuthorList[j].Affiliations
+			for k := range affiliations {
+				match(&affiliations[k])
+			}
+		}
+	}
+}
+
+// Keep only the publications with at least one author affiliated with the
+// institution, given as a ROR identifier or a name (case-insensitively).
+// Affiliations match when they or any unit they are part of is the
+// institution, so departments count towards their university.
+func filterInstitution(pubs []Publication, institution string) []Publication {
+	ror := normalizeROR(institution)
+	name := strings.Join(strings.Fields(institution), " ")
+	matches := func(unit *OrgUnit) bool {
+		for ; unit != nil; unit = unit.PartOf {
+			if ror != "" && unit.rorID() == ror || ror == "" && strings.EqualFold(strings.Join(strings.Fields(unit.Name), " "), name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out []Publication
+	for _, pub := range pubs {
+	authors:
+		for _, author := range pub.Authors.AuthorList {
+			for i := range author.Affiliations {
+				if matches(&author.Affiliations[i]) {
+					out = append(out, pub)
+					break authors
+				}
+			}
+		}
+	}
+	return out
+}
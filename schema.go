@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Builds JSON Schemas for Go types as encoding/json marshals them. Named
+// struct types become definitions referenced by name, which also handles
+// recursive types like OrgUnit.
+type schemaBuilder struct {
+	refPrefix string // where definitions are referenced, e.g. "#/$defs/"
+	defs      map[string]any
+}
+
+func newSchemaBuilder(refPrefix string) *schemaBuilder {
+	return &schemaBuilder{refPrefix: refPrefix, defs: map[string]any{}}
+}
+
+// The schema of values of type t
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{b.schema(t.Elem()), map[string]any{"type": "null"}}}
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes nil slices as null
+		return map[string]any{"type": []string{"array", "null"}, "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": b.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.defs[name]; !ok {
+			b.defs[name] = nil // placeholder, so recursive references stop here
+			b.defs[name] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": b.refPrefix + name}
+	}
+	return map[string]any{}
+}
+
+// The schema of a struct, following the field names, omissions and
+// embedding rules of encoding/json
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = b.schema(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	sort.Strings(required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// The JSON outputs that have schemas, by the name used with `schema`
+var jsonSchemaTypes = map[string]struct {
+	description string
+	value       any
+}{
+	"publications": {"The output of --format json: an array of publications with their journal metrics", []publicationJSON{}},
+	"report":       {"The output of report --format json", Report{}},
+	"lookup":       {"The output of lookup --format json and of POST /v1/lookup", []LookupResult{}},
+	"journals":     {"The output of GET /v1/title", []JournalMetrics{}},
+	"manifest":     {"The run manifest written by --manifest", RunManifest{}},
+}
+
+// The JSON Schema of one of the jsonSchemaTypes
+func jsonSchema(name string) (map[string]any, error) {
+	output, ok := jsonSchemaTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q", name)
+	}
+	b := newSchemaBuilder("#/$defs/")
+	schema := b.schema(reflect.TypeOf(output.value))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = name
+	schema["description"] = output.description
+	schema["$defs"] = b.defs
+	return schema, nil
+}
+
+// The OpenAPI description of the HTTP server's API
+func openAPISpec() map[string]any {
+	b := newSchemaBuilder("#/components/schemas/")
+	jsonResponse := func(description string, value any) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(value))},
+			},
+		}
+	}
+	textResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content":     map[string]any{"text/plain": map[string]any{"schema": map[string]any{"type": "string"}}},
+		}
+	}
+	loading := textResponse("The metrics database is still loading")
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "impact-factor-lookup",
+			"version": "2",
+		},
+		"paths": map[string]any{
+			"/v1/lookup": map[string]any{
+				"post": map[string]any{
+					"summary": "Look up journals by ISSN, title, or sourceid:ID",
+					"requestBody": map[string]any{
+						"required": true,
+						"content": map[string]any{
+							"application/json": map[string]any{"schema": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+						},
+					},
+					"responses": map[string]any{
+						"200": jsonResponse("One result per query, in order", []LookupResult{}),
+						"400": textResponse("The body isn't a JSON array of strings"),
+						"503": loading,
+					},
+				},
+			},
+			"/v1/title": map[string]any{
+				"get": map[string]any{
+					"summary": "Search journals by the words of their titles",
+					"parameters": []any{
+						map[string]any{"name": "q", "in": "query", "required": true, "schema": map[string]any{"type": "string"}},
+						map[string]any{"name": "limit", "in": "query", "schema": map[string]any{"type": "integer", "minimum": 1, "maximum": maxTitleLimit, "default": defaultTitleLimit}},
+					},
+					"responses": map[string]any{
+						"200": jsonResponse("Matching journals, best matches first", []JournalMetrics{}),
+						"400": textResponse("limit is out of range"),
+						"503": loading,
+					},
+				},
+			},
+			"/healthz": map[string]any{
+				"get": map[string]any{"summary": "Whether the process is up", "responses": map[string]any{"200": textResponse("ok")}},
+			},
+			"/readyz": map[string]any{
+				"get": map[string]any{"summary": "Whether the metrics database is loaded", "responses": map[string]any{"200": textResponse("ok"), "503": loading}},
+			},
+			"/metrics": map[string]any{
+				"get": map[string]any{"summary": "Prometheus metrics", "responses": map[string]any{"200": textResponse("Metrics in the Prometheus text format")}},
+			},
+			"/admin/reload": map[string]any{
+				"post": map[string]any{"summary": "Reload the metrics database", "responses": map[string]any{"200": textResponse("ok"), "500": textResponse("The database failed to load; the old one is kept")}},
+			},
+		},
+		"components": map[string]any{"schemas": b.defs},
+	}
+}
+
+// Write v as indented JSON
+func writeIndentedJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// GET /v1/openapi.json: the OpenAPI description of this API
+func (s *lookupServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeIndentedJSON(w, openAPISpec()); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// GET /v1/schemas/{name}.json: the JSON Schema of one of the JSON outputs
+func (s *lookupServer) handleSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := jsonSchema(strings.TrimSuffix(r.PathValue("name"), ".json"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	if err := writeIndentedJSON(w, schema); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// The `schema` subcommand: print the JSON Schema of a JSON output, or the
+// OpenAPI description of the server
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Usage = func() {
+		names := make([]string, 0, len(jsonSchemaTypes))
+		for name := range jsonSchemaTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Printf("Usage: %s schema <%s|openapi>", os.Args[0], strings.Join(names, "|"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var v any
+	if fs.Arg(0) == "openapi" {
+		v = openAPISpec()
+	} else {
+		schema, err := jsonSchema(fs.Arg(0))
+		if err != nil {
+			log.Printf("%v", err)
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		v = schema
+	}
+	if err := writeIndentedJSON(os.Stdout, v); err != nil {
+		fatalf(exitError, "%v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Base URL of the Elsevier APIs, of which the Serial Title and Abstract
+// Retrieval APIs are used
+var scopusAPIURL = "https://api.elsevier.com/content/"
+
+// Client for Scopus API requests
+var scopusClient = &http.Client{Timeout: 30 * time.Second}
+
+// The Elsevier API key given with --scopus-key
+var scopusKey string
+
+// Define the --scopus-key flag of a command that may call Scopus
+func scopusFlags(fs *flag.FlagSet) {
+	fs.StringVar(&scopusKey, "scopus-key", "", "Elsevier API key for --scopus, instead of one stored with config set-key scopus")
+}
+
+// The API key for Scopus requests, or an error when none is given or stored
+func scopusAPIKey() (string, error) {
+	key := apiKey("scopus", scopusKey)
+	if key == "" {
+		return "", fmt.Errorf("--scopus needs an Elsevier API key; pass --scopus-key or store one with config set-key scopus")
+	}
+	return key, nil
+}
+
+// Fetch a Scopus API resource, by its path under scopusAPIURL, and decode
+// the response into response. Returns false if Scopus doesn't know the
+// resource or the request fails.
+func fetchScopus(path string, response any) (bool, error) {
+	key, err := scopusAPIKey()
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("GET", scopusAPIURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-ELS-APIKey", key)
+	resp, err := scopusClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return false, fmt.Errorf("error parsing Scopus response: %v", err)
+	}
+	return true, nil
+}
+
+// A value of a Scopus metric list, such as SJRList, for one year
+type scopusYearValue struct {
+	Year  string `json:"@year"`
+	Value string `json:"$"`
+}
+
+// The parts of a Serial Title API entry that are used here. Scopus gives
+// numbers as strings.
+type scopusSerial struct {
+	Error     string `json:"error"` // e.g. "Title not found"
+	Title     string `json:"dc:title"`
+	Publisher string `json:"dc:publisher"`
+	ISSN      string `json:"prism:issn"`
+	EISSN     string `json:"prism:eIssn"`
+	SourceID  string `json:"source-id"`
+	Subjects  []struct {
+		Code string `json:"@code"`
+	} `json:"subject-area"`
+	SNIPList struct {
+		SNIP []scopusYearValue `json:"SNIP"`
+	} `json:"SNIPList"`
+	SJRList struct {
+		SJR []scopusYearValue `json:"SJR"`
+	} `json:"SJRList"`
+	CiteScore struct {
+		Current     string `json:"citeScoreCurrentMetric"`
+		CurrentYear string `json:"citeScoreCurrentMetricYear"`
+	} `json:"citeScoreYearInfoList"`
+}
+
+// Fetch the Serial Title API entry of a journal by ISSN. Returns nil if
+// Scopus doesn't know the ISSN.
+func fetchScopusSerial(issn string) (*scopusSerial, error) {
+	var response struct {
+		Result struct {
+			Entry []scopusSerial `json:"entry"`
+		} `json:"serial-metadata-response"`
+	}
+	found, err := fetchScopus("serial/title/issn/"+url.PathEscape(normalizeISSN(issn))+"?view=ENHANCED", &response)
+	if !found || len(response.Result.Entry) == 0 || response.Result.Entry[0].Error != "" {
+		return nil, err
+	}
+	return &response.Result.Entry[0], nil
+}
+
+// Parse a number Scopus gives as a string, or nil when it is missing
+func scopusNumber(s string) *float64 {
+	v, err := parseFiniteFloat(strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// The latest value of a Scopus metric list, and its year
+func latestScopusValue(values []scopusYearValue) (*float64, int64) {
+	var latest *float64
+	var latestYear int64
+	for _, v := range values {
+		year, _ := strconv.ParseInt(v.Year, 10, 64)
+		if value := scopusNumber(v.Value); value != nil && year >= latestYear {
+			latest, latestYear = value, year
+		}
+	}
+	return latest, latestYear
+}
+
+// The journal's metrics as a database record. Scopus source IDs are the
+// ones SCImago uses, so the record joins the journal's SCImago rows.
+func (s scopusSerial) metrics() JournalMetrics {
+	sourceID, _ := strconv.ParseInt(s.SourceID, 10, 64)
+	m := JournalMetrics{Title: s.Title, SourceID: sourceID, Publisher: s.Publisher}
+	for _, issn := range []string{s.ISSN, s.EISSN} {
+		if issn = normalizeISSN(issn); issn != "" {
+			m.ISSNs = append(m.ISSNs, issn)
+		}
+	}
+	for _, subject := range s.Subjects {
+		if code, err := strconv.ParseInt(subject.Code, 10, 64); err == nil {
+			m.Fields = append(m.Fields, newSubjectField(code))
+		}
+	}
+	var snipYear int64
+	m.SJR, m.Year = latestScopusValue(s.SJRList.SJR)
+	m.SNIP, snipYear = latestScopusValue(s.SNIPList.SNIP)
+	m.CiteScore = scopusNumber(s.CiteScore.Current)
+	if m.Year == 0 {
+		m.Year = snipYear
+	}
+	if m.Year == 0 {
+		m.Year, _ = strconv.ParseInt(s.CiteScore.CurrentYear, 10, 64)
+	}
+	return m
+}
+
+// The parts of an Abstract Retrieval API record that are used here
+type scopusAbstract struct {
+	Title        string `json:"dc:title"`
+	Journal      string `json:"prism:publicationName"`
+	ISSN         string `json:"prism:issn"` // print and electronic ISSNs, separated by a space
+	CoverDate    string `json:"prism:coverDate"`
+	Volume       string `json:"prism:volume"`
+	Issue        string `json:"prism:issueIdentifier"`
+	CitedByCount string `json:"citedby-count"`
+}
+
+// Fetch the Abstract Retrieval API record of a DOI. Returns nil if Scopus
+// doesn't know the DOI.
+func fetchScopusAbstract(doi string) (*scopusAbstract, error) {
+	var response struct {
+		Result struct {
+			CoreData scopusAbstract `json:"coredata"`
+		} `json:"abstracts-retrieval-response"`
+	}
+	found, err := fetchScopus("abstract/doi/"+url.PathEscape(doiName(doi))+"?field=dc:title,prism:publicationName,prism:issn,prism:coverDate,prism:volume,prism:issueIdentifier,citedby-count", &response)
+	if !found {
+		return nil, err
+	}
+	return &response.Result.CoreData, nil
+}
+
+// Fill in what the publication's record lacks from its Scopus record: the
+// title, journal, ISSN, date, volume, issue and citation count
+func (a scopusAbstract) fill(pub *Publication) {
+	if pub.Title == "" {
+		pub.Title = a.Title
+	}
+	if pub.Published.Publication.Title == "" {
+		pub.Published.Publication.Title = a.Journal
+	}
+	if pub.ISSN == "" {
+		if issns := strings.Fields(a.ISSN); len(issns) > 0 {
+			pub.ISSN = formatISSN(issns[0])
+		}
+	}
+	if pub.Date == "" {
+		pub.Date = a.CoverDate
+	}
+	if pub.Volume == "" {
+		pub.Volume = a.Volume
+	}
+	if pub.Issue == "" {
+		pub.Issue = a.Issue
+	}
+	if count, err := strconv.Atoi(a.CitedByCount); err == nil && pub.Citations == nil {
+		pub.Citations = &count
+	}
+}
+
+// Look the publications and their journals up on Scopus: fill in the
+// article metadata of publications with a DOI, add the journals the
+// metrics don't list, and add CiteScore and SNIP to those that lack them.
+// Lookup failures are logged and leave the publication or journal as it
+// was.
+func enrichFromScopus(pubs []Publication, db *MetricsDatabase) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if pub.DOI == "" {
+			continue
+		}
+		abstract, err := fetchScopusAbstract(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up %s on Scopus: %v", pub.DOI, err)
+			continue
+		}
+		if abstract != nil {
+			abstract.fill(pub)
+		}
+	}
+
+	looked := map[string]bool{}
+	added, updated := 0, 0
+	for _, pub := range pubs {
+		issn := normalizeISSN(pub.ISSN)
+		if issn == "" || looked[issn] {
+			continue
+		}
+		looked[issn] = true
+		known, ok := db.LookupISSN(issn)
+		if ok && known.CiteScore != nil && known.SNIP != nil {
+			continue
+		}
+		serial, err := fetchScopusSerial(issn)
+		if err != nil {
+			log.Printf("Warning: looking up ISSN %s on Scopus: %v", formatISSN(issn), err)
+			continue
+		}
+		if serial == nil {
+			continue
+		}
+		m := serial.metrics()
+		if !ok {
+			db.Add(m)
+			db.setOrigin(m.SourceID, "scopus")
+			added++
+		} else if db.addCiteScore(citeScoreRow{SourceID: known.SourceID, Year: known.Year, CiteScore: m.CiteScore, SNIP: m.SNIP}) {
+			updated++
+		}
+	}
+	if added > 0 || updated > 0 {
+		log.Printf("Added %d journals the metrics lack from Scopus, and CiteScore or SNIP to %d others", added, updated)
+	}
+}
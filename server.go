@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Largest request body accepted by the lookup endpoint
+const maxLookupBody = 10 << 20
+
+// Number of journals returned by the title search endpoint, by default and
+// at most
+const (
+	defaultTitleLimit = 10
+	maxTitleLimit     = 100
+)
+
+// HTTP handlers serving lookups from a metrics database. The database is
+// loaded in the background, so the server isn't ready until the first load
+// completes. Reloads swap in the new contents with MetricsDatabase.Replace,
+// so each request sees either the old or the new database in full.
+type lookupServer struct {
+	db    *MetricsDatabase
+	ready atomic.Bool
+	stats *serverStats
+
+	load     func() (*MetricsDatabase, error)
+	reloadMu sync.Mutex
+}
+
+// Build the request router
+func (s *lookupServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/lookup", s.stats.instrument("/v1/lookup", s.handleBatchLookup))
+	mux.HandleFunc("GET /v1/title", s.stats.instrument("/v1/title", s.handleTitleSearch))
+	mux.HandleFunc("GET /v1/openapi.json", s.handleOpenAPI)
+	mux.HandleFunc("GET /v1/schemas/{name}", s.handleSchema)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("POST /admin/reload", s.handleReload)
+	return mux
+}
+
+// Install a freshly loaded database
+func (s *lookupServer) setDatabase(db *MetricsDatabase) {
+	s.db.Replace(db)
+	s.ready.Store(true)
+	s.stats.loadedAt.Store(time.Now().Unix())
+}
+
+// Load the database again and swap it in. On failure the current database
+// is kept.
+func (s *lookupServer) reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	db, err := s.load()
+	if err != nil {
+		return err
+	}
+	s.setDatabase(db)
+	return nil
+}
+
+// Reload the database whenever the process receives SIGHUP
+func (s *lookupServer) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Printf("Received SIGHUP, reloading metrics database")
+		if err := s.reload(); err != nil {
+			log.Printf("Error reloading metrics database: %v", err)
+		}
+	}
+}
+
+// Poll the metrics file every interval and reload the database when its
+// modification time changes
+func (s *lookupServer) reloadOnChange(filename string, interval time.Duration) {
+	var lastModified time.Time
+	if info, err := os.Stat(filename); err == nil {
+		lastModified = info.ModTime()
+	}
+	for range time.Tick(interval) {
+		info, err := os.Stat(filename)
+		if err != nil || info.ModTime().Equal(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		log.Printf("%s changed, reloading metrics database", filename)
+		if err := s.reload(); err != nil {
+			log.Printf("Error reloading metrics database: %v", err)
+		}
+	}
+}
+
+// Run refresh at each time the schedule matches, logging failures
+func (s *lookupServer) refreshOnSchedule(schedule *cronSchedule, refresh func() error) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Refresh schedule never matches, not refreshing")
+			return
+		}
+		time.Sleep(time.Until(next))
+		log.Printf("Scheduled refresh")
+		if err := refresh(); err != nil {
+			log.Printf("Error in scheduled refresh: %v", err)
+		}
+	}
+}
+
+// POST /admin/reload: reload the metrics database from disk
+func (s *lookupServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(); err != nil {
+		log.Printf("Error reloading metrics database: %v", err)
+		http.Error(w, "error reloading metrics database: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// POST /v1/lookup: the body is a JSON array of ISSNs and/or journal titles,
+// and the response is a JSON array with one LookupResult per query
+func (s *lookupServer) handleBatchLookup(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "metrics database is still loading", http.StatusServiceUnavailable)
+		return
+	}
+
+	var queries []string
+	body := http.MaxBytesReader(w, r.Body, maxLookupBody)
+	if err := json.NewDecoder(body).Decode(&queries); err != nil {
+		http.Error(w, "expected a JSON array of ISSNs or titles: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := s.db.LookupBatch(queries)
+	s.stats.observeLookups(results)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// GET /v1/title?q=nature%20comm&limit=10: journals whose titles match the
+// words of q, where each word may be the start of a title word, for
+// autocompleting journal names. The response is a JSON array of journals,
+// best matches first.
+func (s *lookupServer) handleTitleSearch(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "metrics database is still loading", http.StatusServiceUnavailable)
+		return
+	}
+
+	limit := defaultTitleLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxTitleLimit {
+			http.Error(w, fmt.Sprintf("limit must be a number from 1 to %d", maxTitleLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	journals := s.db.SearchTitles(r.URL.Query().Get("q"))
+	if len(journals) > limit {
+		journals = journals[:limit]
+	}
+	if journals == nil {
+		journals = []JournalMetrics{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(journals); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// GET /healthz: the process is up
+func (s *lookupServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// GET /readyz: the metrics database has been loaded and lookups can be served
+func (s *lookupServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		http.Error(w, "metrics database is still loading", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// GET /metrics: counters and histograms in the Prometheus text format
+func (s *lookupServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.stats.writeTo(w)
+}
+
+// The `serve` subcommand: serve lookups from the metrics database over HTTP
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	network := networkFlags(fs)
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv")
+	sources := metricsSourceFlags(fs)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests when shutting down")
+	watchInterval := fs.Duration("watch-interval", 0, "how often to check the impact factor csv for changes and reload it (0 disables)")
+	refresh := fs.String("refresh", "", "crontab-style schedule, e.g. \"0 3 * * *\", on which to reload the impact factor csv and republish --publish-dir")
+	harvestURL := fs.String("harvest-url", "", "OAI-PMH base URL to harvest publications from on each --refresh")
+	harvestSet := fs.String("harvest-set", "", "OAI-PMH set to harvest")
+	metadataPrefix := fs.String("metadata-prefix", defaultMetadataPrefix, "OAI-PMH metadata format to harvest")
+	repoProfile := fs.String("repo-profile", "", "repository platform to harvest from, presetting --metadata-prefix and --harvest-set: dspace, eprints, or pure")
+	publishDir := fs.String("publish-dir", "", "directory to write the harvested publications.xml and generated publications.bib to on each --refresh")
+	notifyWebhook := fs.String("notify-webhook", "", "Slack or Teams incoming webhook URL to post what changed in each harvest to")
+	notifyEmail := fs.String("notify-email", "", "comma-separated addresses to email what changed in each harvest to")
+	smtpAddr := fs.String("smtp-addr", "localhost:25", "host:port of the mail server for --notify-email")
+	smtpFrom := fs.String("smtp-from", "", "sender address for --notify-email")
+	smtpUser := fs.String("smtp-user", "", "username to authenticate to the mail server with, if it needs one")
+	smtpPassword := fs.String("smtp-password", "", "password for --smtp-user; better set in the config file or the environment")
+	fs.Usage = func() {
+		log.Printf("Usage: %s serve [flags]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "serve", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := applyRepoProfileFlags(fs, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	var schedule *cronSchedule
+	if *refresh != "" {
+		var err error
+		if schedule, err = parseCron(*refresh); err != nil {
+			fatalf(exitUsage, "Bad --refresh schedule: %v", err)
+		}
+	}
+	if (*harvestURL == "") != (*publishDir == "") {
+		fatalf(exitUsage, "--harvest-url and --publish-dir must be given together")
+	}
+	if *harvestURL != "" && schedule == nil {
+		fatalf(exitUsage, "--harvest-url needs a --refresh schedule")
+	}
+	notifications := notifyConfig{
+		Webhook: *notifyWebhook,
+		SMTP:    *smtpAddr,
+		From:    *smtpFrom,
+		User:    *smtpUser,
+		Pass:    *smtpPassword,
+	}
+	for _, address := range strings.Split(*notifyEmail, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			notifications.EmailTo = append(notifications.EmailTo, address)
+		}
+	}
+	if notifications.enabled() && *harvestURL == "" {
+		fatalf(exitUsage, "--notify-webhook and --notify-email need --harvest-url")
+	}
+	if len(notifications.EmailTo) > 0 && notifications.From == "" {
+		fatalf(exitUsage, "--notify-email needs --smtp-from")
+	}
+
+	server := &lookupServer{
+		db:    NewMetricsDatabase(),
+		stats: newServerStats(),
+		load: func() (*MetricsDatabase, error) {
+			return loadMetrics(*metricsPath, *sources, *lenient)
+		},
+	}
+
+	// Load the database in the background so health checks can be answered
+	// while a large CSV is being read
+	go func() {
+		if err := server.reload(); err != nil {
+			fatalf(inputExitCode(err), "%v", err)
+		}
+		log.Printf("Loaded metrics database from %s", *metricsPath)
+		go server.reloadOnSignal()
+		if *watchInterval > 0 {
+			go server.reloadOnChange(*metricsPath, *watchInterval)
+		}
+		if schedule != nil {
+			publish := publishConfig{
+				HarvestURL:     *harvestURL,
+				MetadataPrefix: *metadataPrefix,
+				Set:            *harvestSet,
+				Profile:        *repoProfile,
+				Dir:            *publishDir,
+				Notify:         notifications,
+			}
+			go server.refreshOnSchedule(schedule, func() error {
+				if err := server.reload(); err != nil {
+					return err
+				}
+				if publish.HarvestURL == "" {
+					return nil
+				}
+				return harvestAndPublish(publish, server.db)
+			})
+		}
+	}()
+
+	httpServer := &http.Server{Addr: *addr, Handler: server.routes()}
+
+	// On SIGINT or SIGTERM, stop accepting connections and let in-flight
+	// requests finish before exiting with the signal's exit code
+	shutdownDone := make(chan os.Signal, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Shutting down, waiting up to %v for requests to finish", *shutdownTimeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			fatalf(exitError, "Error shutting down: %v", err)
+		}
+		shutdownDone <- sig
+	}()
+
+	log.Printf("Listening on %s", *addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		fatalf(exitError, "%v", err)
+	}
+	os.Exit(exitCodeForSignal(<-shutdownDone))
+}
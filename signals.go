@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// The exit code for a run aborted by sig
+func exitCodeForSignal(sig os.Signal) int {
+	if sig == syscall.SIGTERM {
+		return exitTerminated
+	}
+	return exitInterrupted
+}
+
+// An output file that only appears at its final path once it has been
+// written completely. Until Commit is called the data goes to a temporary
+// file in the same directory, so an interrupted run never leaves a
+// truncated file behind.
+type atomicFile struct {
+	*os.File
+	path string
+	perm os.FileMode // of the final file, 0644 unless set
+
+	mu   sync.Mutex
+	done bool
+}
+
+// Atomic files being written, which abortAtomicFiles discards
+var pendingAtomicFiles sync.Map // *atomicFile -> struct{}
+
+// Start writing the file that will end up at path
+func createAtomicFile(path string) (*atomicFile, error) {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &atomicFile{File: temp, path: path, perm: 0o644}
+	pendingAtomicFiles.Store(f, struct{}{})
+	return f, nil
+}
+
+// Discard every atomic file that hasn't been committed yet, e.g. when the
+// process is interrupted
+func abortAtomicFiles() {
+	pendingAtomicFiles.Range(func(f, _ any) bool {
+		f.(*atomicFile).Abort()
+		return true
+	})
+}
+
+// Move the finished file into place
+func (f *atomicFile) Commit() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return nil
+	}
+	f.done = true
+	pendingAtomicFiles.Delete(f)
+
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Chmod(f.File.Name(), f.perm); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return os.Rename(f.File.Name(), f.path)
+}
+
+// Throw away the partially written file. It is safe to call Abort after
+// Commit, in which case it does nothing.
+func (f *atomicFile) Abort() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return
+	}
+	f.done = true
+	pendingAtomicFiles.Delete(f)
+
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// Exit as soon as SIGINT or SIGTERM arrives, running cleanup first so
+// partial output can be discarded. Used by the batch modes, whose inputs
+// are read-only, so nothing else needs to be unwound.
+func abortOnSignal(cleanup func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, aborting", sig)
+		cleanup()
+		os.Exit(exitCodeForSignal(sig))
+	}()
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A link to another page of the site
+type siteLink struct {
+	Name string
+	Path string
+	Self bool // whether the link is to the page of the --self author
+}
+
+// A publication as shown on the site
+type sitePublication struct {
+	Publication
+	Key      string
+	Year     string
+	Authors  []siteLink
+	Journal  *siteLink // nil when the publication has no journal
+	Link     string    // the DOI link, or the publication's URL
+	Metrics  *JournalMetrics
+	Quartile string
+}
+
+// The publications of one year on the index page
+type siteYear struct {
+	Year         string
+	Publications []sitePublication
+}
+
+// The data a page template is executed with
+type sitePage struct {
+	Title        string
+	SiteTitle    string
+	Years        []siteYear        // index page
+	Authors      []siteLink        // index page
+	Journals     []siteLink        // index page
+	Journal      *JournalMetrics   // journal pages, when the journal has metrics
+	Publications []sitePublication // author and journal pages
+}
+
+const siteHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; line-height: 1.4; }
+li { margin-bottom: 0.6em; }
+.meta { color: #555; }
+</style>
+</head>
+<body>
+<p><a href="index.html">{{.SiteTitle}}</a></p>
+<h1>{{.Title}}</h1>
+`
+
+const sitePublicationList = `{{define "publications"}}<ul>
+{{range .}}<li>{{if .Link}}<a href="{{.Link}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}<br>
+<span class="meta">{{range $i, $a := .Authors}}{{if $i}}, {{end}}{{if $a.Self}}<strong><a href="{{$a.Path}}">{{$a.Name}}</a></strong>{{else}}<a href="{{$a.Path}}">{{$a.Name}}</a>{{end}}{{end}}.
+{{with .Journal}}<a href="{{.Path}}"><i>{{.Name}}</i></a>{{end}}{{with .Year}} ({{.}}){{end}}{{with .Quartile}}, {{.}}{{end}}</span></li>
+{{end}}</ul>{{end}}`
+
+// The built-in page templates by file name, which --templates can override
+var siteTemplates = map[string]string{
+	"index.html": siteHead + `{{range .Years}}<h2>{{or .Year "Undated"}}</h2>
+{{template "publications" .Publications}}
+{{end}}<h2>Authors</h2>
+<ul>{{range .Authors}}<li><a href="{{.Path}}">{{.Name}}</a></li>{{end}}</ul>
+<h2>Journals</h2>
+<ul>{{range .Journals}}<li><a href="{{.Path}}">{{.Name}}</a></li>{{end}}</ul>
+</body>
+</html>
+` + sitePublicationList,
+
+	"author.html": siteHead + `{{template "publications" .Publications}}
+</body>
+</html>
+` + sitePublicationList,
+
+	"journal.html": siteHead + `{{with .Journal}}<table>
+<tr><th>ISSN</th><td>{{range $i, $issn := .ISSNs}}{{if $i}}, {{end}}{{$issn}}{{end}}</td></tr>
+{{with .Publisher}}<tr><th>Publisher</th><td>{{.}}{{with $.Journal.Country}} ({{.}}){{end}}</td></tr>
+{{end}}<tr><th>SJR ({{.Year}})</th><td>{{metric .SJR}}</td></tr>
+<tr><th>Quartile</th><td>{{if .Quartile}}Q{{.Quartile}}{{else}}unknown{{end}}</td></tr>
+<tr><th>h-index</th><td>{{.HIndex}}</td></tr>
+{{with .CiteScore}}<tr><th>CiteScore</th><td>{{metric .}}</td></tr>
+{{end}}{{with .SNIP}}<tr><th>SNIP</th><td>{{metric .}}</td></tr>
+{{end}}{{with .OpenAccessStatus}}<tr><th>Open access</th><td>{{.}}</td></tr>
+{{end}}</table>
+{{end}}{{template "publications" .Publications}}
+</body>
+</html>
+` + sitePublicationList,
+}
+
+// Functions available to the page templates
+var siteFuncs = template.FuncMap{
+	// A metric with three decimals, or "unknown" when it is nil
+	"metric": func(v *float64) string {
+		if v == nil {
+			return "unknown"
+		}
+		return formatOptional(v, 3)
+	},
+}
+
+// Parse the page templates, using the files in dir in place of the
+// built-in templates of the same name when dir is given
+func parseSiteTemplates(dir string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for name, text := range siteTemplates {
+		if dir != "" {
+			custom, err := os.ReadFile(filepath.Join(dir, name))
+			if err == nil {
+				text = string(custom)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		t, err := template.New(name).Funcs(siteFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template %s: %v", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// The heading a publication is listed under on the index page: its year,
+// or its status when it isn't out yet, which sorts above the years
+func siteYearOf(pub Publication) string {
+	if label := forthcomingLabel(pub); label != "" {
+		return label
+	}
+	if len(pub.Date) >= 4 {
+		return pub.Date[:4]
+	}
+	return ""
+}
+
+// A file name made of the lowercase letters and digits of s, with dashes
+// between words
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "unnamed"
+	}
+	return b.String()
+}
+
+// Write the site for the publications to dir: an index of publications by
+// year, newest first, and a page for each author and journal. Within a
+// year, publications keep their order in pubs. Authors are clustered as
+// by clusterAuthors, with confirmORCID, and those self matches are shown in
+// bold unless self is empty.
+func writeSite(dir, title string, pubs []Publication, db *MetricsDatabase, templates map[string]*template.Template, confirmORCID bool, self string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	pubs = append([]Publication(nil), pubs...)
+	sort.SliceStable(pubs, func(i, j int) bool {
+		return siteYearOf(pubs[i]) > siteYearOf(pubs[j])
+	})
+	assignCitationKeys(pubs)
+	byAuthor := map[string][]sitePublication{}
+	byJournal := map[string][]sitePublication{}
+	journalMetrics := map[string]*JournalMetrics{}
+	pageNames := map[string]string{}
+	clusters := clusterAuthors(pubs, confirmORCID)
+	matcher := parseSelf(self)
+	var years []siteYear
+
+	for _, pub := range pubs {
+		sp := sitePublication{Publication: pub, Key: createCitationKey(pub), Year: siteYearOf(pub), Link: pub.URL}
+		if pub.DOI != "" {
+			sp.Link = doiURL(pub.DOI)
+		}
+		if metrics, ok := db.LookupISSN(pub.ISSN); ok {
+			sp.Metrics = &metrics
+			sp.Quartile = formatQuartile(metrics.Quartile)
+		}
+
+		// Authors are identified by ORCID iD when they have one, and
+		// different spellings of a person's name share a page
+		for _, author := range pub.Authors.AuthorList {
+			family, given := normalizePersonName(author.Person.PersonName.FamilyNames, author.Person.PersonName.FirstNames)
+			name := strings.TrimSpace(given + " " + family)
+			id := slug(family + " " + given)
+			if cluster := clusters.of(author); cluster != nil {
+				name = cluster.displayName()
+				id = cluster.orcid
+				if id == "" {
+					id = slug(cluster.name.family + " " + cluster.name.given)
+				}
+			}
+			path := "author-" + id + ".html"
+			if _, ok := pageNames[path]; !ok {
+				pageNames[path] = name
+			}
+			sp.Authors = append(sp.Authors, siteLink{Name: name, Path: path, Self: self != "" && matcher.matches(author)})
+		}
+		if journal := strings.Join(strings.Fields(pub.Published.Publication.Title), " "); journal != "" {
+			path := "journal-" + slug(journal) + ".html"
+			if sp.Metrics != nil {
+				journal = sp.Metrics.Title
+				path = fmt.Sprintf("journal-%d.html", sp.Metrics.SourceID)
+				journalMetrics[path] = sp.Metrics
+			}
+			if _, ok := pageNames[path]; !ok {
+				pageNames[path] = journal
+			}
+			sp.Journal = &siteLink{Name: journal, Path: path}
+		}
+
+		listed := map[string]bool{}
+		for _, author := range sp.Authors {
+			if !listed[author.Path] {
+				listed[author.Path] = true
+				byAuthor[author.Path] = append(byAuthor[author.Path], sp)
+			}
+		}
+		if sp.Journal != nil {
+			byJournal[sp.Journal.Path] = append(byJournal[sp.Journal.Path], sp)
+		}
+		if len(years) == 0 || years[len(years)-1].Year != sp.Year {
+			years = append(years, siteYear{Year: sp.Year})
+		}
+		years[len(years)-1].Publications = append(years[len(years)-1].Publications, sp)
+	}
+
+	links := func(pages map[string][]sitePublication) []siteLink {
+		var out []siteLink
+		for path := range pages {
+			out = append(out, siteLink{Name: pageNames[path], Path: path})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		return out
+	}
+	write := func(path, templateName string, page sitePage) error {
+		file, err := createAtomicFile(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+		defer file.Abort()
+		page.SiteTitle = title
+		if err := templates[templateName].Execute(file, page); err != nil {
+			return fmt.Errorf("error rendering %s: %v", path, err)
+		}
+		return file.Commit()
+	}
+
+	if err := write("index.html", "index.html", sitePage{
+		Title:    title,
+		Years:    years,
+		Authors:  links(byAuthor),
+		Journals: links(byJournal),
+	}); err != nil {
+		return err
+	}
+	for path, pubs := range byAuthor {
+		if err := write(path, "author.html", sitePage{Title: pageNames[path], Publications: pubs}); err != nil {
+			return err
+		}
+	}
+	for path, pubs := range byJournal {
+		if err := write(path, "journal.html", sitePage{Title: pageNames[path], Journal: journalMetrics[path], Publications: pubs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The `site` subcommand: render the publications in an XML file as a
+// static website
+func runSite(args []string) {
+	fs := flag.NewFlagSet("site", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the config file (default "+defaultConfigPath()+")")
+	lenient := fs.Bool("lenient", false, "skip malformed CSV rows and XML records instead of aborting")
+	metricsPath := fs.String("metrics", "", "path to the impact factor csv, instead of passing it as an argument")
+	sources := metricsSourceFlags(fs)
+	outputDir := fs.String("o", "site", "directory to write the site to")
+	title := fs.String("title", "Publications", "title of the site")
+	templatesDir := fs.String("templates", "", "directory of index.html, author.html and journal.html templates to use instead of the built-in ones")
+	repoProfile := fs.String("repo-profile", "", "repository platform whose metadata quirks to handle: dspace, eprints, or pure")
+	metadataFormat := fs.String("metadata-format", "auto", "metadata format of the paper records: auto, cerif, datacite, mods, marcxml, csv, or doi")
+	crossrefFlags(fs)
+	network := networkFlags(fs)
+	confirmORCID := fs.Bool("confirm-orcid", false, "only give spellings of a name the page of an author with an ORCID iD, rather than of any author they likely are")
+	self := fs.String("self", "", "show this person, given as \"Family, Initials\" or an ORCID iD, in bold in the author lists")
+	fs.Usage = func() {
+		log.Printf("Usage: %s site [flags] <paper xml filename> [impact factor csv]", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyConfig(fs, "site", *configPath); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := network.apply(); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := applyRepoProfileFlags(fs, *repoProfile); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if !metadataFormats[*metadataFormat] {
+		log.Printf("Unknown metadata format %q", *metadataFormat)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	siteArgs := fs.Args()
+	if len(siteArgs) == 1 && *metricsPath != "" {
+		siteArgs = append(siteArgs, *metricsPath)
+	}
+	if len(siteArgs) != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	templates, err := parseSiteTemplates(*templatesDir)
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	journalDB, err := loadMetrics(siteArgs[1], *sources, *lenient)
+	if err != nil {
+		fatalf(inputExitCode(err), "%v", err)
+	}
+	xmlFile, err := os.Open(siteArgs[0])
+	if err != nil {
+		fatalf(exitError, "Error reading file: %v", err)
+	}
+	defer xmlFile.Close()
+	pubs, skipped, err := ReadPublications(xmlFile, *metadataFormat, *lenient)
+	if err != nil {
+		fatalf(exitParse, "Error parsing XML: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d malformed XML records", skipped)
+	}
+	if profile, ok := repoProfiles[*repoProfile]; ok {
+		applyRepoProfile(pubs, profile)
+	}
+
+	if err := writeSite(*outputDir, *title, pubs, journalDB, templates, *confirmORCID, *self); err != nil {
+		fatalf(exitError, "Error writing site: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// An output format defined by a text/template file given with --template.
+// The file is executed for each publication with a publicationJSON, the
+// same publication-plus-metrics data --format json writes, and may define
+// "begin" and "end" templates to write before and after the entries.
+type entryTemplate struct {
+	t *template.Template
+
+	mu  sync.Mutex
+	err error // the first error executing the template
+}
+
+// Functions available to --template files
+var entryTemplateFuncs = template.FuncMap{
+	"metric":   siteFuncs["metric"],
+	"quartile": formatQuartile,
+	"doiURL":   doiURL,
+	"join":     strings.Join,
+	"authors": func(pub publicationJSON) string {
+		return formatAuthors(pub.Authors.AuthorList, defaultBibtexOptions())
+	},
+	// s with the characters special to XML escaped
+	"xml": func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}
+
+// Parse the template file at path
+func parseEntryTemplate(path string) (*entryTemplate, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template: %v", err)
+	}
+	t, err := template.New(filepath.Base(path)).Funcs(entryTemplateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template: %v", err)
+	}
+	return &entryTemplate{t: t}, nil
+}
+
+// Execute the named template, recording the first error
+func (e *entryTemplate) execute(name string, data any) string {
+	var b strings.Builder
+	t := e.t
+	if name != "" {
+		if t = e.t.Lookup(name); t == nil {
+			return ""
+		}
+	}
+	if err := t.Execute(&b, data); err != nil {
+		e.mu.Lock()
+		if e.err == nil {
+			e.err = err
+		}
+		e.mu.Unlock()
+	}
+	return b.String()
+}
+
+// The output format rendering entries with the template
+func (e *entryTemplate) format() outputFormat {
+	return outputFormat{
+		Begin: e.execute("begin", nil),
+		Entry: func(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+			return e.execute("", publicationJSON{
+				Publication: pub,
+				CitationKey: createCitationKey(pub),
+				OrgUnits:    orgUnits(pub),
+				Metrics:     metrics,
+				Trend:       pub.Trend,
+			}), nil
+		},
+		End: e.execute("end", nil),
+	}
+}
+
+// The first error executing the template, if any
+func (e *entryTemplate) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
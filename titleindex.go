@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// A prefix index over the words of normalized journal titles, so title
+// searches only look at the titles that can match instead of every journal
+type titleIndex struct {
+	words  []string   // distinct title words, sorted
+	titles [][]string // the normalized titles containing each word
+}
+
+// Index the titles of the byTitle index
+func buildTitleIndex(byTitle map[string]int32) *titleIndex {
+	byWord := make(map[string][]string)
+	for title := range byTitle {
+		seen := make(map[string]bool)
+		for _, word := range strings.Fields(title) {
+			if !seen[word] {
+				seen[word] = true
+				byWord[word] = append(byWord[word], title)
+			}
+		}
+	}
+
+	index := &titleIndex{words: make([]string, 0, len(byWord))}
+	for word := range byWord {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+	index.titles = make([][]string, len(index.words))
+	for i, word := range index.words {
+		index.titles[i] = byWord[word]
+	}
+	return index
+}
+
+// The titles with a word starting with prefix
+func (ix *titleIndex) withPrefix(prefix string) []string {
+	start := sort.SearchStrings(ix.words, prefix)
+	end := start
+	for end < len(ix.words) && strings.HasPrefix(ix.words[end], prefix) {
+		end++
+	}
+	if end-start == 1 {
+		return ix.titles[start]
+	}
+
+	// Titles may contain several words with the prefix
+	seen := make(map[string]bool)
+	var titles []string
+	for _, group := range ix.titles[start:end] {
+		for _, title := range group {
+			if !seen[title] {
+				seen[title] = true
+				titles = append(titles, title)
+			}
+		}
+	}
+	return titles
+}
+
+// The title index, built on first use after the titles last changed. The
+// caller must hold at least a read lock; titleIndexMu keeps concurrent
+// readers from building it twice.
+func (db *MetricsDatabase) prefixIndex() *titleIndex {
+	db.titleIndexMu.Lock()
+	defer db.titleIndexMu.Unlock()
+	if db.titleIndex == nil {
+		db.titleIndex = buildTitleIndex(db.byTitle)
+	}
+	return db.titleIndex
+}
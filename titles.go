@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kljensen/impact-factor-lookup/bibtex"
+)
+
+// How titles are protected from the case changes of BibTeX styles, which
+// lowercase titles for sentence case unless they are braced
+var titleProtections = map[string]bool{
+	"all":   true, // brace the whole title, so it keeps its case as written
+	"words": true, // brace only what must keep its case: acronyms, formulas, proper nouns and math
+	"none":  true, // leave the title's case to the style
+}
+
+// How --subtitle puts a publication's subtitle in its entry
+var subtitleStyles = map[string]bool{
+	"drop":   true, // leave it out
+	"append": true, // append it to the title, as "Title: Subtitle"
+	"field":  true, // write it in a BibLaTeX subtitle field
+}
+
+// The title to write for a publication: with its subtitle appended when
+// the subtitle style is "append". A colon separates them unless the title
+// ends in a question mark, exclamation mark or colon of its own.
+func displayTitle(pub Publication, opts bibtexOptions) string {
+	subtitle := strings.TrimSpace(pub.Subtitle)
+	if opts.SubtitleStyle != "append" || subtitle == "" || pub.Title == "" {
+		return pub.Title
+	}
+	title := strings.TrimSpace(pub.Title)
+	if strings.HasSuffix(title, "?") || strings.HasSuffix(title, "!") || strings.HasSuffix(title, ":") {
+		return title + " " + subtitle
+	}
+	return title + ": " + subtitle
+}
+
+// Words to brace in titles under --title-protection words, such as proper
+// nouns the heuristics miss in Title Case titles, loaded by
+// loadProtectedWords
+var protectedWords = map[string]bool{}
+
+// Load a --protected-words file: one word per line, as it should be
+// capitalized, e.g. "Copenhagen" or "Bayesian". Blank lines and lines
+// starting with # are skipped.
+func loadProtectedWords(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening protected words: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			protectedWords[word] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Longer words that Title Case leaves lowercase
+var titleCaseMinorWords = map[string]bool{
+	"about": true, "from": true, "into": true, "onto": true, "over": true,
+	"than": true, "that": true, "upon": true, "with": true, "versus": true,
+	"between": true, "through": true, "under": true, "without": true,
+}
+
+// Whether a title is in sentence case rather than Title Case: whether any
+// of its words that Title Case would capitalize is lowercase. Capitalized
+// words in such titles are taken to be proper nouns.
+func isSentenceCase(words []string) bool {
+	for _, word := range words {
+		runes := []rune(word)
+		if len(runes) > 3 && unicode.IsLower(runes[0]) && !titleCaseMinorWords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a title word must keep its case: words in --protected-words, words
+// with capitals after their first letter (DNA, mRNA, H2O, McDonald), and
+// in sentence case titles, capitalized words other than the first of the
+// title or of a subtitle
+func needsProtection(word string, first, sentenceCase bool) bool {
+	if protectedWords[word] {
+		return true
+	}
+	runes := []rune(word)
+	for _, r := range runes[1:] {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return sentenceCase && !first && unicode.IsUpper(runes[0])
+}
+
+// Whether a rune is part of a title word, which may have inner hyphens and
+// apostrophes ("CRISPR-Cas9", "Crohn's")
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '\'' || r == '’'
+}
+
+// The length of the escape or command s starts with, e.g. 2 for \{ and
+// 15 for \textasciitilde, so title protection steps over it whole
+func latexCommandLength(s string) int {
+	n := min(2, len(s))
+	if n == 2 && s[1] < utf8.RuneSelf && unicode.IsLetter(rune(s[1])) {
+		for n < len(s) && s[n] < utf8.RuneSelf && unicode.IsLetter(rune(s[n])) {
+			n++
+		}
+	}
+	return n
+}
+
+// The title as the BibTeX title field, protected according to one of
+// titleProtections. With "words", inline math is braced whole, groups the
+// title already braces are kept as they are, and each word that
+// needsProtection is braced.
+func protectTitle(title, mode string) string {
+	if mode == "none" {
+		return title
+	}
+	if mode != "words" {
+		return "{" + title + "}"
+	}
+
+	// The words outside braces and math tell the case of the title
+	var plain strings.Builder
+	depth, last := 0, 0
+	for _, span := range bibtex.MathSpans(title) {
+		plain.WriteString(title[last:span[0]] + " ")
+		last = span[1]
+	}
+	text := plain.String() + title[last:]
+	plain.Reset()
+	for i := 0; i < len(text); i++ {
+		switch r := text[i]; {
+		case r == '\\':
+			i += latexCommandLength(text[i:]) - 1
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case depth == 0:
+			plain.WriteByte(r)
+		}
+	}
+	var words []string
+	for _, word := range strings.FieldsFunc(plain.String(), func(r rune) bool { return !isWordRune(r) }) {
+		words = append(words, strings.Trim(word, "-'’"))
+	}
+	sentenceCase := isSentenceCase(words)
+
+	var b strings.Builder
+	spans := bibtex.MathSpans(title)
+	first := true
+	for i := 0; i < len(title); {
+		if len(spans) > 0 && i == spans[0][0] && depth == 0 {
+			b.WriteString("{" + title[spans[0][0]:spans[0][1]] + "}")
+			i = spans[0][1]
+			spans = spans[1:]
+			first = false
+			continue
+		}
+		for len(spans) > 0 && spans[0][0] < i {
+			spans = spans[1:]
+		}
+		r, size := utf8.DecodeRuneInString(title[i:])
+		switch {
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case r == '\\':
+			size = latexCommandLength(title[i:])
+		case depth == 0 && isWordRune(r):
+			end := i
+			for end < len(title) {
+				next, nextSize := utf8.DecodeRuneInString(title[end:])
+				if !isWordRune(next) {
+					break
+				}
+				end += nextSize
+			}
+			word := title[i:end]
+			trimmed := strings.TrimRight(word, "-'’")
+			if trimmed != "" && needsProtection(trimmed, first, sentenceCase) {
+				b.WriteString("{" + trimmed + "}" + word[len(trimmed):])
+			} else {
+				b.WriteString(word)
+			}
+			if trimmed != "" {
+				first = false
+			}
+			i = end
+			continue
+		case depth == 0 && (r == ':' || r == '?' || r == '!' || r == '.'):
+			// A subtitle or a new sentence starts with a capital
+			b.WriteRune(r)
+			i += size
+			first = true
+			continue
+		}
+		b.WriteString(title[i : i+size])
+		i += size
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Number of years of SJR history included in a trend
+const trendYears = 5
+
+// The SJR of a journal in one year
+type SJRPoint struct {
+	Year int64   `json:"year"`
+	SJR  float64 `json:"sjr"`
+}
+
+// How a journal's SJR has developed over its most recent years, and the
+// change from the first to the last of those years
+type SJRTrend struct {
+	Points []SJRPoint `json:"points"`
+	Delta  float64    `json:"delta"`
+}
+
+// The SJR trend over the last five years of data for the journal with the
+// given SCImago source ID. Returns nil unless at least two years of SJR
+// values are loaded, so single-year databases have no trends.
+func (db *MetricsDatabase) SJRTrend(sourceID int64) *SJRTrend {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sjrTrend(sourceID)
+}
+
+func (db *MetricsDatabase) sjrTrend(sourceID int64) *SJRTrend {
+	years := db.sjrByID[sourceID]
+	if len(years) < 2 {
+		return nil
+	}
+
+	points := make([]SJRPoint, 0, len(years))
+	for year, sjr := range years {
+		points = append(points, SJRPoint{Year: year, SJR: sjr})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Year < points[j].Year
+	})
+	if len(points) > trendYears {
+		points = points[len(points)-trendYears:]
+	}
+
+	return &SJRTrend{
+		Points: points,
+		// Rounded to the precision SCImago publishes SJR values with
+		Delta: math.Round((points[len(points)-1].SJR-points[0].SJR)*1000) / 1000,
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// How often one journal appears in a set of publications
+type VenueCount struct {
+	Journal      string    `json:"journal"`
+	ISSN         string    `json:"issn,omitempty"`
+	Publications int       `json:"publications"`
+	Trend        *SJRTrend `json:"trend,omitempty"` // of journals with two or more years of SJR values
+}
+
+// Where a set of publications appeared, and how spread out that is
+type VenueReport struct {
+	Venues       []VenueCount `json:"venues"` // most frequent first
+	Unique       int          `json:"unique"`
+	Gini         float64      `json:"gini"`          // 0 when publications are spread evenly over the venues, towards 1 when a few venues dominate
+	WithoutVenue int          `json:"without_venue"` // publications without a journal
+
+	// The publishers of the journals, when the metrics CSV has them
+	Publishers    []PublisherCount `json:"publishers,omitempty"` // most frequent first
+	PublisherGini float64          `json:"publisher_gini,omitempty"`
+}
+
+// Count the publications in each journal. Journals with metrics are
+// identified by their SCImago record, so a journal's ISSNs and title
+// variants count together, and others by their normalized title.
+func buildVenueReport(pubs []Publication, db *MetricsDatabase) *VenueReport {
+	report := &VenueReport{}
+	counts := map[string]*VenueCount{}
+	var keys []string
+	for _, pub := range pubs {
+		venue := VenueCount{Journal: strings.Join(strings.Fields(pub.Published.Publication.Title), " "), ISSN: pub.ISSN}
+		key := normalizeTitle(venue.Journal)
+		if metrics, ok := db.LookupISSN(pub.ISSN); ok {
+			venue.Journal = metrics.Title
+			venue.Trend = db.SJRTrend(metrics.SourceID)
+			key = fmt.Sprintf("sourceid:%d", metrics.SourceID)
+		}
+		if key == "" {
+			report.WithoutVenue++
+			continue
+		}
+		if counts[key] == nil {
+			counts[key] = &venue
+			keys = append(keys, key)
+		}
+		counts[key].Publications++
+	}
+
+	for _, key := range keys {
+		report.Venues = append(report.Venues, *counts[key])
+	}
+	sort.SliceStable(report.Venues, func(i, j int) bool {
+		if report.Venues[i].Publications != report.Venues[j].Publications {
+			return report.Venues[i].Publications > report.Venues[j].Publications
+		}
+		return report.Venues[i].Journal < report.Venues[j].Journal
+	})
+	report.Unique = len(report.Venues)
+
+	frequencies := make([]int, len(report.Venues))
+	for i, venue := range report.Venues {
+		frequencies[i] = venue.Publications
+	}
+	report.Gini = gini(frequencies)
+	report.Publishers, report.PublisherGini = countPublishers(pubs, db)
+	return report
+}
+
+// The Gini coefficient of the values, or 0 when there are none
+func gini(values []int) float64 {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	var sum, weighted float64
+	for i, v := range sorted {
+		sum += float64(v)
+		weighted += float64(i+1) * float64(v)
+	}
+	if sum == 0 {
+		return 0
+	}
+	n := float64(len(sorted))
+	return 2*weighted/(n*sum) - (n+1)/n
+}
+
+// Write the venue frequencies and diversity as aligned text
+func writeVenueReportText(w io.Writer, venues *VenueReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Unique venues:\t%d\n", venues.Unique)
+	fmt.Fprintf(tw, "Venue Gini coefficient:\t%.3f\n", venues.Gini)
+	if venues.WithoutVenue > 0 {
+		fmt.Fprintf(tw, "Without a venue:\t%d\n", venues.WithoutVenue)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "Journal\tISSN\tPublications\tSJR trend")
+	for _, venue := range venues.Venues {
+		trend := ""
+		if venue.Trend != nil {
+			trend = fmt.Sprintf("%+.3f since %d", venue.Trend.Delta, venue.Trend.Points[0].Year)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", venue.Journal, venue.ISSN, venue.Publications, trend)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(venues.Publishers) > 0 {
+		fmt.Fprintln(w)
+		return writePublisherCountsText(w, venues.Publishers, venues.PublisherGini)
+	}
+	return nil
+}
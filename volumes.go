@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Labels repositories put before volume and issue numbers
+var (
+	volumeLabel = regexp.MustCompile(`(?i)^(?:volume\b|vol\b\.?|v\.)\s*`)
+	issueLabel  = regexp.MustCompile(`(?i)^(?:number\b|issue\b|iss\.|no\b\.?|nr\b\.?|n\.)\s*`)
+)
+
+// Volumes that carry their issue: "12 (3)", "12, no. 3", "12 Suppl 1"
+var (
+	volumeWithIssue      = regexp.MustCompile(`^(\w+)\s*\(([^()]+)\)$`)
+	volumeWithNumber     = regexp.MustCompile(`(?i)^(\w+)\s*,?\s*(?:number\b|issue\b|iss\.|no\b\.?|nr\b\.?)\s*(\S+)$`)
+	volumeWithSupplement = regexp.MustCompile(`(?i)^(\w+)\s*,?\s*((?:supplement|suppl\.?)\s*\S*)$`)
+)
+
+// A supplement issue: "Suppl 1", "Supplement 2", "S1"
+var supplementIssue = regexp.MustCompile(`(?i)^(?:(?:supplement|suppl\.?)\s*(\w*)|s(\d+))$`)
+
+// Clean up an issue number: drop its label and parentheses, and write
+// supplements as "Suppl. 1"
+func normalizeIssue(issue string) string {
+	issue = strings.Join(strings.Fields(issue), " ")
+	issue = strings.TrimSpace(issueLabel.ReplaceAllString(issue, ""))
+	if strings.HasPrefix(issue, "(") && strings.HasSuffix(issue, ")") {
+		issue = strings.TrimSpace(issue[1 : len(issue)-1])
+	}
+	if match := supplementIssue.FindStringSubmatch(issue); match != nil {
+		return strings.TrimSpace("Suppl. " + match[1] + match[2])
+	}
+	return issue
+}
+
+// Split noisy volume and issue values into a clean volume and issue. The
+// volume may carry the issue ("12 (3)", "12, no. 3", "12 Suppl 1"), which
+// fills an empty issue. When it names another issue than the one given,
+// the given issue is kept and the values as given are returned as a note,
+// since it can't be told which is right. Values that match none of the
+// patterns are only trimmed.
+func normalizeVolumeIssue(volume, issue string) (string, string, string) {
+	givenVolume, givenIssue := volume, issue
+	volume = strings.Join(strings.Fields(volume), " ")
+	volume = strings.TrimSpace(volumeLabel.ReplaceAllString(volume, ""))
+	issue = normalizeIssue(issue)
+
+	var carried string
+	for _, pattern := range []*regexp.Regexp{volumeWithIssue, volumeWithNumber, volumeWithSupplement} {
+		if match := pattern.FindStringSubmatch(volume); match != nil {
+			volume, carried = match[1], normalizeIssue(match[2])
+			break
+		}
+	}
+	switch {
+	case carried == "" || carried == issue:
+	case issue == "":
+		issue = carried
+	default:
+		return volume, issue, fmt.Sprintf("Volume and issue as given: %s; %s", strings.TrimSpace(givenVolume), strings.TrimSpace(givenIssue))
+	}
+	return volume, issue, ""
+}
+
+// Clean up the volumes and issues of the publications with
+// normalizeVolumeIssue
+func normalizeVolumes(pubs []Publication) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if pub.Volume == "" && pub.Issue == "" {
+			continue
+		}
+		pub.Volume, pub.Issue, pub.VolumeNote = normalizeVolumeIssue(pub.Volume, pub.Issue)
+	}
+}
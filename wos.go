@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Base URL of the Clarivate Web of Science Starter API
+var wosAPIURL = "https://api.clarivate.com/apis/wos-starter/v1/"
+
+// Client for Web of Science API requests
+var wosClient = &http.Client{Timeout: 30 * time.Second}
+
+// The Clarivate API key given with --wos-key
+var wosKey string
+
+// Define the --wos-key flag of a command that may call Web of Science
+func wosFlags(fs *flag.FlagSet) {
+	fs.StringVar(&wosKey, "wos-key", "", "Clarivate Web of Science Starter API key for --wos, instead of one stored with config set-key wos")
+}
+
+// The API key for Web of Science requests, or an error when none is given
+// or stored
+func wosAPIKey() (string, error) {
+	key := apiKey("wos", wosKey)
+	if key == "" {
+		return "", fmt.Errorf("--wos needs a Web of Science Starter API key; pass --wos-key or store one with config set-key wos")
+	}
+	return key, nil
+}
+
+// Search a Web of Science Starter API endpoint, such as "documents", and
+// decode the response into response
+func fetchWoS(endpoint string, query url.Values, response any) error {
+	key, err := wosAPIKey()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", wosAPIURL+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-ApiKey", key)
+	resp, err := wosClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("error parsing Web of Science response: %v", err)
+	}
+	return nil
+}
+
+// The parts of a Web of Science document record that are used here
+type wosDocument struct {
+	UID    string `json:"uid"` // accession number, e.g. WOS:000123456700001
+	Source struct {
+		Title  string `json:"sourceTitle"`
+		Year   int    `json:"publishYear"`
+		Volume string `json:"volume"`
+		Issue  string `json:"issue"`
+	} `json:"source"`
+	Citations []struct {
+		DB    string `json:"db"`
+		Count int    `json:"count"`
+	} `json:"citations"`
+	Identifiers struct {
+		DOI   string `json:"doi"`
+		ISSN  string `json:"issn"`
+		EISSN string `json:"eissn"`
+	} `json:"identifiers"`
+}
+
+// Fetch the Web of Science Core Collection record of a DOI. Returns nil if
+// Web of Science doesn't index the DOI.
+func fetchWoSDocument(doi string) (*wosDocument, error) {
+	var response struct {
+		Hits []wosDocument `json:"hits"`
+	}
+	query := url.Values{"db": {"WOS"}, "q": {"DO=(" + doiName(doi) + ")"}, "limit": {"1"}}
+	if err := fetchWoS("documents", query, &response); err != nil || len(response.Hits) == 0 {
+		return nil, err
+	}
+	return &response.Hits[0], nil
+}
+
+// Fill in what the publication's record lacks from its Web of Science
+// record: the journal, ISSN, year, volume, issue and citation count, and
+// its accession number
+func (d wosDocument) fill(pub *Publication) {
+	pub.WoSID = d.UID
+	if pub.Published.Publication.Title == "" {
+		pub.Published.Publication.Title = d.Source.Title
+	}
+	if pub.ISSN == "" {
+		pub.ISSN = d.Identifiers.ISSN
+	}
+	if pub.ISSN == "" {
+		pub.ISSN = d.Identifiers.EISSN
+	}
+	if pub.Date == "" && d.Source.Year > 0 {
+		pub.Date = strconv.Itoa(d.Source.Year)
+	}
+	if pub.Volume == "" {
+		pub.Volume = d.Source.Volume
+	}
+	if pub.Issue == "" {
+		pub.Issue = d.Source.Issue
+	}
+	for _, c := range d.Citations {
+		if strings.EqualFold(c.DB, "WOS") && pub.Citations == nil {
+			count := c.Count
+			pub.Citations = &count
+		}
+	}
+}
+
+// Whether Web of Science indexes the journal with an ISSN, i.e. whether it
+// is a journal Clarivate's Journal Citation Reports may cover
+func fetchWoSJournalIndexed(issn string) (bool, error) {
+	var response struct {
+		Hits []struct {
+			ID string `json:"id"`
+		} `json:"hits"`
+	}
+	if err := fetchWoS("journals", url.Values{"issn": {formatISSN(normalizeISSN(issn))}}, &response); err != nil {
+		return false, err
+	}
+	return len(response.Hits) > 0, nil
+}
+
+// Look the publications and their journals up on Web of Science: fill in
+// the article metadata and citation counts of publications with a DOI,
+// and whether Web of Science indexes the journal of each publication with
+// an ISSN. Lookup failures are logged and leave the publication as it was.
+func enrichFromWoS(pubs []Publication) {
+	for i := range pubs {
+		pub := &pubs[i]
+		if pub.DOI == "" {
+			continue
+		}
+		doc, err := fetchWoSDocument(pub.DOI)
+		if err != nil {
+			log.Printf("Warning: looking up %s on Web of Science: %v", pub.DOI, err)
+			continue
+		}
+		if doc != nil {
+			doc.fill(pub)
+		}
+	}
+
+	indexed := map[string]*bool{}
+	for i := range pubs {
+		pub := &pubs[i]
+		issn := normalizeISSN(pub.ISSN)
+		if issn == "" {
+			continue
+		}
+		if _, ok := indexed[issn]; !ok {
+			found, err := fetchWoSJournalIndexed(issn)
+			if err != nil {
+				log.Printf("Warning: looking up ISSN %s on Web of Science: %v", formatISSN(issn), err)
+			}
+			indexed[issn] = nil
+			if err == nil {
+				indexed[issn] = &found
+			}
+		}
+		pub.WoSIndexed = indexed[issn]
+	}
+}
+
+// The Web of Science coverage of a set of publications
+type WoSSummary struct {
+	Publications int `json:"publications"` // publications whose journal was looked up
+	Records      int `json:"records"`      // publications with a Web of Science record
+	Indexed      int `json:"indexed"`      // publications in journals Web of Science indexes
+}
+
+// Count the publications Web of Science has records of and indexes the
+// journals of. Returns nil when none of them was looked up.
+func summarizeWoS(pubs []Publication) *WoSSummary {
+	summary := &WoSSummary{}
+	for _, pub := range pubs {
+		if pub.WoSID != "" {
+			summary.Records++
+		}
+		if pub.WoSIndexed == nil {
+			continue
+		}
+		summary.Publications++
+		if *pub.WoSIndexed {
+			summary.Indexed++
+		}
+	}
+	if summary.Publications == 0 && summary.Records == 0 {
+		return nil
+	}
+	return summary
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// The start of a Zotero RDF document, declaring the vocabularies Zotero
+// uses in its own RDF exports
+const zoteroRDFHeader = `<rdf:RDF
+ xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+ xmlns:z="http://www.zotero.org/namespaces/export#"
+ xmlns:dc="http://purl.org/dc/elements/1.1/"
+ xmlns:dcterms="http://purl.org/dc/terms/"
+ xmlns:bib="http://purl.org/net/biblio#"
+ xmlns:foaf="http://xmlns.com/foaf/0.1/"
+ xmlns:prism="http://prismstandard.org/namespaces/1.2/basic/">
+`
+
+const zoteroRDFFooter = "</rdf:RDF>\n"
+
+// Escape text for use in XML content and attributes
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Convert a publication to a Zotero RDF item: a bib:Article for journal
+// articles, or a dataset, preprint or document for DataCite records that
+// aren't articles. The journal metrics go in the Extra field
+// (dc:description), one "Name: value" line each.
+func toZoteroRDF(pub Publication, metrics *JournalMetrics, opts bibtexOptions) (string, error) {
+	var rdf strings.Builder
+	line := func(indent int, format string, args ...any) {
+		rdf.WriteString(strings.Repeat("    ", indent))
+		fmt.Fprintf(&rdf, format, args...)
+		rdf.WriteString("\n")
+	}
+
+	about := "#" + createCitationKey(pub)
+	if pub.DOI != "" {
+		about = doiURL(pub.DOI)
+	}
+	element, itemType := "bib:Article", "journalArticle"
+	switch {
+	case pub.EntryType == "dataset":
+		element, itemType = "bib:Data", "dataset"
+	case isUnpublishedPreprint(pub):
+		element, itemType = "rdf:Description", "preprint"
+	case pub.EntryType != "":
+		element, itemType = "bib:Document", "document"
+	}
+	line(1, `<%s rdf:about="%s">`, element, xmlEscape(about))
+	line(2, "<z:itemType>%s</z:itemType>", itemType)
+	if pub.Publisher != "" {
+		line(2, "<dc:publisher>")
+		line(3, "<foaf:Organization>")
+		line(4, "<foaf:name>%s</foaf:name>", xmlEscape(pub.Publisher))
+		line(3, "</foaf:Organization>")
+		line(2, "</dc:publisher>")
+	}
+
+	// The journal, with the issue-level details. Other items only carry
+	// their DOI.
+	if itemType == "journalArticle" {
+		line(2, "<dcterms:isPartOf>")
+		line(3, "<bib:Journal>")
+		if pub.Volume != "" {
+			line(4, "<prism:volume>%s</prism:volume>", xmlEscape(pub.Volume))
+		}
+		if pub.Issue != "" {
+			line(4, "<prism:number>%s</prism:number>", xmlEscape(pub.Issue))
+		}
+		if journal := pub.Published.Publication.Title; journal != "" {
+			line(4, "<dc:title>%s</dc:title>", xmlEscape(journal))
+			if opts.JournalStyle != "full" {
+				line(4, "<dcterms:alternative>%s</dcterms:alternative>", xmlEscape(abbreviateJournalTitle(journal, opts.JournalStyle)))
+			}
+		}
+		if pub.ISSN != "" {
+			line(4, "<dc:identifier>ISSN %s</dc:identifier>", xmlEscape(pub.ISSN))
+		}
+		if pub.DOI != "" {
+			line(4, "<dc:identifier>DOI %s</dc:identifier>", xmlEscape(pub.DOI))
+		}
+		line(3, "</bib:Journal>")
+		line(2, "</dcterms:isPartOf>")
+	} else if pub.DOI != "" {
+		line(2, "<dc:identifier>DOI %s</dc:identifier>", xmlEscape(pub.DOI))
+	}
+
+	if len(pub.Authors.AuthorList) > 0 {
+		line(2, "<bib:authors>")
+		line(3, "<rdf:Seq>")
+		for _, author := range namedAuthors(pub.Authors.AuthorList) {
+			family := author.Person.PersonName.FamilyNames
+			given := author.Person.PersonName.FirstNames
+			// Organizations are left alone; Zotero takes a lone
+			// surname as a single-field name
+			if opts.NormalizeAuthors && !author.Person.Organization {
+				family, given = normalizePersonName(family, given)
+			}
+			if opts.AuthorStyle == "initials" && !author.Person.Organization {
+				given = initials(given)
+			}
+			line(4, "<rdf:li>")
+			line(5, "<foaf:Person>")
+			line(6, "<foaf:surname>%s</foaf:surname>", xmlEscape(family))
+			if given != "" {
+				line(6, "<foaf:givenName>%s</foaf:givenName>", xmlEscape(given))
+			}
+			line(5, "</foaf:Person>")
+			line(4, "</rdf:li>")
+		}
+		line(3, "</rdf:Seq>")
+		line(2, "</bib:authors>")
+	}
+
+	var keywords []string
+	if opts.Keywords {
+		keywords = append(keywords, pub.Keywords...)
+	}
+	if opts.SubjectKeywords && metrics != nil {
+		keywords = append(keywords, subjectKeywords(*metrics)...)
+	}
+	for _, keyword := range cleanKeywords(keywords) {
+		line(2, "<dc:subject>%s</dc:subject>", xmlEscape(keyword))
+	}
+
+	if pub.Title != "" {
+		line(2, "<dc:title>%s</dc:title>", xmlEscape(displayTitle(pub, opts)))
+	}
+	if opts.Abstracts && pub.Abstract != "" {
+		line(2, "<dcterms:abstract>%s</dcterms:abstract>", xmlEscape(strings.Join(strings.Fields(pub.Abstract), " ")))
+	}
+	if pub.Date != "" {
+		line(2, "<dc:date>%s</dc:date>", xmlEscape(pub.Date))
+	}
+	if pub.Language != "" {
+		line(2, "<z:language>%s</z:language>", xmlEscape(pub.Language))
+	}
+
+	url := pub.URL
+	if url == "" && pub.DOI != "" && opts.URLFromDOI {
+		url = doiURL(pub.DOI)
+	}
+	if url != "" {
+		line(2, "<dc:identifier>")
+		line(3, "<dcterms:URI>")
+		line(4, "<rdf:value>%s</rdf:value>", xmlEscape(url))
+		line(3, "</dcterms:URI>")
+		line(2, "</dc:identifier>")
+	}
+
+	if extra := zoteroExtra(metrics, opts); extra != "" {
+		line(2, "<dc:description>%s</dc:description>", xmlEscape(extra))
+	}
+	line(1, "</%s>", element)
+	return rdf.String(), nil
+}
+
+// The journal metrics as lines of Zotero's Extra field
+func zoteroExtra(metrics *JournalMetrics, opts bibtexOptions) string {
+	if metrics == nil {
+		return ""
+	}
+	var lines []string
+	add := func(name string, v *float64) {
+		if v != nil {
+			lines = append(lines, name+": "+formatOptional(v, opts.MetricPrecision))
+		}
+	}
+	add("SJR", metrics.SJR)
+	add("Average citations", metrics.AvgCitations)
+	lines = append(lines, fmt.Sprintf("h-index: %d", metrics.HIndex))
+	if q := formatQuartile(metrics.Quartile); q != "" {
+		lines = append(lines, "Quartile: "+q)
+	}
+	add("SJR percentile", metrics.SJRPercentile)
+	add("h-index percentile", metrics.HIndexPercentile)
+	add("Field-normalized citations", metrics.FieldNormalizedCitations)
+	add("CiteScore", metrics.CiteScore)
+	add("SNIP", metrics.SNIP)
+	add("CiteScore percentile", metrics.CiteScorePercentile)
+	add("Eigenfactor", metrics.Eigenfactor)
+	add("Article Influence", metrics.ArticleInfluence)
+	if metrics.Publisher != "" {
+		lines = append(lines, "Journal publisher: "+metrics.Publisher)
+	}
+	if metrics.Country != "" {
+		lines = append(lines, "Journal country: "+metrics.Country)
+	}
+	if oa := metrics.OpenAccessStatus(); oa != "" {
+		lines = append(lines, "Journal open access: "+oa)
+	}
+	return strings.Join(lines, "\n")
+}